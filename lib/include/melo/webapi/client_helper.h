@@ -37,24 +37,29 @@ class ClientHelper {
    * otherwise
    * @param[out] method The HTTP method to use
    * @param[out] url The HTTP URL to use
+   * @param[out] authorization The HTTP Authorization header to set (empty if
+   * the device has no token yet)
    * @param[out] body The HTTP request body to use
    */
   static void create_add_device(const Device &dev, bool full,
                                 std::string &method, std::string &url,
+                                std::string &authorization,
                                 std::string &body);
 
   /**
    * Parse response from adding / updating a device.
    *
+   * On first registration, the token issued by the Web API is stored in the
+   * device: it is required by all the next requests of the device.
+   *
+   * @param[in,out] dev The device
    * @param[in] code The HTTP response code
    * @param[in] body The HTTP response body to parse
    * @param[out] error The optional returned by the parser
    * @return `true` if the device has been added / updated, `false` otherswise.
    */
-  static inline bool parse_add_device(int code, const std::string &body,
-                                      std::string *error = nullptr) {
-    return generic_parse(code, body, error);
-  }
+  static bool parse_add_device(Device &dev, int code, const std::string &body,
+                               std::string *error = nullptr);
 
   /**
    * Create request to remove a device.
@@ -62,9 +67,12 @@ class ClientHelper {
    * @param[in] dev The device
    * @param[out] method The HTTP method to use
    * @param[out] url The HTTP URL to use
+   * @param[out] authorization The HTTP Authorization header to set (empty if
+   * the device has no token yet)
    */
   static void create_remove_device(const Device &dev, std::string &method,
-                                   std::string &url);
+                                   std::string &url,
+                                   std::string &authorization);
 
   /**
    * Parse response from removing a device.
@@ -86,10 +94,13 @@ class ClientHelper {
    * @param[in] online Set to `true` if the device is online, `false` otherwise
    * @param[out] method The HTTP method to use
    * @param[out] url The HTTP URL to use
+   * @param[out] authorization The HTTP Authorization header to set (empty if
+   * the device has no token yet)
    */
   static void create_update_device_online_status(const Device &dev, bool online,
                                                  std::string &method,
-                                                 std::string &url);
+                                                 std::string &url,
+                                                 std::string &authorization);
 
   /**
    * Parse response from updating online status of a device.
@@ -111,11 +122,14 @@ class ClientHelper {
    * @param[in] iface The device interface
    * @param[out] method The HTTP method to use
    * @param[out] url The HTTP URL to use
+   * @param[out] authorization The HTTP Authorization header to set (empty if
+   * the device has no token yet)
    * @param[out] body The HTTP request body to use
    */
   static void create_add_device_interface(const Device &dev,
                                           const Device::Interface &iface,
                                           std::string &method, std::string &url,
+                                          std::string &authorization,
                                           std::string &body);
 
   /**
@@ -140,11 +154,13 @@ class ClientHelper {
    * @param[in] iface The device interface
    * @param[out] method The HTTP method to use
    * @param[out] url The HTTP URL to use
+   * @param[out] authorization The HTTP Authorization header to set (empty if
+   * the device has no token yet)
    */
   static inline void create_remove_device_interface(
       const Device &dev, const Device::Interface &iface, std::string &method,
-      std::string &url) {
-    create_remove_device_interface(dev, iface.mac, method, url);
+      std::string &url, std::string &authorization) {
+    create_remove_device_interface(dev, iface.mac, method, url, authorization);
   }
 
   /**
@@ -154,11 +170,14 @@ class ClientHelper {
    * @param[in] mac The device interface MAC address
    * @param[out] method The HTTP method to use
    * @param[out] url The HTTP URL to use
+   * @param[out] authorization The HTTP Authorization header to set (empty if
+   * the device has no token yet)
    */
   static void create_remove_device_interface(const Device &dev,
                                              const std::string &mac,
                                              std::string &method,
-                                             std::string &url);
+                                             std::string &url,
+                                             std::string &authorization);
 
   /**
    * Parse response from removing a device interface.
@@ -174,6 +193,9 @@ class ClientHelper {
   }
 
  private:
+  static void create_authorization(const Device &dev,
+                                   std::string &authorization);
+
   static bool generic_parse(int code, const std::string &body,
                             std::string *error);
 
@@ -121,6 +121,24 @@ class Device {
    */
   inline void update_description(Descriptor desc) { desc_ = std::move(desc); }
 
+  /**
+   * Get secret token of the device.
+   *
+   * @return the token issued by the Web API on first registration, or an empty
+   * string if not yet registered.
+   */
+  [[nodiscard]] inline const std::string &get_token() const { return token_; }
+
+  /**
+   * Set secret token of the device.
+   *
+   * The token is issued only once by the Web API: it should be persisted and
+   * restored with this function when the device restarts.
+   *
+   * @param[in] token The token of the device
+   */
+  inline void set_token(std::string token) { token_ = std::move(token); }
+
   /**
    * Get interface list.
    *
@@ -208,6 +226,7 @@ class Device {
  private:
   Descriptor desc_;                //!< Description of the device
   std::vector<Interface> ifaces_;  //!< Interfaces of the device
+  std::string token_;              //!< Secret token of the device
 };
 
 }  // namespace melo::webapi
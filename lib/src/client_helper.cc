@@ -20,11 +20,21 @@ static nlohmann::json interface_to_json(const Device::Interface &iface) {
   return obj;
 }
 
+void ClientHelper::create_authorization(const Device &dev,
+                                        std::string &authorization) {
+  authorization.clear();
+  if (!dev.get_token().empty()) {
+    authorization = fmt::format("Bearer {}", dev.get_token());
+  }
+}
+
 void ClientHelper::create_add_device(const Device &dev, bool full,
                                      std::string &method, std::string &url,
+                                     std::string &authorization,
                                      std::string &body) {
   method = "PUT";
   url = "/device/add";
+  create_authorization(dev, authorization);
 
   auto &desc = dev.get_description();
   nlohmann::json req = {
@@ -52,28 +62,56 @@ void ClientHelper::create_add_device(const Device &dev, bool full,
   body = req.dump();
 }
 
+bool ClientHelper::parse_add_device(Device &dev, int code,
+                                    const std::string &body,
+                                    std::string *error) {
+  if (!generic_parse(code, body, error)) {
+    return false;
+  }
+
+  // The token is only returned on first registration
+  try {
+    auto resp = nlohmann::json::parse(body);
+    auto token = resp.value("token", "");
+    if (!token.empty()) {
+      dev.set_token(std::move(token));
+    }
+  } catch (const std::exception &e) {
+    if (error) {
+      *error = e.what();
+    }
+    return false;
+  }
+
+  return true;
+}
+
 void ClientHelper::create_remove_device(const Device &dev, std::string &method,
-                                        std::string &url) {
+                                        std::string &url,
+                                        std::string &authorization) {
   method = "DELETE";
   url = fmt::format("/device/{}", dev.get_description().serial_number);
+  create_authorization(dev, authorization);
 }
 
-void ClientHelper::create_update_device_online_status(const Device &dev,
-                                                      bool online,
-                                                      std::string &method,
-                                                      std::string &url) {
+void ClientHelper::create_update_device_online_status(
+    const Device &dev, bool online, std::string &method, std::string &url,
+    std::string &authorization) {
   method = "PUT";
   url = fmt::format("/device/{}/{}", dev.get_description().serial_number,
                     online ? "online" : "offline");
+  create_authorization(dev, authorization);
 }
 
 void ClientHelper::create_add_device_interface(const Device &dev,
                                                const Device::Interface &iface,
                                                std::string &method,
                                                std::string &url,
+                                               std::string &authorization,
                                                std::string &body) {
   method = "PUT";
   url = fmt::format("/device/{}/add", dev.get_description().serial_number);
+  create_authorization(dev, authorization);
 
   auto &desc = dev.get_description();
   body = interface_to_json(iface).dump();
@@ -82,9 +120,11 @@ void ClientHelper::create_add_device_interface(const Device &dev,
 void ClientHelper::create_remove_device_interface(const Device &dev,
                                                   const std::string &mac,
                                                   std::string &method,
-                                                  std::string &url) {
+                                                  std::string &url,
+                                                  std::string &authorization) {
   method = "DELETE";
   url = fmt::format("/device/{}/{}", dev.get_description().serial_number, mac);
+  create_authorization(dev, authorization);
 }
 
 bool ClientHelper::generic_parse(int code, const std::string &body,
@@ -0,0 +1,154 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/dillya/melo-webapi/internal/blob"
+	"github.com/dillya/melo-webapi/internal/device"
+	"github.com/dillya/melo-webapi/internal/plugin"
+	"github.com/dillya/melo-webapi/internal/release"
+	"github.com/dillya/melo-webapi/internal/signing"
+	"github.com/dillya/melo-webapi/internal/utils"
+	"github.com/dillya/melo-webapi/internal/utils/middleware"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// Name of the API key security scheme in OpenAPI
+const apiKeySecurityScheme = "adminApiKey"
+
+// Header used to send the API key
+const apiKeyHeader = "X-Api-Key"
+
+// Network list
+type networkListOutput struct {
+	Body []device.Network
+}
+
+// Device list
+type deviceListOutput struct {
+	Body []device.NetworkDevice
+}
+
+// Operation result
+type resultOutput struct {
+	Body adminResult
+}
+
+// Result
+type adminResult struct {
+	Code  uint   `json:"code" example:"2" doc:"The result code: 0=success"`
+	Error string `json:"error,omitempty" example:"Failed to remove device" doc:"The error message if code != 0"`
+}
+
+func getApiKeyChecker(api huma.API, key string) func(ctx huma.Context, next func(huma.Context)) {
+	return func(ctx huma.Context, next func(huma.Context)) {
+		// Compare API keys
+		if subtle.ConstantTimeCompare([]byte(ctx.Header(apiKeyHeader)), []byte(key)) != 1 {
+			huma.WriteErr(api, ctx, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+		next(ctx)
+	}
+}
+
+// Register the admin API: it is not registered when the API key is empty
+func Register(api huma.API, store device.DeviceStore, releases release.ReleaseStore, plugins plugin.PluginStore, blobs blob.BlobStore, signer *signing.Signer, client_ip middleware.IpConfig, key string) {
+	if key == "" {
+		return
+	}
+
+	// Register API key authentication
+	components := api.OpenAPI().Components
+	if components.SecuritySchemes == nil {
+		components.SecuritySchemes = map[string]*huma.SecurityScheme{}
+	}
+	components.SecuritySchemes[apiKeySecurityScheme] = &huma.SecurityScheme{
+		Type:        "apiKey",
+		In:          "header",
+		Name:        apiKeyHeader,
+		Description: "The API key of the administrators.",
+	}
+	security := []map[string][]string{{apiKeySecurityScheme: {}}}
+
+	// API key checker middleware
+	api_key_check := getApiKeyChecker(api, key)
+
+	// Register GET /admin/networks handler
+	huma.Register(api, huma.Operation{
+		OperationID: "adminListNetworks",
+		Method:      http.MethodGet,
+		Path:        "/admin/networks",
+		Summary:     "List networks",
+		Description: "List all networks with their number of registered and online devices.",
+		Tags:        []string{"Admin"},
+		Security:    security,
+		Middlewares: huma.Middlewares{api_key_check},
+	}, func(ctx context.Context, input *struct{}) (*networkListOutput, error) {
+		// List networks
+		networks, err := store.ListNetworks(ctx)
+		if err != nil {
+			return nil, utils.HttpError(err, "")
+		}
+		resp := &networkListOutput{}
+		resp.Body = networks
+		return resp, nil
+	})
+
+	// Register GET /admin/devices handler
+	huma.Register(api, huma.Operation{
+		OperationID: "adminListDevices",
+		Method:      http.MethodGet,
+		Path:        "/admin/devices",
+		Summary:     "List / search devices",
+		Description: "List the devices of all networks or of a network, and search them by serial number or name.",
+		Tags:        []string{"Admin"},
+		Security:    security,
+		Middlewares: huma.Middlewares{api_key_check},
+	}, func(ctx context.Context, input *struct {
+		Ip    string `query:"ip" example:"203.0.113.10" doc:"The public IP address of the network, or any address within an IPv6 network (all networks when empty)"`
+		Query string `query:"q" example:"living" doc:"The text to search in serial number and name (case insensitive)"`
+	}) (*deviceListOutput, error) {
+		// Search devices
+		devices, err := store.Search(ctx, client_ip.NetworkFromIp(input.Ip), input.Query)
+		if err != nil {
+			return nil, utils.HttpError(err, "")
+		}
+		resp := &deviceListOutput{}
+		resp.Body = devices
+		return resp, nil
+	})
+
+	// Register DELETE /admin/device/{ip}/{serial} handler
+	huma.Register(api, huma.Operation{
+		OperationID: "adminRemoveDevice",
+		Method:      http.MethodDelete,
+		Path:        "/admin/device/{ip}/{serial}",
+		Summary:     "Force device removal",
+		Description: "Remove the device from a network without its token.",
+		Tags:        []string{"Admin"},
+		Security:    security,
+		Middlewares: huma.Middlewares{api_key_check},
+	}, func(ctx context.Context, input *struct {
+		Ip     string `path:"ip" example:"203.0.113.10" doc:"The public IP address of the network, or any address within an IPv6 network"`
+		Serial string `path:"serial" example:"01:23:45:67:89:ab" doc:"Serial Number of the device to remove"`
+	}) (*resultOutput, error) {
+		// Remove device
+		if err := store.Remove(ctx, client_ip.NetworkFromIp(input.Ip), input.Serial); err != nil {
+			return nil, utils.HttpError(err, "path.")
+		}
+
+		return &resultOutput{}, nil
+	})
+
+	// Register release management
+	registerRelease(api, releases, signer, security, api_key_check)
+
+	// Register plugin catalog management
+	registerPlugin(api, plugins, signer, security, api_key_check)
+
+	// Register artifact management
+	registerArtifact(api, blobs, security, api_key_check)
+}
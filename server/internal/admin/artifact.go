@@ -0,0 +1,86 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/dillya/melo-webapi/internal/blob"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// Artifact output
+type artifactOutput struct {
+	Body blob.Blob
+}
+
+// Context key of the request body, streamed to the blob store instead of being read by Huma
+type bodyReaderKey struct{}
+
+// Register the artifact management handlers: they are not registered when the storage is disabled
+func registerArtifact(api huma.API, store blob.BlobStore, security []map[string][]string, api_key_check func(ctx huma.Context, next func(huma.Context))) {
+	if store == nil {
+		return
+	}
+
+	// Register PUT /admin/artifact/{key} handler
+	huma.Register(api, huma.Operation{
+		OperationID: "adminUploadArtifact",
+		Method:      http.MethodPut,
+		Path:        "/admin/artifact/{key}",
+		Summary:     "Upload artifact",
+		Description: "Upload an artifact (release update, plugin archive, ...) or replace it: it can then be downloaded from /download/{key}. When the expected checksum is set, the artifact is not stored if it does not match.",
+		Tags:        []string{"Admin"},
+		Security:    security,
+		Middlewares: huma.Middlewares{api_key_check, func(ctx huma.Context, next func(huma.Context)) {
+			next(huma.WithValue(ctx, bodyReaderKey{}, ctx.BodyReader()))
+		}},
+		RequestBody: &huma.RequestBody{
+			Description: "The content of the artifact.",
+			Required:    true,
+			Content:     map[string]*huma.MediaType{"application/octet-stream": {}},
+		},
+	}, func(ctx context.Context, input *struct {
+		Key    string `path:"key" example:"melo-1.2.0-aarch64.swu" pattern:"^[A-Za-z0-9][A-Za-z0-9._-]*$" maxLength:"255" doc:"The key of the artifact"`
+		Sha256 string `query:"sha256" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" pattern:"^[0-9a-f]{64}$" doc:"The expected SHA-256 checksum of the artifact"`
+	}) (*artifactOutput, error) {
+		// Store artifact
+		artifact, err := store.Put(ctx, input.Key, ctx.Value(bodyReaderKey{}).(io.Reader), input.Sha256)
+		if errors.Is(err, blob.ErrChecksumMismatch) {
+			return nil, huma.Error422UnprocessableEntity("validation failed", &huma.ErrorDetail{
+				Message:  err.Error(),
+				Location: "query.sha256",
+				Value:    input.Sha256,
+			})
+		} else if err != nil {
+			return nil, blob.HttpError(err, "path.key")
+		}
+
+		resp := &artifactOutput{}
+		resp.Body = artifact
+		return resp, nil
+	})
+
+	// Register DELETE /admin/artifact/{key} handler
+	huma.Register(api, huma.Operation{
+		OperationID: "adminRemoveArtifact",
+		Method:      http.MethodDelete,
+		Path:        "/admin/artifact/{key}",
+		Summary:     "Remove artifact",
+		Description: "Remove an artifact: it cannot be downloaded anymore.",
+		Tags:        []string{"Admin"},
+		Security:    security,
+		Middlewares: huma.Middlewares{api_key_check},
+	}, func(ctx context.Context, input *struct {
+		Key string `path:"key" example:"melo-1.2.0-aarch64.swu" doc:"The key of the artifact"`
+	}) (*resultOutput, error) {
+		// Remove artifact
+		if err := store.Remove(ctx, input.Key); err != nil {
+			return nil, blob.HttpError(err, "path.key")
+		}
+
+		return &resultOutput{}, nil
+	})
+}
@@ -0,0 +1,125 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/dillya/melo-webapi/internal/plugin"
+	"github.com/dillya/melo-webapi/internal/signing"
+	"github.com/dillya/melo-webapi/internal/utils"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// Plugin output
+type pluginOutput struct {
+	Body plugin.Plugin
+}
+
+// Register the plugin catalog management handlers
+func registerPlugin(api huma.API, store plugin.PluginStore, signer *signing.Signer, security []map[string][]string, api_key_check func(ctx huma.Context, next func(huma.Context))) {
+	// Register PUT /admin/plugin handler
+	huma.Register(api, huma.Operation{
+		OperationID: "adminAddPlugin",
+		Method:      http.MethodPut,
+		Path:        "/admin/plugin",
+		Summary:     "Add / update plugin",
+		Description: "Add a plugin to the catalog, or update its description (its versions are not changed).",
+		Tags:        []string{"Admin"},
+		Security:    security,
+		Middlewares: huma.Middlewares{api_key_check},
+	}, func(ctx context.Context, input *struct {
+		Body plugin.Plugin
+	}) (*pluginOutput, error) {
+		// Add plugin
+		if err := store.Add(ctx, input.Body); err != nil {
+			return nil, utils.HttpError(err, "body.")
+		}
+
+		// Get plugin with its versions
+		details, err := store.Get(ctx, input.Body.Name)
+		if err != nil {
+			return nil, utils.HttpError(err, "")
+		}
+		resp := &pluginOutput{}
+		resp.Body = details
+		return resp, nil
+	})
+
+	// Register DELETE /admin/plugin/{name} handler
+	huma.Register(api, huma.Operation{
+		OperationID: "adminRemovePlugin",
+		Method:      http.MethodDelete,
+		Path:        "/admin/plugin/{name}",
+		Summary:     "Remove plugin",
+		Description: "Remove a plugin from the catalog with all its versions.",
+		Tags:        []string{"Admin"},
+		Security:    security,
+		Middlewares: huma.Middlewares{api_key_check},
+	}, func(ctx context.Context, input *struct {
+		Name string `path:"name" example:"spotify" doc:"The name of the plugin"`
+	}) (*resultOutput, error) {
+		// Remove plugin
+		if err := store.Remove(ctx, input.Name); err != nil {
+			return nil, utils.HttpError(err, "path.")
+		}
+
+		return &resultOutput{}, nil
+	})
+
+	// Register PUT /admin/plugin/{name}/version handler
+	huma.Register(api, huma.Operation{
+		OperationID: "adminPublishPluginVersion",
+		Method:      http.MethodPut,
+		Path:        "/admin/plugin/{name}/version",
+		Summary:     "Publish plugin version",
+		Description: "Publish a version of a plugin with its compatibility range, or replace it. When artifact keys are configured, the checksum of the plugin archive must be signed by one of the artifact keys.",
+		Tags:        []string{"Admin"},
+		Security:    security,
+		Middlewares: huma.Middlewares{api_key_check},
+	}, func(ctx context.Context, input *struct {
+		Name string `path:"name" example:"spotify" doc:"The name of the plugin"`
+		Body plugin.PluginVersion
+	}) (*pluginOutput, error) {
+		// Check signature
+		if err := checkSignature(signer, input.Body.Sha256, input.Body.Signature, input.Body.SignatureKey); err != nil {
+			return nil, err
+		}
+
+		// Publish version
+		if err := store.AddVersion(ctx, input.Name, input.Body); err != nil {
+			return nil, utils.HttpError(err, "body.")
+		}
+
+		// Get plugin with its versions
+		details, err := store.Get(ctx, input.Name)
+		if err != nil {
+			return nil, utils.HttpError(err, "")
+		}
+		resp := &pluginOutput{}
+		resp.Body = details
+		return resp, nil
+	})
+
+	// Register DELETE /admin/plugin/{name}/version/{version} handler
+	huma.Register(api, huma.Operation{
+		OperationID: "adminRemovePluginVersion",
+		Method:      http.MethodDelete,
+		Path:        "/admin/plugin/{name}/version/{version}",
+		Summary:     "Remove plugin version",
+		Description: "Remove a version of a plugin: devices are not offered it anymore.",
+		Tags:        []string{"Admin"},
+		Security:    security,
+		Middlewares: huma.Middlewares{api_key_check},
+	}, func(ctx context.Context, input *struct {
+		Name    string `path:"name" example:"spotify" doc:"The name of the plugin"`
+		Version string `path:"version" example:"1.1.0" doc:"The version of the plugin"`
+	}) (*resultOutput, error) {
+		// Remove version
+		if err := store.RemoveVersion(ctx, input.Name, input.Version); err != nil {
+			return nil, utils.HttpError(err, "path.")
+		}
+
+		return &resultOutput{}, nil
+	})
+}
@@ -0,0 +1,172 @@
+package admin
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/dillya/melo-webapi/internal/release"
+	"github.com/dillya/melo-webapi/internal/signing"
+	"github.com/dillya/melo-webapi/internal/utils"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// Release output
+type releaseOutput struct {
+	Body release.Release
+}
+
+// Release adoption output
+type adoptionOutput struct {
+	Body release.Adoption
+}
+
+// checkSignature checks an artifact is signed by a configured artifact key when the verification is
+// enabled: the signature is verified against the SHA-256 checksum of the artifact.
+func checkSignature(signer *signing.Signer, sha256 string, signature string, key_id string) error {
+	if !signer.VerifyEnabled() {
+		return nil
+	} else if signature == "" {
+		return huma.Error422UnprocessableEntity("validation failed", &huma.ErrorDetail{
+			Message:  "signature is required",
+			Location: "body.signature",
+		})
+	} else if signer.ArtifactKey(key_id) == nil {
+		return huma.Error422UnprocessableEntity("validation failed", &huma.ErrorDetail{
+			Message:  "unknown signing key",
+			Location: "body.signature_key",
+			Value:    key_id,
+		})
+	}
+
+	// Verify signature of the checksum
+	digest, _ := hex.DecodeString(sha256)
+	value, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !signer.Verify(key_id, digest, value) {
+		return huma.Error422UnprocessableEntity("validation failed", &huma.ErrorDetail{
+			Message:  "invalid signature",
+			Location: "body.signature",
+			Value:    signature,
+		})
+	}
+	return nil
+}
+
+// Register the release management handlers
+func registerRelease(api huma.API, store release.ReleaseStore, signer *signing.Signer, security []map[string][]string, api_key_check func(ctx huma.Context, next func(huma.Context))) {
+	// Register PUT /admin/release handler
+	huma.Register(api, huma.Operation{
+		OperationID: "adminPublishRelease",
+		Method:      http.MethodPut,
+		Path:        "/admin/release",
+		Summary:     "Publish release",
+		Description: "Publish a release on a channel, or replace it (to move it to another channel for instance): the rollout of a replaced release is kept. When artifact keys are configured, the checksum of the update must be signed by one of the artifact keys.",
+		Tags:        []string{"Admin"},
+		Security:    security,
+		Middlewares: huma.Middlewares{api_key_check},
+	}, func(ctx context.Context, input *struct {
+		Body release.Release
+	}) (*releaseOutput, error) {
+		// Check signature
+		if err := checkSignature(signer, input.Body.Sha256, input.Body.Signature, input.Body.SignatureKey); err != nil {
+			return nil, err
+		}
+
+		// Publish release
+		if err := store.Add(ctx, input.Body); err != nil {
+			return nil, utils.HttpError(err, "body.")
+		}
+
+		// Get release with its publication date
+		rel, err := store.Get(ctx, input.Body.Version, input.Body.Arch)
+		if err != nil {
+			return nil, utils.HttpError(err, "")
+		}
+		resp := &releaseOutput{}
+		resp.Body = rel
+		return resp, nil
+	})
+
+	// Register DELETE /admin/release/{version}/{arch} handler
+	huma.Register(api, huma.Operation{
+		OperationID: "adminRemoveRelease",
+		Method:      http.MethodDelete,
+		Path:        "/admin/release/{version}/{arch}",
+		Summary:     "Remove release",
+		Description: "Remove a release: devices are not offered it anymore.",
+		Tags:        []string{"Admin"},
+		Security:    security,
+		Middlewares: huma.Middlewares{api_key_check},
+	}, func(ctx context.Context, input *struct {
+		Version string `path:"version" example:"1.2.0" doc:"The version of the release"`
+		Arch    string `path:"arch" example:"aarch64" doc:"The CPU architecture of the release"`
+	}) (*resultOutput, error) {
+		// Remove release
+		if err := store.Remove(ctx, input.Version, input.Arch); err != nil {
+			return nil, utils.HttpError(err, "path.")
+		}
+
+		return &resultOutput{}, nil
+	})
+
+	// Register PUT /admin/release/{version}/{arch}/rollout handler
+	huma.Register(api, huma.Operation{
+		OperationID: "adminSetReleaseRollout",
+		Method:      http.MethodPut,
+		Path:        "/admin/release/{version}/{arch}/rollout",
+		Summary:     "Update release rollout",
+		Description: "Update the rollout of a release: increase its percentage, pause it (the release is not offered anymore), resume it or roll it back (the devices running it are offered the previous release).",
+		Tags:        []string{"Admin"},
+		Security:    security,
+		Middlewares: huma.Middlewares{api_key_check},
+	}, func(ctx context.Context, input *struct {
+		Version string `path:"version" example:"1.2.0" doc:"The version of the release"`
+		Arch    string `path:"arch" example:"aarch64" doc:"The CPU architecture of the release"`
+		Body    struct {
+			Rollout      uint8  `json:"rollout" example:"25" minimum:"0" maximum:"100" doc:"The percentage of the devices offered the release"`
+			RolloutState string `json:"rollout_state" example:"active" enum:"active,paused,rolled_back" doc:"The rollout state of the release"`
+		}
+	}) (*releaseOutput, error) {
+		// Update rollout
+		state := release.RolloutState(release.RolloutStateFromString(input.Body.RolloutState))
+		if err := store.SetRollout(ctx, input.Version, input.Arch, input.Body.Rollout, state); err != nil {
+			return nil, utils.HttpError(err, "path.")
+		}
+
+		// Get updated release
+		rel, err := store.Get(ctx, input.Version, input.Arch)
+		if err != nil {
+			return nil, utils.HttpError(err, "")
+		}
+		resp := &releaseOutput{}
+		resp.Body = rel
+		return resp, nil
+	})
+
+	// Register GET /admin/release/{version}/{arch}/adoption handler
+	huma.Register(api, huma.Operation{
+		OperationID: "adminGetReleaseAdoption",
+		Method:      http.MethodGet,
+		Path:        "/admin/release/{version}/{arch}/adoption",
+		Summary:     "Get release adoption",
+		Description: "Get the adoption rate of a release, from the last update check of the devices of its architecture on its channel (or on a less stable one).",
+		Tags:        []string{"Admin"},
+		Security:    security,
+		Middlewares: huma.Middlewares{api_key_check},
+	}, func(ctx context.Context, input *struct {
+		Version string `path:"version" example:"1.2.0" doc:"The version of the release"`
+		Arch    string `path:"arch" example:"aarch64" doc:"The CPU architecture of the release"`
+		Since   uint64 `query:"since" example:"1700000000" doc:"Only the devices which checked for update since this timestamp as Unix epoch"`
+	}) (*adoptionOutput, error) {
+		// Get adoption
+		adoption, err := store.GetAdoption(ctx, input.Version, input.Arch, input.Since)
+		if err != nil {
+			return nil, utils.HttpError(err, "")
+		}
+		resp := &adoptionOutput{}
+		resp.Body = adoption
+		return resp, nil
+	})
+}
@@ -0,0 +1,198 @@
+package blob
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Download request
+type downloadInput struct {
+	Key         string `path:"key" example:"melo-1.2.0-aarch64.swu" pattern:"^[A-Za-z0-9][A-Za-z0-9._-]*$" maxLength:"255" doc:"The key of the artifact"`
+	Range       string `header:"Range" example:"bytes=1048576-" doc:"The byte range to download: bytes=START-END, bytes=START- or bytes=-LENGTH (a single range is supported)"`
+	IfRange     string `header:"If-Range" doc:"Download the range only when the artifact is unchanged (ETag or last modification date), the whole artifact otherwise"`
+	IfNoneMatch string `header:"If-None-Match" doc:"Do not download the artifact when its ETag matches"`
+}
+
+// Byte range of an artifact
+type byteRange struct {
+	offset uint64
+	length uint64
+}
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseRange parses a byte range (RFC 9110) of a blob: the range is ignored (ok is false) when it
+// is missing, invalid or made of multiple ranges, and an error is returned when it starts after the
+// end of the blob.
+func parseRange(header string, size uint64) (r byteRange, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return r, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return r, false, nil
+	}
+
+	// Suffix range: last bytes
+	if first == "" {
+		length, err := strconv.ParseUint(last, 10, 64)
+		if err != nil {
+			return r, false, nil
+		} else if length == 0 || size == 0 {
+			return r, false, errRangeNotSatisfiable
+		}
+		length = min(length, size)
+		return byteRange{offset: size - length, length: length}, true, nil
+	}
+
+	// Range from first byte to last byte (end of blob when empty)
+	start, err := strconv.ParseUint(first, 10, 64)
+	if err != nil {
+		return r, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseUint(last, 10, 64); err != nil || end < start {
+			return r, false, nil
+		}
+	}
+	if start >= size {
+		return r, false, errRangeNotSatisfiable
+	}
+	end = min(end, size-1)
+	return byteRange{offset: start, length: end - start + 1}, true, nil
+}
+
+// Check the If-Range condition: the entity tag or the last modification date must match
+func matchIfRange(header string, etag string, date time.Time) bool {
+	if header == "" || header == etag {
+		return true
+	} else if since, err := http.ParseTime(header); err == nil {
+		return since.Equal(date)
+	}
+	return false
+}
+
+// Check the If-None-Match condition: true when one of the entity tags matches
+func matchIfNoneMatch(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func Register(api huma.API, store BlobStore) {
+	// Storage is disabled
+	if store == nil {
+		return
+	}
+
+	// Register GET and HEAD /download/{key} handlers
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		operation := huma.Operation{
+			OperationID: "downloadArtifact",
+			Method:      method,
+			Path:        "/download/{key}",
+			Summary:     "Download artifact",
+			Description: "Download an artifact (release update, plugin archive, ...). A single byte range can be requested to resume an interrupted download, with If-Range to restart it when the artifact changed. " +
+				"The SHA-256 checksum of the whole artifact is sent in the ETag and Repr-Digest (RFC 9530) headers.",
+			Tags:   []string{"Download"},
+			Errors: []int{http.StatusNotFound, http.StatusServiceUnavailable},
+			Responses: map[string]*huma.Response{
+				"200": {Description: "The whole artifact.", Content: map[string]*huma.MediaType{"application/octet-stream": {}}},
+				"206": {Description: "The requested range of the artifact.", Content: map[string]*huma.MediaType{"application/octet-stream": {}}},
+				"304": {Description: "The artifact matches If-None-Match."},
+				"416": {Description: "The requested range starts after the end of the artifact."},
+			},
+		}
+		if method == http.MethodHead {
+			operation.OperationID = "getArtifactHeaders"
+			operation.Summary = "Get artifact headers"
+			operation.Description = "Get the size, the SHA-256 checksum and the last modification date of an artifact in the headers of a download, without its content."
+		}
+
+		huma.Register(api, operation, func(ctx context.Context, input *downloadInput) (*huma.StreamResponse, error) {
+			// Open artifact (its content is read from the version described by its information)
+			obj, err := store.Open(ctx, input.Key)
+			if err != nil {
+				return nil, HttpError(err, "path.key")
+			}
+			blob := obj.Info()
+			etag := `"` + blob.Sha256 + `"`
+			date := time.Unix(int64(blob.Date), 0).UTC()
+			digest, _ := hex.DecodeString(blob.Sha256)
+
+			// Find status and range
+			status := http.StatusOK
+			r := byteRange{offset: 0, length: blob.Size}
+			if input.IfNoneMatch != "" && matchIfNoneMatch(input.IfNoneMatch, etag) {
+				status = http.StatusNotModified
+			} else if input.Range != "" && matchIfRange(input.IfRange, etag, date) {
+				if ranged, ok, err := parseRange(input.Range, blob.Size); err != nil {
+					status = http.StatusRequestedRangeNotSatisfiable
+				} else if ok {
+					status = http.StatusPartialContent
+					r = ranged
+				}
+			}
+
+			return &huma.StreamResponse{Body: func(ctx huma.Context) {
+				defer obj.Close()
+
+				// Read artifact range
+				var reader io.ReadCloser
+				if method == http.MethodGet && (status == http.StatusOK || status == http.StatusPartialContent) {
+					if reader, err = obj.ReadRange(ctx.Context(), r.offset, r.length); err != nil {
+						var serr huma.StatusError
+						if errors.As(HttpError(err, "path.key"), &serr) {
+							huma.WriteErr(api, ctx, serr.GetStatus(), serr.Error())
+						}
+						return
+					}
+					defer reader.Close()
+				}
+
+				// Send headers
+				ctx.SetHeader("Accept-Ranges", "bytes")
+				ctx.SetHeader("ETag", etag)
+				ctx.SetHeader("Last-Modified", date.Format(http.TimeFormat))
+				ctx.SetHeader("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(digest)+":")
+				switch status {
+				case http.StatusOK, http.StatusPartialContent:
+					ctx.SetHeader("Content-Type", "application/octet-stream")
+					ctx.SetHeader("Content-Disposition", `attachment; filename="`+blob.Key+`"`)
+					ctx.SetHeader("Content-Length", strconv.FormatUint(r.length, 10))
+					if status == http.StatusPartialContent {
+						ctx.SetHeader("Content-Range", fmt.Sprintf("bytes %d-%d/%d", r.offset, r.offset+r.length-1, blob.Size))
+					}
+				case http.StatusRequestedRangeNotSatisfiable:
+					ctx.SetHeader("Content-Range", fmt.Sprintf("bytes */%d", blob.Size))
+				}
+				ctx.SetStatus(status)
+
+				// Send artifact range
+				if reader != nil {
+					if _, err := io.Copy(ctx.BodyWriter(), reader); err != nil {
+						log.WithFields(log.Fields{"key": blob.Key, "error": err}).Debug("download interrupted")
+					}
+				}
+			}}, nil
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2/humatest"
+)
+
+func TestParseRange(t *testing.T) {
+	for _, test := range []struct {
+		header string
+		size   uint64
+		want   byteRange
+		ok     bool
+		err    error
+	}{
+		{"", 100, byteRange{}, false, nil},
+		{"bytes=0-99", 100, byteRange{0, 100}, true, nil},
+		{"bytes=0-0", 100, byteRange{0, 1}, true, nil},
+		{"bytes=10-19", 100, byteRange{10, 10}, true, nil},
+		{"bytes=10-", 100, byteRange{10, 90}, true, nil},
+		{"bytes=90-200", 100, byteRange{90, 10}, true, nil},
+		{"bytes=99-", 100, byteRange{99, 1}, true, nil},
+		{"bytes=-10", 100, byteRange{90, 10}, true, nil},
+		{"bytes=-200", 100, byteRange{0, 100}, true, nil},
+		{"bytes= 10-19 ", 100, byteRange{10, 10}, true, nil},
+		{"bytes=100-", 100, byteRange{}, false, errRangeNotSatisfiable},
+		{"bytes=100-200", 100, byteRange{}, false, errRangeNotSatisfiable},
+		{"bytes=-0", 100, byteRange{}, false, errRangeNotSatisfiable},
+		{"bytes=0-", 0, byteRange{}, false, errRangeNotSatisfiable},
+		{"bytes=-10", 0, byteRange{}, false, errRangeNotSatisfiable},
+		{"bytes=20-10", 100, byteRange{}, false, nil},
+		{"bytes=0-9,20-29", 100, byteRange{}, false, nil},
+		{"bytes=10", 100, byteRange{}, false, nil},
+		{"bytes=a-b", 100, byteRange{}, false, nil},
+		{"bytes=-", 100, byteRange{}, false, nil},
+		{"items=0-9", 100, byteRange{}, false, nil},
+	} {
+		t.Run(test.header, func(t *testing.T) {
+			got, ok, err := parseRange(test.header, test.size)
+			if got != test.want || ok != test.ok || err != test.err {
+				t.Fatalf("size %d: got %+v, %t, %v, want %+v, %t, %v", test.size, got, ok, err, test.want, test.ok, test.err)
+			}
+		})
+	}
+}
+
+func TestMatchIfRange(t *testing.T) {
+	date := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"none", "", true},
+		{"same etag", `"abc"`, true},
+		{"other etag", `"def"`, false},
+		{"weak etag", `W/"abc"`, false},
+		{"same date", date.Format(http.TimeFormat), true},
+		{"older date", date.Add(-time.Second).Format(http.TimeFormat), false},
+		{"newer date", date.Add(time.Second).Format(http.TimeFormat), false},
+		{"invalid", "yesterday", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := matchIfRange(test.header, `"abc"`, date); got != test.want {
+				t.Fatalf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMatchIfNoneMatch(t *testing.T) {
+	for _, test := range []struct {
+		header string
+		want   bool
+	}{
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"def", "abc"`, true},
+		{`*`, true},
+		{`"def"`, false},
+		{`abc`, false},
+	} {
+		if got := matchIfNoneMatch(test.header, `"abc"`); got != test.want {
+			t.Errorf("%s: got %t, want %t", test.header, got, test.want)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	// Serve an artifact of the local store
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %s", err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	blob, err := store.Put(context.Background(), "melo.swu", bytes.NewReader(data), "")
+	if err != nil {
+		t.Fatalf("failed to add artifact: %s", err)
+	}
+	etag := `"` + blob.Sha256 + `"`
+	date := time.Unix(int64(blob.Date), 0).UTC()
+	_, api := humatest.New(t)
+	Register(api, store)
+
+	for _, test := range []struct {
+		name          string
+		headers       []any
+		status        int
+		body          []byte
+		content_range string
+	}{
+		{"whole", nil, http.StatusOK, data, ""},
+		{"range", []any{"Range: bytes=10-19"}, http.StatusPartialContent, data[10:20], "bytes 10-19/100"},
+		{"suffix range", []any{"Range: bytes=-5"}, http.StatusPartialContent, data[95:], "bytes 95-99/100"},
+		{"multiple ranges", []any{"Range: bytes=0-9,20-29"}, http.StatusOK, data, ""},
+		{"unsatisfiable range", []any{"Range: bytes=100-"}, http.StatusRequestedRangeNotSatisfiable, nil, "bytes */100"},
+		{"if-range etag", []any{"Range: bytes=10-19", "If-Range: " + etag}, http.StatusPartialContent, data[10:20], "bytes 10-19/100"},
+		{"if-range date", []any{"Range: bytes=10-19", "If-Range: " + date.Format(http.TimeFormat)}, http.StatusPartialContent, data[10:20], "bytes 10-19/100"},
+		{"if-range changed", []any{"Range: bytes=10-19", `If-Range: "changed"`}, http.StatusOK, data, ""},
+		{"if-range older", []any{"Range: bytes=10-19", "If-Range: " + date.Add(-time.Hour).Format(http.TimeFormat)}, http.StatusOK, data, ""},
+		{"if-none-match", []any{"If-None-Match: " + etag}, http.StatusNotModified, nil, ""},
+		{"if-none-match with range", []any{"Range: bytes=10-19", "If-None-Match: " + etag}, http.StatusNotModified, nil, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resp := api.Get("/download/melo.swu", test.headers...)
+			if resp.Code != test.status {
+				t.Fatalf("got status %d, want %d", resp.Code, test.status)
+			} else if test.body != nil && !bytes.Equal(resp.Body.Bytes(), test.body) {
+				t.Fatalf("got %d bytes, want %d bytes", resp.Body.Len(), len(test.body))
+			} else if got := resp.Header().Get("Content-Range"); got != test.content_range {
+				t.Fatalf("got Content-Range %q, want %q", got, test.content_range)
+			} else if got := resp.Header().Get("ETag"); got != etag {
+				t.Fatalf("got ETag %q, want %q", got, etag)
+			}
+		})
+	}
+
+	// Unknown artifact
+	if resp := api.Get("/download/unknown.swu"); resp.Code != http.StatusNotFound {
+		t.Fatalf("unknown artifact: got status %d, want %d", resp.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,29 @@
+package blob
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/danielgtaylor/huma/v2"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// Errors returned by the blob stores (see also utils.ErrUnavailable)
+var (
+	ErrBlobNotFound     = fmt.Errorf("artifact %w", utils.ErrNotFound)
+	ErrInvalidKey       = errors.New("invalid artifact key")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// HttpError converts a blob store error to a Huma error model: the location is used for an invalid
+// key (as "path.key"), a checksum mismatch must be handled by the caller.
+func HttpError(err error, location string) error {
+	if errors.Is(err, ErrInvalidKey) {
+		return huma.Error422UnprocessableEntity("validation failed", &huma.ErrorDetail{
+			Message:  err.Error(),
+			Location: location,
+		})
+	}
+	return utils.HttpError(err, "")
+}
@@ -0,0 +1,215 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// Local filesystem blob store: the blobs are stored as files in the root directory, their content
+// hash in the hidden .sha256 directory and the uploads in progress in the hidden .tmp directory.
+type localStore struct {
+	root string
+}
+
+// Hidden directories of the local store
+const (
+	localHashDir = ".sha256"
+	localTmpDir  = ".tmp"
+)
+
+// NewLocalStore creates a blob store in a local directory (created if needed). The files copied
+// in the directory are hashed on first access.
+func NewLocalStore(path string) (BlobStore, error) {
+	for _, dir := range []string{localHashDir, localTmpDir} {
+		if err := os.MkdirAll(filepath.Join(path, dir), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &localStore{root: path}, nil
+}
+
+// Write a file atomically: the content is written to a temporary file renamed on success, when
+// the check returns no error
+func (s *localStore) writeFile(path string, r io.Reader, check func() error) (int64, error) {
+	file, err := os.CreateTemp(filepath.Join(s.root, localTmpDir), "upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+
+	size, err := io.Copy(file, r)
+	if err == nil {
+		err = file.Chmod(0o644)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if close := file.Close(); err == nil {
+		err = close
+	}
+	if err == nil && check != nil {
+		err = check()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	return size, err
+}
+
+// Convert a filesystem error
+func localError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrBlobNotFound
+	}
+	return err
+}
+
+func (s *localStore) Put(ctx context.Context, key string, r io.Reader, expected string) (Blob, error) {
+	if err := checkKey(key); err != nil {
+		return Blob{}, err
+	}
+
+	// Store blob while computing its hash (the previous blob is kept on mismatch)
+	hash := sha256.New()
+	var sum string
+	if _, err := s.writeFile(filepath.Join(s.root, key), io.TeeReader(r, hash), func() error {
+		sum = hex.EncodeToString(hash.Sum(nil))
+		if expected != "" && sum != expected {
+			return ErrChecksumMismatch
+		}
+		return nil
+	}); err != nil {
+		return Blob{}, err
+	}
+	if _, err := s.writeFile(filepath.Join(s.root, localHashDir, key), strings.NewReader(sum), nil); err != nil {
+		return Blob{}, err
+	}
+
+	return s.Stat(ctx, key)
+}
+
+func (s *localStore) Stat(ctx context.Context, key string) (Blob, error) {
+	obj, err := s.Open(ctx, key)
+	if err != nil {
+		return Blob{}, err
+	}
+	defer obj.Close()
+	return obj.Info(), nil
+}
+
+// Opened blob file
+type localObject struct {
+	file *os.File
+	blob Blob
+}
+
+func (s *localStore) Open(ctx context.Context, key string) (Object, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	// Open blob file and get its information from the opened file
+	path := filepath.Join(s.root, key)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, localError(err)
+	}
+	obj, err := s.openObject(key, path, file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *localStore) openObject(key string, path string, file *os.File) (*localObject, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	} else if !info.Mode().IsRegular() {
+		return nil, ErrBlobNotFound
+	}
+
+	// Get content hash (the hash is saved after the blob, so a hash older than its blob is outdated)
+	var sum string
+	hash_path := filepath.Join(s.root, localHashDir, key)
+	if hash_info, err := os.Stat(hash_path); err == nil && !hash_info.ModTime().Before(info.ModTime()) {
+		data, err := os.ReadFile(hash_path)
+		if err != nil {
+			return nil, err
+		}
+		sum = string(data)
+	}
+
+	// The hash read may be the one of a new blob replacing the opened one
+	current, err := os.Stat(path)
+	replaced := err != nil || !os.SameFile(info, current)
+
+	// Compute content hash from the opened file when missing or outdated (saved when not replaced)
+	if sum == "" || replaced {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, io.NewSectionReader(file, 0, info.Size())); err != nil {
+			return nil, err
+		}
+		sum = hex.EncodeToString(hash.Sum(nil))
+		if !replaced {
+			if _, err := s.writeFile(hash_path, strings.NewReader(sum), nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &localObject{
+		file: file,
+		blob: Blob{
+			Key:    key,
+			Size:   uint64(info.Size()),
+			Sha256: sum,
+			Date:   uint64(info.ModTime().Unix()),
+		},
+	}, nil
+}
+
+func (o *localObject) Info() Blob {
+	return o.blob
+}
+
+func (o *localObject) ReadRange(ctx context.Context, offset uint64, length uint64) (io.ReadCloser, error) {
+	return io.NopCloser(io.NewSectionReader(o.file, int64(offset), int64(length))), nil
+}
+
+func (o *localObject) Close() error {
+	return o.file.Close()
+}
+
+func (s *localStore) Remove(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	// Remove blob and its hash
+	if err := os.Remove(filepath.Join(s.root, key)); err != nil {
+		return localError(err)
+	}
+	os.Remove(filepath.Join(s.root, localHashDir, key))
+	return nil
+}
+
+func (s *localStore) Ping(ctx context.Context) error {
+	if info, err := os.Stat(s.root); err != nil {
+		return fmt.Errorf("%w: %w", utils.ErrUnavailable, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory", utils.ErrUnavailable, s.root)
+	}
+	return nil
+}
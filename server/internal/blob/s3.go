@@ -0,0 +1,358 @@
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// S3 compatible blob store (AWS S3, MinIO, ...): the objects are addressed with path-style URLs
+// and their content hash is stored in the sha256 user metadata.
+type s3Store struct {
+	endpoint   *url.URL
+	region     string
+	bucket     string
+	access_key string
+	secret_key string
+	client     *http.Client
+}
+
+// Header of the content hash user metadata
+const s3HashHeader = "X-Amz-Meta-Sha256"
+
+// Hash of an empty payload
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// NewS3Store creates a blob store in a bucket of a S3 compatible server. The objects uploaded
+// with other tools are hashed on first access.
+func NewS3Store(endpoint string, region string, bucket string, access_key string, secret_key string) (BlobStore, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	return &s3Store{
+		endpoint:   u,
+		region:     region,
+		bucket:     bucket,
+		access_key: access_key,
+		secret_key: secret_key,
+		client:     &http.Client{},
+	}, nil
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Sign a request with AWS Signature Version 4 (the payload hash is the hexadecimal SHA-256 of the
+// body)
+func (s *s3Store) sign(req *http.Request, payload_hash string) {
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payload_hash)
+
+	// Generate canonical headers (host and Amazon headers)
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonical_headers := ""
+	for _, name := range names {
+		canonical_headers += name + ":" + headers[name] + "\n"
+	}
+	signed_headers := strings.Join(names, ";")
+
+	// Generate canonical request and string to sign
+	canonical_request := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonical_headers,
+		signed_headers,
+		payload_hash,
+	}, "\n")
+	request_hash := sha256.Sum256([]byte(canonical_request))
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	string_to_sign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(request_hash[:])
+
+	// Derive signing key and sign
+	key := hmacSha256([]byte("AWS4"+s.secret_key), date)
+	key = hmacSha256(key, s.region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, string_to_sign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.access_key+"/"+scope+
+		", SignedHeaders="+signed_headers+", Signature="+signature)
+}
+
+// Send a signed request on an object (the bucket when the key is empty): a body requires its
+// payload hash and its length
+func (s *s3Store) do(ctx context.Context, method string, key string, header http.Header, body io.Reader, payload_hash string, length int64) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.ContentLength = length
+	} else {
+		payload_hash = emptyPayloadHash
+	}
+	s.sign(req, payload_hash)
+
+	// Send request (the server is unavailable on network or server errors)
+	resp, err := s.client.Do(req)
+	if ctx_err := ctx.Err(); ctx_err != nil {
+		return nil, ctx_err
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %w", utils.ErrUnavailable, err)
+	} else if resp.StatusCode >= http.StatusInternalServerError {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: S3 %s %s: %s", utils.ErrUnavailable, method, u.Path, resp.Status)
+	}
+	return resp, nil
+}
+
+// Convert an unexpected S3 response to an error
+func s3Error(resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrBlobNotFound
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("S3 %s %s: %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, message)
+}
+
+// Upload a file as an object with its content hash
+func (s *s3Store) upload(ctx context.Context, key string, file *os.File, size int64, sum string) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	header := http.Header{s3HashHeader: {sum}, "Content-Type": {"application/octet-stream"}}
+	resp, err := s.do(ctx, http.MethodPut, key, header, file, sum, size)
+	if err != nil {
+		return err
+	} else if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Compute the content hash of an object version and save it in its metadata (the object is copied
+// onto itself, unless it was replaced meanwhile)
+func (s *s3Store) hashObject(ctx context.Context, key string, etag string) (string, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, http.Header{"If-Match": {etag}}, nil, "", 0)
+	if err != nil {
+		return "", err
+	} else if resp.StatusCode != http.StatusOK {
+		return "", s3Error(resp)
+	}
+	defer resp.Body.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	// Replace object metadata
+	header := http.Header{
+		s3HashHeader:                 {sum},
+		"X-Amz-Copy-Source":          {"/" + s.bucket + "/" + key},
+		"X-Amz-Copy-Source-If-Match": {etag},
+		"X-Amz-Metadata-Directive":   {"REPLACE"},
+		"Content-Type":               {"application/octet-stream"},
+	}
+	copy_resp, err := s.do(ctx, http.MethodPut, key, header, nil, "", 0)
+	if err != nil {
+		return "", err
+	} else if copy_resp.StatusCode != http.StatusOK {
+		return "", s3Error(copy_resp)
+	}
+	copy_resp.Body.Close()
+	return sum, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, r io.Reader, expected string) (Blob, error) {
+	if err := checkKey(key); err != nil {
+		return Blob{}, err
+	}
+
+	// Spool blob to a temporary file while computing its hash (required to sign the upload)
+	file, err := os.CreateTemp("", "melo-webapi-upload-*")
+	if err != nil {
+		return Blob{}, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), r)
+	if err != nil {
+		return Blob{}, err
+	}
+
+	// Upload blob
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if expected != "" && sum != expected {
+		return Blob{}, ErrChecksumMismatch
+	}
+	if err := s.upload(ctx, key, file, size, sum); err != nil {
+		return Blob{}, err
+	}
+
+	return s.Stat(ctx, key)
+}
+
+func (s *s3Store) Stat(ctx context.Context, key string) (Blob, error) {
+	obj, err := s.Open(ctx, key)
+	if err != nil {
+		return Blob{}, err
+	}
+	return obj.Info(), nil
+}
+
+// Opened object: its content is read with the entity tag of the object when opened
+type s3Object struct {
+	store *s3Store
+	etag  string
+	blob  Blob
+}
+
+// Get object metadata
+func (s *s3Store) head(ctx context.Context, key string) (*s3Object, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, "", 0)
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, s3Error(resp)
+	}
+	resp.Body.Close()
+	obj := &s3Object{store: s, etag: resp.Header.Get("ETag"), blob: Blob{Key: key, Sha256: resp.Header.Get(s3HashHeader)}}
+	if obj.blob.Size, err = strconv.ParseUint(resp.Header.Get("Content-Length"), 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid S3 object size: %w", err)
+	}
+	if date, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		obj.blob.Date = uint64(date.Unix())
+	}
+	return obj, nil
+}
+
+func (s *s3Store) Open(ctx context.Context, key string) (Object, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	// Get object metadata
+	obj, err := s.head(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compute content hash when missing (the metadata of the copied object are read again)
+	if obj.blob.Sha256 == "" {
+		if _, err := s.hashObject(ctx, key, obj.etag); err != nil {
+			return nil, err
+		}
+		if obj, err = s.head(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+
+	return obj, nil
+}
+
+func (o *s3Object) Info() Blob {
+	return o.blob
+}
+
+func (o *s3Object) ReadRange(ctx context.Context, offset uint64, length uint64) (io.ReadCloser, error) {
+	// Get object range (only when unchanged)
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	header := http.Header{
+		"Range":    {fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)},
+		"If-Match": {o.etag},
+	}
+	resp, err := o.store.do(ctx, http.MethodGet, o.blob.Key, header, nil, "", 0)
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode == http.StatusPreconditionFailed {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: S3 object %s replaced while reading", utils.ErrUnavailable, o.blob.Key)
+	} else if resp.StatusCode == http.StatusOK && (offset != 0 || length != o.blob.Size) {
+		// The whole object is only expected when the range covers it
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: S3 object %s range ignored by the server", utils.ErrUnavailable, o.blob.Key)
+	} else if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		return nil, s3Error(resp)
+	}
+	return resp.Body, nil
+}
+
+func (o *s3Object) Close() error {
+	return nil
+}
+
+func (s *s3Store) Remove(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	// Check object exists with its metadata only (deleting a missing object is not an error)
+	if _, err := s.head(ctx, key); err != nil {
+		return err
+	}
+
+	// Remove object
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, "", 0)
+	if err != nil {
+		return err
+	} else if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Store) Ping(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodHead, "", nil, nil, "", 0)
+	if err != nil {
+		return err
+	} else if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return fmt.Errorf("%w: S3 bucket %s: %s", utils.ErrUnavailable, s.bucket, resp.Status)
+	}
+	resp.Body.Close()
+	return nil
+}
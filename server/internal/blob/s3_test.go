@@ -0,0 +1,219 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// Open the S3 store of the integration tests, run against a S3 compatible server (like MinIO) set
+// with the MELO_WEBAPI_TEST_S3_* environment variables: the tests are skipped when no endpoint is
+// set, and the bucket is created when missing.
+func openTestS3Store(t *testing.T) *s3Store {
+	endpoint := os.Getenv("MELO_WEBAPI_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MELO_WEBAPI_TEST_S3_ENDPOINT is not set")
+	}
+	bucket := os.Getenv("MELO_WEBAPI_TEST_S3_BUCKET")
+	if bucket == "" {
+		bucket = "melo-webapi-test"
+	}
+	store, err := NewS3Store(endpoint, "us-east-1", bucket, os.Getenv("MELO_WEBAPI_TEST_S3_ACCESS_KEY"), os.Getenv("MELO_WEBAPI_TEST_S3_SECRET_KEY"))
+	if err != nil {
+		t.Fatalf("failed to create S3 store: %s", err)
+	}
+	s := store.(*s3Store)
+
+	// Create bucket
+	ctx := context.Background()
+	if err := s.Ping(ctx); err != nil {
+		resp, err := s.do(ctx, http.MethodPut, "", nil, nil, "", 0)
+		if err != nil {
+			t.Fatalf("failed to create bucket: %s", err)
+		} else if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to create bucket: %s", s3Error(resp))
+		}
+		resp.Body.Close()
+	}
+	return s
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Read a range of an opened blob
+func readRange(t *testing.T, obj Object, offset uint64, length uint64) ([]byte, error) {
+	reader, err := obj.ReadRange(context.Background(), offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func TestS3Store(t *testing.T) {
+	s := openTestS3Store(t)
+	ctx := context.Background()
+	key := "test-" + t.Name() + ".bin"
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	t.Cleanup(func() { s.Remove(ctx, key) })
+
+	// Checksum mismatch
+	if _, err := s.Put(ctx, key, bytes.NewReader(data), sha256Hex(nil)); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Put with wrong checksum: got %v, want %v", err, ErrChecksumMismatch)
+	}
+	if _, err := s.Stat(ctx, key); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("Stat after mismatch: got %v, want %v", err, ErrBlobNotFound)
+	}
+
+	// Put and stat
+	blob, err := s.Put(ctx, key, bytes.NewReader(data), sha256Hex(data))
+	if err != nil {
+		t.Fatalf("Put: %s", err)
+	} else if blob.Key != key || blob.Size != uint64(len(data)) || blob.Sha256 != sha256Hex(data) || blob.Date == 0 {
+		t.Fatalf("Put: got %+v", blob)
+	}
+	if stat, err := s.Stat(ctx, key); err != nil || stat != blob {
+		t.Fatalf("Stat: got %+v, %v, want %+v", stat, err, blob)
+	}
+
+	// Read ranges
+	obj, err := s.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer obj.Close()
+	for _, test := range []struct {
+		name   string
+		offset uint64
+		length uint64
+	}{
+		{"whole", 0, uint64(len(data))},
+		{"start", 0, 10},
+		{"middle", 1234, 5678},
+		{"end", uint64(len(data)) - 1, 1},
+		{"empty", 0, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := readRange(t, obj, test.offset, test.length)
+			if err != nil {
+				t.Fatalf("ReadRange: %s", err)
+			} else if want := data[test.offset : test.offset+test.length]; !bytes.Equal(got, want) {
+				t.Fatalf("ReadRange: got %d bytes, want %d bytes", len(got), len(want))
+			}
+		})
+	}
+
+	// Replace blob: the opened version cannot be read anymore
+	if _, err := s.Put(ctx, key, bytes.NewReader(data[:100]), ""); err != nil {
+		t.Fatalf("Put replacement: %s", err)
+	}
+	if _, err := readRange(t, obj, 0, 10); !errors.Is(err, utils.ErrUnavailable) {
+		t.Fatalf("ReadRange of replaced blob: got %v, want %v", err, utils.ErrUnavailable)
+	}
+
+	// Remove
+	if err := s.Remove(ctx, key); err != nil {
+		t.Fatalf("Remove: %s", err)
+	}
+	if _, err := s.Open(ctx, key); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("Open after Remove: got %v, want %v", err, ErrBlobNotFound)
+	}
+	if err := s.Remove(ctx, key); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("Remove twice: got %v, want %v", err, ErrBlobNotFound)
+	}
+}
+
+func TestS3StoreHashMissing(t *testing.T) {
+	s := openTestS3Store(t)
+	ctx := context.Background()
+	key := "test-" + t.Name() + ".bin"
+	data := []byte("uploaded with another tool")
+	t.Cleanup(func() { s.Remove(ctx, key) })
+
+	// Upload object without its content hash
+	resp, err := s.do(ctx, http.MethodPut, key, nil, bytes.NewReader(data), sha256Hex(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("failed to upload object: %s", err)
+	} else if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to upload object: %s", s3Error(resp))
+	}
+	resp.Body.Close()
+
+	// The hash is computed on first access and saved
+	for _, name := range []string{"computed", "saved"} {
+		blob, err := s.Stat(ctx, key)
+		if err != nil {
+			t.Fatalf("Stat (%s): %s", name, err)
+		} else if blob.Size != uint64(len(data)) || blob.Sha256 != sha256Hex(data) {
+			t.Fatalf("Stat (%s): got %+v", name, blob)
+		}
+	}
+}
+
+func TestS3StoreInvalidKey(t *testing.T) {
+	s := openTestS3Store(t)
+	ctx := context.Background()
+	for _, key := range []string{"", ".hidden", "../escape", "a/b"} {
+		if _, err := s.Open(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Open(%q): got %v, want %v", key, err, ErrInvalidKey)
+		}
+	}
+}
+
+func TestS3StoreIgnoredRequests(t *testing.T) {
+	// Serve an object ignoring the ranges, and record the requests
+	data := []byte("0123456789")
+	methods := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		switch r.Method {
+		case http.MethodHead, http.MethodGet:
+			w.Header().Set("ETag", `"etag"`)
+			w.Header().Set(s3HashHeader, sha256Hex(data))
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data)
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+	store, err := NewS3Store(server.URL, "us-east-1", "bucket", "access", "secret")
+	if err != nil {
+		t.Fatalf("failed to create S3 store: %s", err)
+	}
+	ctx := context.Background()
+
+	// The object is only checked with its metadata before its removal
+	if err := store.Remove(ctx, "blob"); err != nil {
+		t.Fatalf("failed to remove blob: %s", err)
+	} else if !slices.Equal(methods, []string{http.MethodHead, http.MethodDelete}) {
+		t.Fatalf("got requests %v, want HEAD then DELETE", methods)
+	}
+
+	// The whole object is only accepted for a range covering it
+	obj, err := store.Open(ctx, "blob")
+	if err != nil {
+		t.Fatalf("failed to open blob: %s", err)
+	}
+	defer obj.Close()
+	if got, err := readRange(t, obj, 0, uint64(len(data))); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("whole range: got %q, %v, want %q", got, err, data)
+	}
+	if _, err := readRange(t, obj, 2, 3); !errors.Is(err, utils.ErrUnavailable) {
+		t.Fatalf("ignored range: got %v, want %v", err, utils.ErrUnavailable)
+	}
+}
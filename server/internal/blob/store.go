@@ -0,0 +1,60 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"regexp"
+)
+
+// Blob is a stored artifact (release update, plugin archive, ...) with its content hash
+type Blob struct {
+	Key    string `json:"key" example:"melo-1.2.0-aarch64.swu" doc:"The key of the artifact"`
+	Size   uint64 `json:"size" example:"52428800" doc:"The size of the artifact in bytes"`
+	Sha256 string `json:"sha256" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" doc:"The SHA-256 checksum of the artifact"`
+	Date   uint64 `json:"date" example:"0" doc:"The last modification timestamp as Unix epoch"`
+}
+
+// Object is an opened blob: its content is read from the version described by Info, even when the
+// blob is replaced meanwhile (or an error is returned).
+type Object interface {
+	// Get the size and the content hash of the opened blob
+	Info() Blob
+	// Read length bytes from offset
+	ReadRange(ctx context.Context, offset uint64, length uint64) (io.ReadCloser, error)
+	// Close the object
+	Close() error
+}
+
+// BlobStore is the storage backend of the artifacts.
+//
+// A blob is identified by a key matching KeyPattern. The errors are ErrBlobNotFound,
+// ErrInvalidKey, ErrChecksumMismatch, utils.ErrUnavailable or another storage error.
+type BlobStore interface {
+	// Store a blob or replace it: the content hash is computed while storing, and the blob is not
+	// stored when it does not match the expected hash (when set)
+	Put(ctx context.Context, key string, r io.Reader, sha256 string) (Blob, error)
+	// Get the size and the content hash of a blob
+	Stat(ctx context.Context, key string) (Blob, error)
+	// Open a blob to read its content (the object must be closed)
+	Open(ctx context.Context, key string) (Object, error)
+	// Remove a blob
+	Remove(ctx context.Context, key string) error
+	// Ping checks the storage is reachable
+	Ping(ctx context.Context) error
+}
+
+// KeyPattern is the pattern of the blob keys: a file name not starting with a dot
+const KeyPattern = "^[A-Za-z0-9][A-Za-z0-9._-]*$"
+
+// Maximum length of a blob key
+const keyMaxLength = 255
+
+var keyRegexp = regexp.MustCompile(KeyPattern)
+
+// Check a blob key
+func checkKey(key string) error {
+	if len(key) > keyMaxLength || !keyRegexp.MatchString(key) {
+		return ErrInvalidKey
+	}
+	return nil
+}
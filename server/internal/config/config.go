@@ -0,0 +1,533 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Database configuration
+type Database struct {
+	Backend         string        `yaml:"backend"`
+	Dsn             string        `yaml:"dsn"`
+	Mysql           Server        `yaml:"mysql"`
+	Postgres        Server        `yaml:"postgres"`
+	SqlitePath      string        `yaml:"sqlite_path"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	RetryInterval   time.Duration `yaml:"retry_interval"`
+}
+
+// Database server login
+type Server struct {
+	Hostname string `yaml:"hostname"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Database string `yaml:"database"`
+}
+
+// HTTP server configuration
+type Http struct {
+	Listen          string        `yaml:"listen"`
+	CorsOrigins     []string      `yaml:"cors_origins"`
+	RealIpHeader    string        `yaml:"real_ip_header"`
+	TrustedProxies  []string      `yaml:"trusted_proxies"`
+	DrainPeriod     time.Duration `yaml:"drain_period"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// Device registry configuration
+type Device struct {
+	HeartbeatTimeout time.Duration `yaml:"heartbeat_timeout"`
+	OfflineRetention time.Duration `yaml:"offline_retention"`
+	Ipv6Prefix       int           `yaml:"ipv6_prefix"`
+	MergeWindow      time.Duration `yaml:"merge_window"`
+}
+
+// Release configuration
+type Release struct {
+	CheckRetention time.Duration `yaml:"check_retention"`
+}
+
+// Signing configuration of the manifests and verification of the artifacts
+type Signing struct {
+	Key          string        `yaml:"key"`
+	PreviousKeys []string      `yaml:"previous_keys"`
+	ArtifactKeys []string      `yaml:"artifact_keys"`
+	ManifestTtl  time.Duration `yaml:"manifest_ttl"`
+}
+
+// Artifact storage configuration
+type Artifacts struct {
+	Backend string `yaml:"backend"`
+	Path    string `yaml:"path"`
+	S3      S3     `yaml:"s3"`
+}
+
+// S3 compatible server configuration
+type S3 struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+}
+
+// Config is the server configuration
+type Config struct {
+	Url       string    `yaml:"url"`
+	AdminKey  string    `yaml:"admin_key"`
+	Http      Http      `yaml:"http"`
+	Database  Database  `yaml:"database"`
+	Device    Device    `yaml:"device"`
+	Release   Release   `yaml:"release"`
+	Signing   Signing   `yaml:"signing"`
+	Artifacts Artifacts `yaml:"artifacts"`
+}
+
+// Default returns the default configuration
+func Default() Config {
+	return Config{
+		Http: Http{
+			Listen:          "0.0.0.0:8888",
+			CorsOrigins:     []string{"https://*", "http://*"},
+			DrainPeriod:     5 * time.Second,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Database: Database{
+			Backend:         "mysql",
+			SqlitePath:      "melo-webapi.db",
+			MaxOpenConns:    10,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: 3 * time.Minute,
+			RetryInterval:   10 * time.Second,
+		},
+		Device: Device{
+			HeartbeatTimeout: 0,
+			OfflineRetention: 30 * 24 * time.Hour,
+			Ipv6Prefix:       64,
+			MergeWindow:      0,
+		},
+		Release: Release{
+			CheckRetention: 90 * 24 * time.Hour,
+		},
+		Signing: Signing{
+			ManifestTtl: 7 * 24 * time.Hour,
+		},
+		Artifacts: Artifacts{
+			Backend: "none",
+			Path:    "artifacts",
+			S3: S3{
+				Region: "us-east-1",
+			},
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the configuration file, the environment and
+// the command line (in this order of precedence): print is set with --print-config.
+func Load(args []string) (cfg Config, print bool, err error) {
+	// Find configuration file (the command line is parsed again once the file is loaded)
+	var path string
+	dummy := Default()
+	if err := newFlagSet(&dummy, &path, &print).Parse(args); err != nil {
+		return cfg, false, err
+	}
+	if path == "" {
+		path = os.Getenv("MELO_WEBAPI_CONFIG")
+	}
+
+	// Load configuration file
+	cfg = Default()
+	if path != "" {
+		if err := loadFile(&cfg, path); err != nil {
+			return cfg, false, err
+		}
+	}
+
+	// Override with environment
+	if err := loadEnv(&cfg); err != nil {
+		return cfg, false, err
+	}
+
+	// Override with command line
+	if err := newFlagSet(&cfg, &path, &print).Parse(args); err != nil {
+		return cfg, false, err
+	}
+
+	return cfg, print, cfg.Validate()
+}
+
+func loadFile(cfg *Config, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open configuration file: %w", err)
+	}
+	defer file.Close()
+
+	// Parse YAML (unknown keys are rejected to detect typos)
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Environment variable parsers
+type stringValue struct{ value *string }
+type intValue struct{ value *int }
+type durationValue struct{ value *time.Duration }
+type listValue struct{ value *[]string }
+
+func (v stringValue) Set(str string) error {
+	*v.value = str
+	return nil
+}
+
+func (v intValue) Set(str string) error {
+	value, err := strconv.Atoi(str)
+	if err != nil {
+		return errors.New("invalid number")
+	}
+	*v.value = value
+	return nil
+}
+
+func (v durationValue) Set(str string) error {
+	// Parse duration (as "90s", "5m", "720h"...)
+	value, err := time.ParseDuration(str)
+	if err != nil {
+		return errors.New("invalid duration")
+	}
+	*v.value = value
+	return nil
+}
+
+func (v listValue) Set(str string) error {
+	// Parse comma separated list
+	list := []string{}
+	for _, item := range strings.Split(str, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*v.value = list
+	return nil
+}
+
+func (v stringValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+	return *v.value
+}
+
+func (v intValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+	return strconv.Itoa(*v.value)
+}
+
+func (v durationValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+	return v.value.String()
+}
+
+func (v listValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+	return strings.Join(*v.value, ",")
+}
+
+func loadEnv(cfg *Config) error {
+	db := &cfg.Database
+	for name, value := range map[string]flag.Value{
+		"MELO_WEBAPI_URL":               stringValue{&cfg.Url},
+		"MELO_WEBAPI_ADMIN_KEY":         stringValue{&cfg.AdminKey},
+		"MELO_WEBAPI_LISTEN":            stringValue{&cfg.Http.Listen},
+		"MELO_WEBAPI_CORS_ORIGINS":      listValue{&cfg.Http.CorsOrigins},
+		"MELO_WEBAPI_REAL_IP_HEADER":    stringValue{&cfg.Http.RealIpHeader},
+		"MELO_WEBAPI_TRUSTED_PROXIES":   listValue{&cfg.Http.TrustedProxies},
+		"MELO_WEBAPI_DRAIN_PERIOD":      durationValue{&cfg.Http.DrainPeriod},
+		"MELO_WEBAPI_SHUTDOWN_TIMEOUT":  durationValue{&cfg.Http.ShutdownTimeout},
+		"MELO_WEBAPI_BACKEND":           stringValue{&db.Backend},
+		"MELO_WEBAPI_DATABASE_DSN":      stringValue{&db.Dsn},
+		"MELO_WEBAPI_MYSQL_HOSTNAME":    stringValue{&db.Mysql.Hostname},
+		"MELO_WEBAPI_MYSQL_USER":        stringValue{&db.Mysql.User},
+		"MELO_WEBAPI_MYSQL_PASSWORD":    stringValue{&db.Mysql.Password},
+		"MELO_WEBAPI_MYSQL_DATABASE":    stringValue{&db.Mysql.Database},
+		"MELO_WEBAPI_POSTGRES_HOSTNAME": stringValue{&db.Postgres.Hostname},
+		"MELO_WEBAPI_POSTGRES_USER":     stringValue{&db.Postgres.User},
+		"MELO_WEBAPI_POSTGRES_PASSWORD": stringValue{&db.Postgres.Password},
+		"MELO_WEBAPI_POSTGRES_DATABASE": stringValue{&db.Postgres.Database},
+		"MELO_WEBAPI_SQLITE_PATH":       stringValue{&db.SqlitePath},
+		"MELO_WEBAPI_DB_MAX_OPEN_CONNS": intValue{&db.MaxOpenConns},
+		"MELO_WEBAPI_DB_MAX_IDLE_CONNS": intValue{&db.MaxIdleConns},
+		"MELO_WEBAPI_DB_CONN_LIFETIME":  durationValue{&db.ConnMaxLifetime},
+		"MELO_WEBAPI_DB_RETRY_INTERVAL": durationValue{&db.RetryInterval},
+		"MELO_WEBAPI_HEARTBEAT_TIMEOUT": durationValue{&cfg.Device.HeartbeatTimeout},
+		"MELO_WEBAPI_OFFLINE_RETENTION": durationValue{&cfg.Device.OfflineRetention},
+		"MELO_WEBAPI_IPV6_PREFIX":       intValue{&cfg.Device.Ipv6Prefix},
+		"MELO_WEBAPI_MERGE_WINDOW":      durationValue{&cfg.Device.MergeWindow},
+		"MELO_WEBAPI_CHECK_RETENTION":   durationValue{&cfg.Release.CheckRetention},
+		"MELO_WEBAPI_SIGNING_KEY":       stringValue{&cfg.Signing.Key},
+		"MELO_WEBAPI_PREVIOUS_KEYS":     listValue{&cfg.Signing.PreviousKeys},
+		"MELO_WEBAPI_ARTIFACT_KEYS":     listValue{&cfg.Signing.ArtifactKeys},
+		"MELO_WEBAPI_MANIFEST_TTL":      durationValue{&cfg.Signing.ManifestTtl},
+		"MELO_WEBAPI_ARTIFACTS_BACKEND": stringValue{&cfg.Artifacts.Backend},
+		"MELO_WEBAPI_ARTIFACTS_PATH":    stringValue{&cfg.Artifacts.Path},
+		"MELO_WEBAPI_S3_ENDPOINT":       stringValue{&cfg.Artifacts.S3.Endpoint},
+		"MELO_WEBAPI_S3_REGION":         stringValue{&cfg.Artifacts.S3.Region},
+		"MELO_WEBAPI_S3_BUCKET":         stringValue{&cfg.Artifacts.S3.Bucket},
+		"MELO_WEBAPI_S3_ACCESS_KEY":     stringValue{&cfg.Artifacts.S3.AccessKey},
+		"MELO_WEBAPI_S3_SECRET_KEY":     stringValue{&cfg.Artifacts.S3.SecretKey},
+	} {
+		if str := os.Getenv(name); str != "" {
+			if err := value.Set(str); err != nil {
+				return fmt.Errorf("%s for %s: %s", err, name, str)
+			}
+		}
+	}
+	return nil
+}
+
+func newFlagSet(cfg *Config, path *string, print *bool) *flag.FlagSet {
+	flags := flag.NewFlagSet("melo-webapi", flag.ContinueOnError)
+	flags.StringVar(path, "config", "", "Path of the YAML configuration file (env: MELO_WEBAPI_CONFIG)")
+	flags.BoolVar(print, "print-config", false, "Print the effective configuration and exit")
+	flags.StringVar(&cfg.Url, "url", cfg.Url, "URL of the OpenAPI compliant Melo Web API")
+	flags.StringVar(&cfg.Http.Listen, "listen", cfg.Http.Listen, "Address and port to listen on")
+	flags.Var(listValue{&cfg.Http.CorsOrigins}, "cors-origins", "Comma separated list of CORS allowed origins")
+	flags.StringVar(&cfg.Http.RealIpHeader, "real-ip-header", cfg.Http.RealIpHeader, "HTTP header to read from the real IP address of the client (X-Forwarded-For, Forwarded, X-Real-Ip, ...)")
+	flags.Var(listValue{&cfg.Http.TrustedProxies}, "trusted-proxies", "Comma separated list of proxy addresses / networks allowed to set the real IP header (none when empty)")
+	flags.DurationVar(&cfg.Http.DrainPeriod, "drain-period", cfg.Http.DrainPeriod, "Duration between the readiness failure and the shutdown, to let the load balancers stop sending requests (0 to disable)")
+	flags.DurationVar(&cfg.Http.ShutdownTimeout, "shutdown-timeout", cfg.Http.ShutdownTimeout, "Maximum duration to wait for in-flight requests on shutdown")
+	flags.StringVar(&cfg.Database.Backend, "backend", cfg.Database.Backend, "Storage backend: mysql, postgres, sqlite or memory")
+	flags.StringVar(&cfg.Database.Dsn, "dsn", cfg.Database.Dsn, "Database DSN (overrides the MySQL / PostgreSQL login)")
+	flags.StringVar(&cfg.Database.SqlitePath, "sqlite-path", cfg.Database.SqlitePath, "Path of the SQLite database file")
+	flags.IntVar(&cfg.Database.MaxOpenConns, "db-max-open-conns", cfg.Database.MaxOpenConns, "Maximum number of open database connections (0 for unlimited)")
+	flags.IntVar(&cfg.Database.MaxIdleConns, "db-max-idle-conns", cfg.Database.MaxIdleConns, "Maximum number of idle database connections")
+	flags.DurationVar(&cfg.Database.ConnMaxLifetime, "db-conn-lifetime", cfg.Database.ConnMaxLifetime, "Maximum lifetime of a database connection (0 for unlimited)")
+	flags.DurationVar(&cfg.Database.RetryInterval, "db-retry-interval", cfg.Database.RetryInterval, "Interval between two database connection attempts on startup")
+	flags.DurationVar(&cfg.Device.HeartbeatTimeout, "heartbeat-timeout", cfg.Device.HeartbeatTimeout, "Duration without update after which a device is set offline (0 to disable)")
+	flags.DurationVar(&cfg.Device.OfflineRetention, "offline-retention", cfg.Device.OfflineRetention, "Duration without update after which an offline device is removed (0 to disable)")
+	flags.IntVar(&cfg.Device.Ipv6Prefix, "ipv6-prefix", cfg.Device.Ipv6Prefix, "Prefix length of the IPv6 networks grouping the devices")
+	flags.DurationVar(&cfg.Device.MergeWindow, "merge-window", cfg.Device.MergeWindow, "Maximum duration between the updates of a device seen on two networks to merge their device lists (0 to disable)")
+	flags.DurationVar(&cfg.Release.CheckRetention, "check-retention", cfg.Release.CheckRetention, "Duration after which the update check of a device is removed (0 to disable)")
+	flags.DurationVar(&cfg.Signing.ManifestTtl, "manifest-ttl", cfg.Signing.ManifestTtl, "Validity duration of the signed manifests")
+	flags.StringVar(&cfg.Artifacts.Backend, "artifacts-backend", cfg.Artifacts.Backend, "Artifact storage backend: none, local or s3")
+	flags.StringVar(&cfg.Artifacts.Path, "artifacts-path", cfg.Artifacts.Path, "Directory of the artifacts for the local storage backend")
+	flags.StringVar(&cfg.Artifacts.S3.Endpoint, "s3-endpoint", cfg.Artifacts.S3.Endpoint, "URL of the S3 compatible server of the artifacts")
+	flags.StringVar(&cfg.Artifacts.S3.Region, "s3-region", cfg.Artifacts.S3.Region, "Region of the S3 bucket (signed in the requests)")
+	flags.StringVar(&cfg.Artifacts.S3.Bucket, "s3-bucket", cfg.Artifacts.S3.Bucket, "S3 bucket of the artifacts")
+	flags.StringVar(&cfg.Artifacts.S3.AccessKey, "s3-access-key", cfg.Artifacts.S3.AccessKey, "Access key of the S3 server")
+	flags.StringVar(&cfg.Artifacts.S3.SecretKey, "s3-secret-key", cfg.Artifacts.S3.SecretKey, "Secret key of the S3 server (prefer MELO_WEBAPI_S3_SECRET_KEY: the command line is visible to the other users)")
+	return flags
+}
+
+// Validate checks the configuration values
+func (c *Config) Validate() error {
+	// Check HTTP server
+	if _, _, err := net.SplitHostPort(c.Http.Listen); err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", c.Http.Listen, err)
+	}
+	if len(c.Http.CorsOrigins) == 0 {
+		return errors.New("at least one CORS origin is required")
+	}
+	if _, err := ParseNetworks(c.Http.TrustedProxies); err != nil {
+		return err
+	}
+	if c.Http.DrainPeriod < 0 {
+		return errors.New("drain period cannot be negative")
+	}
+	if c.Http.ShutdownTimeout <= 0 {
+		return errors.New("shutdown timeout must be positive")
+	}
+
+	// Check database
+	switch c.Database.Backend {
+	case "mysql", "postgres", "memory":
+	case "sqlite":
+		if c.Database.SqlitePath == "" && c.Database.Dsn == "" {
+			return errors.New("SQLite database path is required")
+		}
+	default:
+		return fmt.Errorf("invalid storage backend: %s", c.Database.Backend)
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		return errors.New("database pool sizes cannot be negative")
+	}
+	if c.Database.ConnMaxLifetime < 0 || c.Database.RetryInterval <= 0 {
+		return errors.New("invalid database connection durations")
+	}
+
+	// Check device registry
+	if c.Device.HeartbeatTimeout < 0 || c.Device.OfflineRetention < 0 || c.Device.MergeWindow < 0 {
+		return errors.New("device durations cannot be negative")
+	}
+	if c.Device.Ipv6Prefix < 1 || c.Device.Ipv6Prefix > 128 {
+		return fmt.Errorf("invalid IPv6 prefix length %d", c.Device.Ipv6Prefix)
+	}
+
+	// Check releases
+	if c.Release.CheckRetention < 0 {
+		return errors.New("update check retention cannot be negative")
+	}
+
+	// Check signing keys
+	if key, err := ParseSigningKey(c.Signing.Key); err != nil {
+		return err
+	} else if key == nil && len(c.Signing.PreviousKeys) > 0 {
+		return errors.New("previous signing keys require a signing key")
+	}
+	if _, err := ParsePreviousKeys(c.Signing.PreviousKeys); err != nil {
+		return err
+	}
+	if _, err := ParseArtifactKeys(c.Signing.ArtifactKeys); err != nil {
+		return err
+	}
+	if c.Signing.ManifestTtl <= 0 {
+		return errors.New("manifest validity must be positive")
+	}
+
+	// Check artifact storage
+	switch c.Artifacts.Backend {
+	case "none":
+	case "local":
+		if c.Artifacts.Path == "" {
+			return errors.New("artifacts path is required")
+		}
+	case "s3":
+		if c.Artifacts.S3.Endpoint == "" || c.Artifacts.S3.Bucket == "" || c.Artifacts.S3.Region == "" {
+			return errors.New("S3 endpoint, region and bucket are required")
+		}
+	default:
+		return fmt.Errorf("invalid artifact storage backend: %s", c.Artifacts.Backend)
+	}
+
+	return nil
+}
+
+// ParseNetworks parses a list of IP addresses and networks (in CIDR notation)
+func ParseNetworks(list []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, item := range list {
+		// Single address
+		if ip := net.ParseIP(item); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		// Network
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", item)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ParseSigningKey parses a base64 encoded Ed25519 private key (the 32 bytes seed or the 64 bytes
+// private key), nil when empty
+func ParseSigningKey(str string) (ed25519.PrivateKey, error) {
+	if str == "" {
+		return nil, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(str)
+	switch {
+	case err == nil && len(data) == ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(data), nil
+	case err == nil && len(data) == ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(data), nil
+	default:
+		return nil, errors.New("invalid signing key")
+	}
+}
+
+// ParsePreviousKeys parses a list of base64 encoded Ed25519 private keys (see ParseSigningKey)
+func ParsePreviousKeys(list []string) ([]ed25519.PrivateKey, error) {
+	keys := []ed25519.PrivateKey{}
+	for index, item := range list {
+		key, err := ParseSigningKey(item)
+		if err != nil || key == nil {
+			return nil, fmt.Errorf("invalid previous signing key #%d", index+1)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ParseArtifactKeys parses a list of base64 encoded Ed25519 public keys
+func ParseArtifactKeys(list []string) ([]ed25519.PublicKey, error) {
+	keys := []ed25519.PublicKey{}
+	for index, item := range list {
+		data, err := base64.StdEncoding.DecodeString(item)
+		if err != nil || len(data) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid artifact key #%d", index+1)
+		}
+		keys = append(keys, ed25519.PublicKey(data))
+	}
+	return keys, nil
+}
+
+// Matches the password of a MySQL DSN (user:password@...)
+var mysqlPassword = regexp.MustCompile(`^([^:@/]*):[^@]*@`)
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "xxxxx"
+}
+
+func redactDsn(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return mysqlPassword.ReplaceAllString(dsn, "${1}:xxxxx@")
+}
+
+// Print writes the configuration as YAML with the secrets hidden
+func (c Config) Print(w io.Writer) error {
+	c.AdminKey = redact(c.AdminKey)
+	c.Database.Dsn = redactDsn(c.Database.Dsn)
+	c.Database.Mysql.Password = redact(c.Database.Mysql.Password)
+	c.Database.Postgres.Password = redact(c.Database.Postgres.Password)
+	c.Signing.Key = redact(c.Signing.Key)
+	previous_keys := []string{}
+	for _, key := range c.Signing.PreviousKeys {
+		previous_keys = append(previous_keys, redact(key))
+	}
+	c.Signing.PreviousKeys = previous_keys
+	c.Artifacts.S3.SecretKey = redact(c.Artifacts.S3.SecretKey)
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
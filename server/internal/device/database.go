@@ -3,129 +3,163 @@ package device
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/dillya/melo-webapi/internal/utils"
-
-	log "github.com/sirupsen/logrus"
 )
 
-func InitializeTables(db *sql.DB) bool {
-	const version = 1
+// SQL device store
+type sqlStore struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	dialect utils.Dialect
+	queries sqlQueries
+}
 
-	// Get version
-	table_version := utils.GetTableVersion(db, "device")
-	if table_version == version {
-		return true
-	}
+// Dialect specific queries
+type sqlQueries struct {
+	get_device_id    string
+	lock_device      string
+	get_device       string
+	list_networks    string
+	linked_networks  string
+	search_devices   string
+	add_device       string
+	remove_device    string
+	update_status    string
+	add_address      string
+	remove_address   string
+	remove_addresses string
+	get_token_hash   string
+	set_token_hash   string
+	list_reapable    string
+	expire_online    string
+	purge_offline    string
+}
 
-	log.Infof("recreate Device tables due to update: %d -> %d", table_version, version)
+func newSqlQueries(d utils.Dialect) sqlQueries {
+	// SQLite has no row lock: its transactions lock the database (see "_txlock=immediate")
+	for_update := " FOR UPDATE"
+	if d == utils.SQLite {
+		for_update = ""
+	}
 
-	// Remove previous tables
-	_, err := db.Exec("DROP TABLE IF EXISTS device_iface, device CASCADE")
-	if err != nil {
-		log.Errorf("failed to drop old tables: %s", err)
-		return false
-	}
-
-	// Create device table
-	device := `CREATE TABLE device (
-  id INT(11) NOT NULL AUTO_INCREMENT,
-  ip INT(10) unsigned NOT NULL,
-  serial VARCHAR(17) NOT NULL,
-  name VARCHAR(128) NOT NULL,
-  description VARCHAR(256),
-  icon TINYINT(3) unsigned NOT NULL DEFAULT 0,
-  location VARCHAR(128),
-  http_port MEDIUMINT(9) NOT NULL,
-  https_port MEDIUMINT(9) NOT NULL DEFAULT 0,
-  online BOOL DEFAULT FALSE,
-  last_update BIGINT(4) UNSIGNED NOT NULL,
-  PRIMARY KEY (id),
-  UNIQUE KEY serial_ip (serial,ip),
-  KEY serial (serial),
-  KEY ip (ip)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_uca1400_ai_ci;`
-	_, err = db.Exec(device)
-	if err != nil {
-		log.Errorf("failed to create device table: %s", err)
-		return false
-	}
-
-	// Create device_iface table
-	device_iface := `CREATE TABLE device_iface (
-  id INT(11) NOT NULL AUTO_INCREMENT,
-  device_id INT(11) NOT NULL,
-  ipv4 INT(10) UNSIGNED,
-  ipv6 VARBINARY(16),
-  mac BIGINT(20) UNSIGNED NOT NULL,
-  name VARCHAR(128) NOT NULL DEFAULT 'Unknown',
-  type INT(11) NOT NULL DEFAULT 0,
-  PRIMARY KEY (id),
-  UNIQUE KEY device_id_mac (device_id,mac),
-  CONSTRAINT device_iface_constraint FOREIGN KEY (device_id) REFERENCES device (id) ON DELETE CASCADE
-) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_uca1400_ai_ci;`
-	_, err = db.Exec(device_iface)
-	if err != nil {
-		log.Errorf("failed to create device interface table: %s", err)
-		return false
+	return sqlQueries{
+		get_device_id: d.Rebind("SELECT id FROM device WHERE ip=" + d.Inet6Aton("?") + " AND serial=?"),
+		lock_device:   d.Rebind("SELECT id FROM device WHERE ip=" + d.Inet6Aton("?") + " AND serial=?" + for_update),
+		get_device: d.Rebind("SELECT " + deviceColumns + ", " + interfaceColumns(d) + " FROM device" +
+			" LEFT JOIN device_iface ON device_iface.device_id=device.id WHERE device.ip=" + d.Inet6Aton("?") + " AND device.serial=? ORDER BY device_iface.id"),
+		list_networks: "SELECT " + d.Inet6Ntoa("ip") + ", COUNT(*), SUM(CASE WHEN online THEN 1 ELSE 0 END) FROM device GROUP BY ip ORDER BY ip",
+		linked_networks: d.Rebind("SELECT DISTINCT " + d.Inet6Ntoa("other.ip") + " FROM device AS mine" +
+			" JOIN device AS other ON other.serial=mine.serial AND other.token_hash=mine.token_hash AND other.ip<>mine.ip" +
+			" WHERE mine.ip=" + d.Inet6Aton("?") + " AND mine.token_hash IS NOT NULL" +
+			" AND other.last_update+?>=mine.last_update AND other.last_update<=mine.last_update+?"),
+		search_devices: d.Rebind("SELECT " + deviceColumns + ", " + interfaceColumns(d) + ", " + d.Inet6Ntoa("device.ip") + " FROM device" +
+			" LEFT JOIN device_iface ON device_iface.device_id=device.id WHERE (? = '' OR device.ip=" + d.Inet6Aton("?") + ")" +
+			" AND (LOWER(device.serial) LIKE ? ESCAPE '!' OR LOWER(device.name) LIKE ? ESCAPE '!') ORDER BY device.ip, device.id, device_iface.id"),
+		add_device: d.Rebind(`INSERT INTO device
+(ip, serial, name, description, icon, location, http_port, https_port, online, last_update)
+VALUES (` + d.Inet6Aton("?") + `, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+` + d.Upsert([]string{"serial", "ip"}, "name", "description", "icon", "location", "http_port", "https_port", "online", "last_update")),
+		remove_device: d.Rebind("DELETE FROM device WHERE ip=" + d.Inet6Aton("?") + " AND serial=?"),
+		update_status: d.Rebind("UPDATE device SET online=?, last_update = ? WHERE ip = " + d.Inet6Aton("?") + " AND serial=?"),
+		add_address: d.Rebind(`INSERT INTO device_iface
+(device_id, mac, type, name, ipv4, ipv6)
+SELECT id, ` + d.MacAton("?") + `, ?, ?, ` + d.InetAton("?") + `, ` + d.Inet6Aton("?") + `
+FROM device WHERE ip=` + d.Inet6Aton("?") + ` AND serial=?
+` + d.Upsert([]string{"device_id", "mac"}, "type", "name", "ipv4", "ipv6")),
+		remove_address: d.Rebind("DELETE FROM device_iface WHERE device_id IN (SELECT id FROM device WHERE ip=" + d.Inet6Aton("?") + " AND serial=?) AND mac=" +
+			d.MacAton("?")),
+		remove_addresses: d.Rebind("DELETE FROM device_iface WHERE device_id IN (SELECT id FROM device WHERE ip=" + d.Inet6Aton("?") + " AND serial=?)"),
+		get_token_hash:   d.Rebind("SELECT token_hash FROM device WHERE ip=" + d.Inet6Aton("?") + " AND serial=?"),
+		set_token_hash:   d.Rebind("UPDATE device SET token_hash=? WHERE ip=" + d.Inet6Aton("?") + " AND serial=? AND token_hash IS NULL"),
+		list_reapable:    d.Rebind("SELECT id, " + d.Inet6Ntoa("ip") + ", serial FROM device WHERE online=? AND last_update < ?"),
+		expire_online:    d.Rebind("UPDATE device SET online=? WHERE id=? AND online=? AND last_update < ?"),
+		purge_offline:    d.Rebind("DELETE FROM device WHERE id=? AND online=? AND last_update < ?"),
 	}
+}
 
-	// Update version
-	return utils.UpdateTableVersion(db, "device", 1)
+// NewSQLStore creates a device store backed by a SQL database (MySQL / MariaDB, SQLite or PostgreSQL)
+func NewSQLStore(db *sql.DB, dialect utils.Dialect) DeviceStore {
+	return &sqlStore{db: db, dialect: dialect, queries: newSqlQueries(dialect)}
 }
 
-func listInterface(ctx context.Context, db *sql.DB, id uint) []DeviceInterface {
-	// Create interface list
-	list := []DeviceInterface{}
+// Query executor: a database or a transaction
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Get the query executor: the transaction of the store when bound (see Change), or the database
+func (s *sqlStore) conn() sqlExecutor {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+func (s *sqlStore) Change(ctx context.Context, ip string, serial string, fn func(tx DeviceStore) error) error {
+	// Already bound to a transaction
+	if s.tx != nil {
+		return fn(s)
+	}
 
-	// Fetch interfaces of the current device
-	ifaces, err := db.QueryContext(ctx, "SELECT type, name, mac, INET_NTOA(ipv4), INET6_NTOA(ipv6) FROM device_iface WHERE device_id=?", id)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.WithFields(log.Fields{"error": err}).Error("failed to get interface list")
-		return list
+		return utils.DbError(ctx, s.db, err)
 	}
-	defer ifaces.Close()
+	defer tx.Rollback()
 
-	// Generate list
-	for ifaces.Next() {
-		// Scan interface
-		var iface_type uint
-		var mac uint64
-		var name string
-		var ipv4, ipv6 []byte
-		if err := ifaces.Scan(&iface_type, &name, &mac, &ipv4, &ipv6); err != nil {
-			log.WithFields(log.Fields{"error": err}).Error("failed to scan interface")
-			continue
-		}
+	// Lock the device row until the end of the transaction (a missing device is not locked)
+	var id uint
+	if err := tx.QueryRowContext(ctx, s.queries.lock_device, ip, serial).Scan(&id); err != nil && err != sql.ErrNoRows {
+		return utils.DbError(ctx, s.db, err)
+	}
 
-		// Add interface to list
-		list = append(list, DeviceInterface{
-			Type:        InterfaceType.ToString(InterfaceType(iface_type)),
-			Name:        name,
-			MacAddress:  utils.Uint64ToHwAddress(mac),
-			Ipv4Address: string(ipv4),
-			Ipv6Address: string(ipv6),
-		})
+	// Apply changes and commit them
+	if err := fn(&sqlStore{db: s.db, tx: tx, dialect: s.dialect, queries: s.queries}); err != nil {
+		return err
 	}
+	if err := tx.Commit(); err != nil {
+		return utils.DbError(ctx, s.db, err)
+	}
+	return nil
+}
+
+// Check the device exists (used when no row is affected by a query)
+func (s *sqlStore) checkDevice(ctx context.Context, ip string, serial string) error {
+	var id uint
+	err := s.conn().QueryRowContext(ctx, s.queries.get_device_id, ip, serial).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrDeviceNotFound
+	} else if err != nil {
+		return utils.DbError(ctx, s.db, err)
+	}
+	return nil
+}
+
+// Device columns to use with scanDevices
+const deviceColumns = "device.id, device.name, device.serial, device.description, device.icon, device.location, device.http_port, " +
+	"device.https_port, device.online, device.last_update"
 
-	return list
+// Interface columns to use with scanDevices (NULL when the device has no interface)
+func interfaceColumns(d utils.Dialect) string {
+	return "device_iface.type, device_iface.name, " + d.MacNtoa("device_iface.mac") + ", " + d.InetNtoa("device_iface.ipv4") + ", " +
+		d.Inet6Ntoa("device_iface.ipv6")
 }
 
-func List(ctx context.Context, db *sql.DB, ip string) []Device {
+// Scan the devices joined with their interfaces (the rows of a device must be consecutive): the
+// callback is called on the first row of each device, when the extra columns are scanned.
+func scanDevices(rows *sql.Rows, on_device func(id uint), extra ...any) ([]Device, error) {
 	// Create device list
 	list := []Device{}
 
-	// Fetch devices
-	devices, err := db.QueryContext(ctx, "SELECT id, name, serial, description, icon, location, http_port, https_port, online, last_update FROM device WHERE ip=INET_ATON(?)", ip)
-	if err != nil {
-		log.WithFields(log.Fields{"error": err}).Error("failed to get device list")
-		return list
-	}
-	defer devices.Close()
-
 	// Generate list
-	for devices.Next() {
+	var last_id uint
+	for rows.Next() {
 		// Scan device
 		var online bool
 		var id, icon uint
@@ -133,42 +167,276 @@ func List(ctx context.Context, db *sql.DB, ip string) []Device {
 		var last_update uint64
 		var serial, name string
 		var description, location []byte
-		if err := devices.Scan(&id, &name, &serial, &description, &icon, &location, &http_port, &https_port, &online, &last_update); err != nil {
-			log.WithFields(log.Fields{"error": err}).Error("failed to scan device")
-			continue
+
+		// Scan interface
+		var iface_type, mac sql.NullInt64
+		var iface_name sql.NullString
+		var ipv4, ipv6 []byte
+
+		dest := []any{&id, &name, &serial, &description, &icon, &location, &http_port, &https_port, &online, &last_update,
+			&iface_type, &iface_name, &mac, &ipv4, &ipv6}
+		if err := rows.Scan(append(dest, extra...)...); err != nil {
+			return nil, err
+		}
+
+		// Add device to list on its first row
+		if len(list) == 0 || id != last_id {
+			list = append(list, Device{
+				Serial:      serial,
+				Name:        name,
+				Description: string(description),
+				Icon:        Icon.ToString(Icon(icon)),
+				Location:    string(location),
+				HttpPort:    http_port,
+				HttpsPort:   https_port,
+				Online:      online,
+				LastUpdate:  last_update,
+				Interfaces:  []DeviceInterface{},
+			})
+			last_id = id
+			if on_device != nil {
+				on_device(id)
+			}
 		}
 
-		// Add device to list
-		list = append(list, Device{
-			Serial:      serial,
-			Name:        name,
-			Description: string(description),
-			Icon:        Icon.ToString(Icon(icon)),
-			Location:    string(location),
-			HttpPort:    http_port,
-			HttpsPort:   https_port,
-			Online:      online,
-			LastUpdate:  last_update,
-			Interfaces:  listInterface(ctx, db, id),
-		})
-	}
-
-	return list
+		// Add interface to device
+		if mac.Valid {
+			dev := &list[len(list)-1]
+			dev.Interfaces = append(dev.Interfaces, DeviceInterface{
+				Type:        InterfaceType.ToString(InterfaceType(iface_type.Int64)),
+				Name:        iface_name.String,
+				MacAddress:  utils.Uint64ToHwAddress(uint64(mac.Int64)),
+				Ipv4Address: string(ipv4),
+				Ipv6Address: string(ipv6),
+			})
+		}
+	}
+
+	return list, rows.Err()
 }
 
-func Add(ctx context.Context, db *sql.DB, ip string, dev Device) bool {
-	// Check required values
-	if dev.Serial == "" {
-		log.Error("invalid device serial number")
-		return false
+// Device list sort columns
+var listSortColumns = [...]string{"id", "name", "last_update"}
+
+func (s *sqlStore) LinkedNetworks(ctx context.Context, ip string, window time.Duration) ([]string, error) {
+	networks := []string{ip}
+	if window <= 0 {
+		return networks, nil
+	}
+
+	// Find the same devices updated within the window on other networks
+	seconds := uint64(window.Seconds())
+	rows, err := s.conn().QueryContext(ctx, s.queries.linked_networks, ip, seconds, seconds)
+	if err != nil {
+		return nil, utils.DbError(ctx, s.db, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var network string
+		if err := rows.Scan(&network); err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, rows.Err()
+}
+
+// Build the device list query with its arguments
+func (s *sqlStore) listQuery(networks []string, opts ListOptions) (string, []any) {
+	d := s.dialect
+
+	// Filter devices of the networks
+	placeholders := make([]string, len(networks))
+	args := []any{}
+	for index, network := range networks {
+		placeholders[index] = d.Inet6Aton("?")
+		args = append(args, network)
+	}
+	in_networks := "IN (" + strings.Join(placeholders, ", ") + ")"
+	where := []string{"ip " + in_networks}
+
+	// Keep only the last entry of a device seen on many networks
+	if len(networks) > 1 {
+		where = append(where, "NOT EXISTS (SELECT 1 FROM device AS newer WHERE newer.serial=device.serial AND newer.ip "+in_networks+
+			" AND (newer.last_update>device.last_update OR (newer.last_update=device.last_update AND newer.id>device.id)))")
+		args = append(args, args...)
+	}
+	if opts.Online != nil {
+		where = append(where, "online=?")
+		args = append(args, *opts.Online)
+	}
+	if opts.Icon != "" {
+		where = append(where, "icon=?")
+		args = append(args, IconFromString(opts.Icon))
+	}
+	if opts.InterfaceType != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM device_iface WHERE device_iface.device_id=device.id AND device_iface.type=?)")
+		args = append(args, InterfaceTypeFromString(opts.InterfaceType))
+	}
+	if opts.Name != "" {
+		where = append(where, "LOWER(name) LIKE ? ESCAPE '!'")
+		args = append(args, "%"+utils.EscapeLike(strings.ToLower(opts.Name))+"%")
+	}
+	if opts.Since != 0 {
+		where = append(where, "last_update >= ?")
+		args = append(args, opts.Since)
+	}
+
+	// Sort devices (the identifier breaks ties)
+	column := listSortColumns[opts.Sort]
+	direction, compare := " ASC", ">"
+	if opts.Descending {
+		direction, compare = " DESC", "<"
+	}
+	order := []string{"id" + direction}
+	if opts.Sort != SortById {
+		order = append([]string{column + direction}, order...)
+	}
+
+	// Start after the cursor
+	if cursor := opts.Cursor; cursor != nil {
+		switch opts.Sort {
+		case SortById:
+			where = append(where, "id"+compare+"?")
+			args = append(args, cursor.Id)
+		case SortByName:
+			where = append(where, "("+column+compare+"? OR ("+column+"=? AND id"+compare+"?))")
+			args = append(args, cursor.Name, cursor.Name, cursor.Id)
+		case SortByLastUpdate:
+			where = append(where, "("+column+compare+"? OR ("+column+"=? AND id"+compare+"?))")
+			args = append(args, cursor.LastUpdate, cursor.LastUpdate, cursor.Id)
+		}
+	}
+
+	// Select a page of devices (and one more to detect the next page), then join the interfaces
+	query := "SELECT * FROM device WHERE " + strings.Join(where, " AND ") + " ORDER BY " + strings.Join(order, ", ")
+	if opts.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", opts.Limit+1)
+	}
+	query = "SELECT " + deviceColumns + ", " + interfaceColumns(d) + " FROM (" + query + ") AS device" +
+		" LEFT JOIN device_iface ON device_iface.device_id=device.id ORDER BY device." + strings.Join(order, ", device.") + ", device_iface.id"
+
+	return d.Rebind(query), args
+}
+
+func (s *sqlStore) List(ctx context.Context, ip string, opts ListOptions) ([]Device, *ListCursor, error) {
+	// Get networks to list
+	networks, err := s.LinkedNetworks(ctx, ip, opts.MergeWindow)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Fetch devices with their interfaces
+	query, args := s.listQuery(networks, opts)
+	devices, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, utils.DbError(ctx, s.db, err)
+	}
+	defer devices.Close()
+
+	// Keep the identifier of each device
+	ids := []uint{}
+	list, err := scanDevices(devices, func(id uint) { ids = append(ids, id) })
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Set cursor when more devices are available
+	var next *ListCursor
+	if opts.Limit > 0 && uint(len(list)) > opts.Limit {
+		list = list[:opts.Limit]
+		next = newListCursor(opts, ids[opts.Limit-1], list[opts.Limit-1])
+	}
+
+	return list, next, nil
+}
+
+func (s *sqlStore) Get(ctx context.Context, ip string, serial string) (Device, error) {
+	// Fetch device with its interfaces
+	rows, err := s.conn().QueryContext(ctx, s.queries.get_device, ip, serial)
+	if err != nil {
+		return Device{}, utils.DbError(ctx, s.db, err)
+	}
+	defer rows.Close()
+
+	list, err := scanDevices(rows, nil)
+	if err != nil {
+		return Device{}, err
+	} else if len(list) == 0 {
+		return Device{}, ErrDeviceNotFound
+	}
+	return list[0], nil
+}
+
+func (s *sqlStore) ListNetworks(ctx context.Context) ([]Network, error) {
+	// Create network list
+	list := []Network{}
+
+	// Fetch networks
+	networks, err := s.conn().QueryContext(ctx, s.queries.list_networks)
+	if err != nil {
+		return nil, utils.DbError(ctx, s.db, err)
+	}
+	defer networks.Close()
+
+	// Generate list
+	for networks.Next() {
+		var network Network
+		if err := networks.Scan(&network.Ip, &network.Devices, &network.Online); err != nil {
+			return nil, err
+		}
+		list = append(list, network)
+	}
+
+	return list, networks.Err()
+}
+
+func (s *sqlStore) Search(ctx context.Context, ip string, query string) ([]NetworkDevice, error) {
+	// Fetch devices with their interfaces
+	pattern := "%" + utils.EscapeLike(strings.ToLower(query)) + "%"
+	devices, err := s.conn().QueryContext(ctx, s.queries.search_devices, ip, ip, pattern, pattern)
+	if err != nil {
+		return nil, utils.DbError(ctx, s.db, err)
+	}
+	defer devices.Close()
+
+	// Keep the network of each device
+	var network string
+	networks := []string{}
+	list, err := scanDevices(devices, func(uint) { networks = append(networks, network) }, &network)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate list
+	result := []NetworkDevice{}
+	for index, dev := range list {
+		result = append(result, NetworkDevice{Ip: networks[index], Device: dev})
+	}
+
+	return result, nil
+}
+
+func (s *sqlStore) Add(ctx context.Context, ip string, dev Device) error {
+	// Check values (all invalid interfaces are reported)
+	if err := validateDevice(dev); err != nil {
+		return err
+	}
+
+	// Register the device and its interfaces atomically (in the transaction of the store when bound)
+	var err error
+	tx := s.tx
+	if tx == nil {
+		if tx, err = s.db.BeginTx(ctx, nil); err != nil {
+			return utils.DbError(ctx, s.db, err)
+		}
+		defer tx.Rollback()
 	}
 
 	// Add or update device
 	ts := time.Now().Unix()
-	result, err := db.ExecContext(ctx, `INSERT INTO device
-(ip, serial, name, description, icon, location, http_port, https_port, online, last_update)
-VALUES (INET_ATON(?), ?, ?, ?, ?, ?, ?, ?, ?, ?)
-ON DUPLICATE KEY UPDATE name=?, description=?, icon=?, location=?, http_port=?, https_port=?, online=?, last_update=?`,
+	_, err = tx.ExecContext(ctx, s.queries.add_device,
 		ip,
 		dev.Serial,
 		dev.Name,
@@ -179,77 +447,71 @@ ON DUPLICATE KEY UPDATE name=?, description=?, icon=?, location=?, http_port=?,
 		dev.HttpsPort,
 		dev.Online,
 		ts,
-		dev.Name,
-		dev.Description,
-		IconFromString(dev.Icon),
-		dev.Location,
-		dev.HttpPort,
-		dev.HttpsPort,
-		dev.Online,
-		ts,
 	)
 	if err != nil {
-		log.WithFields(log.Fields{"error": err, "device": dev}).Error("failed to add device")
-		return false
+		return utils.DbError(ctx, s.db, err)
 	}
-	_, err = result.RowsAffected()
 
-	// Update interfaces
+	// Replace interfaces
 	if dev.Interfaces != nil {
 		// Remove all old interfaces
-		if !RemoveAddresses(ctx, db, ip, dev.Serial, false) {
-			log.WithFields(log.Fields{"device": dev}).Error("failed to remove the old device interfaces")
-			return false
+		if _, err := tx.ExecContext(ctx, s.queries.remove_addresses, ip, dev.Serial); err != nil {
+			return utils.DbError(ctx, s.db, err)
 		}
 
 		// Add interfaces one by one
-		for _, iface := range dev.Interfaces {
-			if !AddAddress(ctx, db, ip, dev.Serial, iface, false) {
-				return false
+		for index, iface := range dev.Interfaces {
+			if _, err := s.addAddress(ctx, tx, ip, dev.Serial, iface); err != nil {
+				return fmt.Errorf("ifaces[%d]: %w", index, utils.DbError(ctx, s.db, err))
 			}
 		}
 	}
 
-	return err == nil
+	// Commit registration
+	if tx != s.tx {
+		if err := tx.Commit(); err != nil {
+			return utils.DbError(ctx, s.db, err)
+		}
+	}
+	return nil
 }
 
-func Remove(ctx context.Context, db *sql.DB, ip string, serial string) bool {
+func (s *sqlStore) Remove(ctx context.Context, ip string, serial string) error {
 	// Remove device (interfaces will be removed automatically)
-	result, err := db.ExecContext(ctx, "DELETE FROM device WHERE ip=INET_ATON(?) AND serial=?",
+	result, err := s.conn().ExecContext(ctx, s.queries.remove_device,
 		ip,
 		serial,
 	)
 	if err != nil {
-		log.WithFields(log.Fields{"error": err, "serial": serial}).Error("failed to remove device")
-		return false
+		return utils.DbError(ctx, s.db, err)
 	}
-	rows, err := result.RowsAffected()
-	return err == nil && rows == 1
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrDeviceNotFound
+	}
+	return nil
 }
 
-func UpdateStatus(ctx context.Context, db *sql.DB, ip string, serial string, online bool) bool {
+func (s *sqlStore) UpdateStatus(ctx context.Context, ip string, serial string, online bool) error {
 	// Update status
 	ts := time.Now().Unix()
-	_, err := db.Exec("UPDATE device SET online=?, last_update = ? WHERE ip = INET_ATON(?) AND serial=?", online, ts, ip, serial)
+	result, err := s.conn().ExecContext(ctx, s.queries.update_status, online, ts, ip, serial)
 	if err != nil {
-		log.WithFields(log.Fields{"error": err, "serial": serial}).Error("failed to update device status")
+		return utils.DbError(ctx, s.db, err)
 	}
-	return err == nil
-}
 
-func AddAddress(ctx context.Context, db *sql.DB, ip string, serial string, iface DeviceInterface, update bool) bool {
-	// Check required values
-	if utils.Uint64FromHwAddress(iface.MacAddress) == 0 {
-		log.Error("invalid interface MAC address")
-		return false
+	// Some databases only count changed rows
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return s.checkDevice(ctx, ip, serial)
 	}
+	return nil
+}
 
-	// Add or update address
-	result, err := db.ExecContext(ctx, `INSERT INTO device_iface
-(device_id, mac, type, name, ipv4, ipv6)
-SELECT id, ?, ?, ?, INET_ATON(?), INET6_ATON(?)
-FROM device WHERE ip=INET_ATON(?) AND serial=?
-ON DUPLICATE KEY UPDATE type=?, name=?, ipv4=INET_ATON(?), ipv6=INET6_ATON(?)`,
+func (s *sqlStore) addAddress(ctx context.Context, exec sqlExecutor, ip string, serial string, iface DeviceInterface) (sql.Result, error) {
+	return exec.ExecContext(ctx, s.queries.add_address,
 		utils.Uint64FromHwAddress(iface.MacAddress),
 		InterfaceTypeFromString(iface.Type),
 		iface.Name,
@@ -257,62 +519,159 @@ ON DUPLICATE KEY UPDATE type=?, name=?, ipv4=INET_ATON(?), ipv6=INET6_ATON(?)`,
 		iface.Ipv6Address,
 		ip,
 		serial,
-		InterfaceTypeFromString(iface.Type),
-		iface.Name,
-		iface.Ipv4Address,
-		iface.Ipv6Address,
 	)
+}
+
+func (s *sqlStore) AddAddress(ctx context.Context, ip string, serial string, iface DeviceInterface, update bool) error {
+	// Check values
+	if err := validateInterface(iface); err != nil {
+		return err
+	}
+
+	// Add or update address
+	result, err := s.addAddress(ctx, s.conn(), ip, serial, iface)
 	if err != nil {
-		log.WithFields(log.Fields{"error": err, "serial": serial}).Error("failed to add address")
-		return false
+		return utils.DbError(ctx, s.db, err)
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		if err := s.checkDevice(ctx, ip, serial); err != nil {
+			return err
+		}
 	}
-	_, err = result.RowsAffected()
 
 	// Update device
 	if update {
-		UpdateStatus(ctx, db, ip, serial, true)
+		return s.UpdateStatus(ctx, ip, serial, true)
 	}
 
-	return err == nil
+	return nil
 }
 
-func RemoveAddress(ctx context.Context, db *sql.DB, ip string, serial string, hw_address string, update bool) bool {
+func (s *sqlStore) RemoveAddress(ctx context.Context, ip string, serial string, hw_address string, update bool) error {
+	// Check values
+	if err := validateMac("mac", hw_address); err != nil {
+		return err
+	}
+
 	// Remove address
-	result, err := db.ExecContext(ctx, "DELETE FROM device_iface WHERE device_id IN (SELECT id FROM device WHERE ip=INET_ATON(?) AND serial=?) AND mac=?",
+	result, err := s.conn().ExecContext(ctx, s.queries.remove_address,
 		ip,
 		serial,
 		utils.Uint64FromHwAddress(hw_address),
 	)
 	if err != nil {
-		log.WithFields(log.Fields{"error": err, "serial": serial}).Error("failed to remove address")
-		return false
+		return utils.DbError(ctx, s.db, err)
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		if err := s.checkDevice(ctx, ip, serial); err != nil {
+			return err
+		}
+		return ErrInterfaceNotFound
 	}
-	rows, err := result.RowsAffected()
 
 	// Update device
 	if update {
-		UpdateStatus(ctx, db, ip, serial, true)
+		return s.UpdateStatus(ctx, ip, serial, true)
 	}
 
-	return err == nil && rows == 1
+	return nil
 }
 
-func RemoveAddresses(ctx context.Context, db *sql.DB, ip string, serial string, update bool) bool {
+func (s *sqlStore) RemoveAddresses(ctx context.Context, ip string, serial string, update bool) error {
 	// Remove address
-	result, err := db.ExecContext(ctx, "DELETE FROM device_iface WHERE device_id IN (SELECT id FROM device WHERE ip=INET_ATON(?) AND serial=?)",
+	_, err := s.conn().ExecContext(ctx, s.queries.remove_addresses,
 		ip,
 		serial,
 	)
 	if err != nil {
-		log.WithFields(log.Fields{"error": err, "serial": serial}).Error("failed to remove address")
-		return false
+		return utils.DbError(ctx, s.db, err)
 	}
-	_, err = result.RowsAffected()
 
 	// Update device
 	if update {
-		UpdateStatus(ctx, db, ip, serial, true)
+		return s.UpdateStatus(ctx, ip, serial, true)
 	}
 
-	return err == nil
+	return nil
+}
+
+func (s *sqlStore) GetTokenHash(ctx context.Context, ip string, serial string) (string, error) {
+	// Get token hash
+	var hash sql.NullString
+	err := s.conn().QueryRowContext(ctx, s.queries.get_token_hash, ip, serial).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", utils.DbError(ctx, s.db, err)
+	}
+	return hash.String, nil
+}
+
+func (s *sqlStore) SetTokenHash(ctx context.Context, ip string, serial string, hash string) (bool, error) {
+	// Update token hash
+	result, err := s.conn().ExecContext(ctx, s.queries.set_token_hash, hash, ip, serial)
+	if err != nil {
+		return false, utils.DbError(ctx, s.db, err)
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+// Apply a reaper query on each device with the online status not updated since the timestamp: the
+// query checks the status again, so a device updated meanwhile is skipped.
+func (s *sqlStore) reapDevices(ctx context.Context, online bool, before uint64, apply func(id uint) (sql.Result, error)) ([]NetworkSerial, error) {
+	// Find devices
+	rows, err := s.conn().QueryContext(ctx, s.queries.list_reapable, online, before)
+	if err != nil {
+		return nil, utils.DbError(ctx, s.db, err)
+	}
+	ids := []uint{}
+	candidates := []NetworkSerial{}
+	for rows.Next() {
+		var id uint
+		var dev NetworkSerial
+		if err := rows.Scan(&id, &dev.Ip, &dev.Serial); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		candidates = append(candidates, dev)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, utils.DbError(ctx, s.db, err)
+	}
+
+	// Apply query one by one
+	list := []NetworkSerial{}
+	for index, id := range ids {
+		result, err := apply(id)
+		if err != nil {
+			return list, utils.DbError(ctx, s.db, err)
+		}
+		if rows, err := result.RowsAffected(); err != nil {
+			return list, err
+		} else if rows == 1 {
+			list = append(list, candidates[index])
+		}
+	}
+	return list, nil
+}
+
+func (s *sqlStore) ExpireOnline(ctx context.Context, before uint64) ([]NetworkSerial, error) {
+	// Set devices offline (the last update timestamp is kept)
+	return s.reapDevices(ctx, true, before, func(id uint) (sql.Result, error) {
+		return s.conn().ExecContext(ctx, s.queries.expire_online, false, id, true, before)
+	})
+}
+
+func (s *sqlStore) PurgeOffline(ctx context.Context, before uint64) ([]NetworkSerial, error) {
+	// Remove devices (interfaces will be removed automatically)
+	return s.reapDevices(ctx, false, before, func(id uint) (sql.Result, error) {
+		return s.conn().ExecContext(ctx, s.queries.purge_offline, id, false, before)
+	})
 }
@@ -2,19 +2,42 @@ package device
 
 import (
 	"context"
-	"database/sql"
 	"net/http"
+	"time"
 
 	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/conditional"
+	"github.com/danielgtaylor/huma/v2/sse"
 
+	"github.com/dillya/melo-webapi/internal/utils"
 	"github.com/dillya/melo-webapi/internal/utils/middleware"
 )
 
 // Device List
+type deviceListInput struct {
+	conditional.Params
+	Online    string `query:"online" enum:"true,false" doc:"Only the online or offline devices"`
+	Icon      string `query:"icon" enum:"unknown,living,kitchen,bed" doc:"Only the devices with this icon"`
+	IfaceType string `query:"iface_type" enum:"unknown,ethernet,wifi" doc:"Only the devices with at least one network interface of this type"`
+	Name      string `query:"name" example:"living" doc:"Only the devices with a name containing this text (case insensitive)"`
+	Since     uint64 `query:"since" example:"1700000000" doc:"Only the devices updated since this timestamp as Unix epoch"`
+	Sort      string `query:"sort" enum:"name,-name,last_update,-last_update" doc:"The sort key, prefixed by '-' for descending order (registration order by default)"`
+	Limit     uint   `query:"limit" example:"50" minimum:"0" maximum:"1000" doc:"The maximum number of devices to return (all when 0)"`
+	Cursor    string `query:"cursor" doc:"The cursor of the next page returned in the Link header"`
+}
+
 type deviceListOutput struct {
+	ETag string `header:"ETag" doc:"The entity tag of the device list"`
+	Link string `header:"Link" doc:"The link to the next page when more devices are available"`
 	Body []Device
 }
 
+// Single device
+type deviceOutput struct {
+	ETag string `header:"ETag" doc:"The entity tag of the device"`
+	Body Device
+}
+
 // Operation result
 type resultOutput struct {
 	Body result
@@ -26,6 +49,18 @@ type result struct {
 	Error string `json:"error,omitempty" example:"Failed to add device" doc:"The error message if code != 0"`
 }
 
+// Add device result
+type addResultOutput struct {
+	Body addResult
+}
+
+// Add device result
+type addResult struct {
+	Code  uint   `json:"code" example:"2" doc:"The result code: 0=success"`
+	Error string `json:"error,omitempty" example:"Failed to add device" doc:"The error message if code != 0"`
+	Token string `json:"token,omitempty" example:"Bp0HHeE3nQJ6tYhGfpGvPRBOcqZ8E2yiQvzXRTfiTtE" doc:"The secret token of the device returned on first registration (unless the device sent its token): it must be sent as bearer token to modify the device"`
+}
+
 // Interface
 type DeviceInterface struct {
 	Type        string `json:"type,omitempty" example:"ethernet" enum:"ethernet,wifi" doc:"The network interface type"`
@@ -49,52 +84,227 @@ type Device struct {
 	Interfaces  []DeviceInterface `json:"ifaces" doc:"List of network interfaces of the device" required:"false"`
 }
 
-func Register(api huma.API, db *sql.DB) {
+// changeDevice applies changes on a device once its token and the conditional headers are checked,
+// within a single store transaction: the device cannot be claimed or modified between the checks and
+// the changes.
+func changeDevice(ctx context.Context, store DeviceStore, ip string, serial string, authorization string, params *conditional.Params, fn func(tx DeviceStore) error) error {
+	return store.Change(ctx, ip, serial, func(tx DeviceStore) error {
+		if err := CheckToken(ctx, tx, ip, serial, bearerToken(authorization)); err != nil {
+			return err
+		}
+		if err := checkPreconditions(ctx, tx, ip, serial, params); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+func Register(api huma.API, store DeviceStore, broker *Broker, client_ip middleware.IpConfig, merge_window time.Duration) {
+	// Register device token authentication
+	registerTokenSecurityScheme(api)
+
 	// IP client extractor middleware
-	client_ip_extract := middleware.GetIpExtractor()
+	client_ip_extract := middleware.GetIpExtractor(client_ip)
 
 	// Register GET /device/list handler
+	list_url := operationUrl(api, "/device/list")
 	huma.Register(api, huma.Operation{
 		OperationID: "listDevice",
 		Method:      http.MethodGet,
 		Path:        "/device/list",
 		Summary:     "List devices",
-		Description: "List all devices registered on the local network.",
+		Description: "List the devices registered on the local network, with optional filters, sorting and cursor pagination. The IPv4 and IPv6 networks of a dual-stack home are merged when a same device is seen on both.",
 		Tags:        []string{"Device"},
 		Middlewares: huma.Middlewares{client_ip_extract},
-	}, func(ctx context.Context, input *struct{}) (*deviceListOutput, error) {
-		ip := middleware.ExtractIp(ctx)
+	}, func(ctx context.Context, input *deviceListInput) (*deviceListOutput, error) {
+		ip := middleware.ExtractNetwork(ctx)
+
+		// Parse options
+		opts, err := input.options()
+		if err != nil {
+			return nil, err
+		}
+		opts.MergeWindow = merge_window
 
 		// List devices
+		devices, next, err := store.List(ctx, ip, opts)
+		if err != nil {
+			return nil, utils.HttpError(err, "")
+		}
+
+		// Skip unchanged list
+		etag := listETag(devices, next)
+		if input.HasConditionalParams() {
+			// The list has no modification time (a removal cannot be dated)
+			if err := input.PreconditionFailed(etag, time.Now()); err != nil {
+				return nil, err
+			}
+		}
+
 		resp := &deviceListOutput{}
-		resp.Body = List(ctx, db, ip)
+		resp.ETag = quoteETag(etag)
+		resp.Body = devices
+		if next != nil {
+			resp.Link = input.nextLink(list_url, next)
+		}
 		return resp, nil
 	})
 
+	// Register GET /device/events handler
+	sse.Register(api, huma.Operation{
+		OperationID: "streamDeviceEvents",
+		Method:      http.MethodGet,
+		Path:        "/device/events",
+		Summary:     "Stream device events",
+		Description: "Stream the changes of the devices registered on the local network (and on the merged networks) as Server-Sent Events.",
+		Tags:        []string{"Device"},
+		Middlewares: huma.Middlewares{client_ip_extract},
+	}, map[string]any{
+		"message": DeviceEvent{},
+	}, func(ctx context.Context, input *struct{}, send sse.Sender) {
+		ip := middleware.ExtractNetwork(ctx)
+
+		// Subscribe to network events
+		events := broker.Subscribe(ip)
+		defer broker.Unsubscribe(ip, events)
+
+		// Forward events until client disconnection
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-events:
+				if !ok {
+					// Server is shutting down
+					return
+				}
+				if err := send.Data(event); err != nil {
+					return
+				}
+			}
+		}
+	})
+
 	// Register PUT /device/add handler
 	huma.Register(api, huma.Operation{
 		OperationID: "addDevice",
 		Method:      http.MethodPut,
 		Path:        "/device/add",
 		Summary:     "Add / reset a device",
-		Description: "Add a new device / reset a device on the local network.",
+		Description: "Add a new device / reset a device on the local network. The device and its interfaces are registered atomically, and all invalid interfaces are reported on failure.",
 		Tags:        []string{"Device"},
+		Security:    tokenSecurity,
 		Middlewares: huma.Middlewares{client_ip_extract},
 	}, func(ctx context.Context, input *struct {
-		Body Device
-	}) (*resultOutput, error) {
-		ip := middleware.ExtractIp(ctx)
+		conditional.Params
+		Authorization string `header:"Authorization" hidden:"true"`
+		Body          Device
+	}) (*addResultOutput, error) {
+		ip := middleware.ExtractNetwork(ctx)
 
-		// Add device
-		resp := &resultOutput{}
-		if !Add(ctx, db, ip, input.Body) {
-			resp.Body.Code = 1
-			resp.Body.Error = "Failed to add device"
+		// Add device when the token and the conditional headers match the current device
+		// (the token of a first registration is issued in the same transaction)
+		resp := &addResultOutput{}
+		err := changeDevice(ctx, store, ip, input.Body.Serial, input.Authorization, &input.Params, func(tx DeviceStore) error {
+			if err := tx.Add(ctx, ip, input.Body); err != nil {
+				return err
+			}
+			token, err := issueToken(ctx, tx, ip, input.Body.Serial, bearerToken(input.Authorization))
+			resp.Body.Token = token
+			return err
+		})
+		if err != nil {
+			return nil, utils.HttpError(err, "body.")
 		}
 
 		return resp, nil
 	})
 
+	// Register GET /device/{serial} handler
+	huma.Register(api, huma.Operation{
+		OperationID: "getDevice",
+		Method:      http.MethodGet,
+		Path:        "/device/{serial}",
+		Summary:     "Get a device",
+		Description: "Get a device registered on the local network.",
+		Tags:        []string{"Device"},
+		Middlewares: huma.Middlewares{client_ip_extract},
+	}, func(ctx context.Context, input *struct {
+		conditional.Params
+		Serial string `path:"serial" example:"01:23:45:67:89:ab" doc:"Serial Number of the device"`
+	}) (*deviceOutput, error) {
+		ip := middleware.ExtractNetwork(ctx)
+
+		// Get device
+		dev, err := store.Get(ctx, ip, input.Serial)
+		if err != nil {
+			return nil, utils.HttpError(err, "path.")
+		}
+
+		// Skip unchanged device
+		etag, modified := deviceETag(dev)
+		if input.HasConditionalParams() {
+			if err := input.PreconditionFailed(etag, modified); err != nil {
+				return nil, err
+			}
+		}
+		return &deviceOutput{ETag: quoteETag(etag), Body: dev}, nil
+	})
+
+	// Register PATCH /device/{serial} handler (the patch is validated once applied on the device)
+	patch_schema := &huma.Schema{
+		Type:        huma.TypeObject,
+		Description: "The fields of the device to update, or null to reset them",
+	}
+	huma.Register(api, huma.Operation{
+		OperationID: "patchDevice",
+		Method:      http.MethodPatch,
+		Path:        "/device/{serial}",
+		Summary:     "Update a device",
+		Description: "Update some fields of the device with a JSON merge patch (RFC 7396): the missing fields are left untouched, and the interfaces are replaced only when set.",
+		Tags:        []string{"Device"},
+		Security:    tokenSecurity,
+		Middlewares: huma.Middlewares{client_ip_extract},
+		RequestBody: &huma.RequestBody{
+			Required: true,
+			Content: map[string]*huma.MediaType{
+				"application/merge-patch+json": {Schema: patch_schema},
+				"application/json":             {Schema: patch_schema},
+			},
+		},
+	}, func(ctx context.Context, input *struct {
+		conditional.Params
+		Authorization string `header:"Authorization" hidden:"true"`
+		Serial        string `path:"serial" example:"01:23:45:67:89:ab" doc:"Serial Number of the device to modify"`
+		Body          map[string]any
+	}) (*deviceOutput, error) {
+		ip := middleware.ExtractNetwork(ctx)
+
+		// Apply patch on current device when the token and the conditional headers match, and get the updated device
+		var dev Device
+		err := changeDevice(ctx, store, ip, input.Serial, input.Authorization, &input.Params, func(tx DeviceStore) error {
+			current, err := tx.Get(ctx, ip, input.Serial)
+			if err != nil {
+				return utils.HttpError(err, "path.")
+			}
+			if current, err = patchDevice(current, input.Body); err != nil {
+				return utils.HttpError(err, "body.")
+			}
+			if err := tx.Add(ctx, ip, current); err != nil {
+				return utils.HttpError(err, "body.")
+			}
+			if dev, err = tx.Get(ctx, ip, input.Serial); err != nil {
+				return utils.HttpError(err, "path.")
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, utils.HttpError(err, "")
+		}
+		etag, _ := deviceETag(dev)
+		return &deviceOutput{ETag: quoteETag(etag), Body: dev}, nil
+	})
+
 	// Register DELETE /device/{serial} handler
 	huma.Register(api, huma.Operation{
 		OperationID: "removeDevice",
@@ -103,20 +313,24 @@ func Register(api huma.API, db *sql.DB) {
 		Summary:     "Remove the device",
 		Description: "Remove the device from the local network.",
 		Tags:        []string{"Device"},
+		Security:    tokenSecurity,
 		Middlewares: huma.Middlewares{client_ip_extract},
 	}, func(ctx context.Context, input *struct {
-		Serial string `path:"serial" example:"01:23:45:67:89:ab" doc:"Serial Number of the device to remove"`
+		conditional.Params
+		Authorization string `header:"Authorization" hidden:"true"`
+		Serial        string `path:"serial" example:"01:23:45:67:89:ab" doc:"Serial Number of the device to remove"`
 	}) (*resultOutput, error) {
-		ip := middleware.ExtractIp(ctx)
+		ip := middleware.ExtractNetwork(ctx)
 
-		// Remove device
-		resp := &resultOutput{}
-		if !Remove(ctx, db, ip, input.Serial) {
-			resp.Body.Code = 1
-			resp.Body.Error = "Failed to remove device"
+		// Remove device when the token and the conditional headers match
+		err := changeDevice(ctx, store, ip, input.Serial, input.Authorization, &input.Params, func(tx DeviceStore) error {
+			return tx.Remove(ctx, ip, input.Serial)
+		})
+		if err != nil {
+			return nil, utils.HttpError(err, "path.")
 		}
 
-		return resp, nil
+		return &resultOutput{}, nil
 	})
 
 	// Register PUT /device/{serial}/online handler
@@ -127,20 +341,24 @@ func Register(api huma.API, db *sql.DB) {
 		Summary:     "Set the device as online",
 		Description: "Set the device as online and update timestamp.",
 		Tags:        []string{"Device"},
+		Security:    tokenSecurity,
 		Middlewares: huma.Middlewares{client_ip_extract},
 	}, func(ctx context.Context, input *struct {
-		Serial string `path:"serial" example:"01:23:45:67:89:ab" doc:"Serial Number of the device to modify"`
+		conditional.Params
+		Authorization string `header:"Authorization" hidden:"true"`
+		Serial        string `path:"serial" example:"01:23:45:67:89:ab" doc:"Serial Number of the device to modify"`
 	}) (*resultOutput, error) {
-		ip := middleware.ExtractIp(ctx)
+		ip := middleware.ExtractNetwork(ctx)
 
-		// Set device online
-		resp := &resultOutput{}
-		if !UpdateStatus(ctx, db, ip, input.Serial, true) {
-			resp.Body.Code = 1
-			resp.Body.Error = "Failed to set device online"
+		// Set device online when the token and the conditional headers match
+		err := changeDevice(ctx, store, ip, input.Serial, input.Authorization, &input.Params, func(tx DeviceStore) error {
+			return tx.UpdateStatus(ctx, ip, input.Serial, true)
+		})
+		if err != nil {
+			return nil, utils.HttpError(err, "path.")
 		}
 
-		return resp, nil
+		return &resultOutput{}, nil
 	})
 
 	// Register PUT /device/{serial}/offline handler
@@ -151,20 +369,24 @@ func Register(api huma.API, db *sql.DB) {
 		Summary:     "Set the device as offline",
 		Description: "Set the device as offline and update timestamp.",
 		Tags:        []string{"Device"},
+		Security:    tokenSecurity,
 		Middlewares: huma.Middlewares{client_ip_extract},
 	}, func(ctx context.Context, input *struct {
-		Serial string `path:"serial" example:"01:23:45:67:89:ab" doc:"Serial Number of the device to modify"`
+		conditional.Params
+		Authorization string `header:"Authorization" hidden:"true"`
+		Serial        string `path:"serial" example:"01:23:45:67:89:ab" doc:"Serial Number of the device to modify"`
 	}) (*resultOutput, error) {
-		ip := middleware.ExtractIp(ctx)
+		ip := middleware.ExtractNetwork(ctx)
 
-		// Set device offline
-		resp := &resultOutput{}
-		if !UpdateStatus(ctx, db, ip, input.Serial, false) {
-			resp.Body.Code = 1
-			resp.Body.Error = "Failed to set device offline"
+		// Set device offline when the token and the conditional headers match
+		err := changeDevice(ctx, store, ip, input.Serial, input.Authorization, &input.Params, func(tx DeviceStore) error {
+			return tx.UpdateStatus(ctx, ip, input.Serial, false)
+		})
+		if err != nil {
+			return nil, utils.HttpError(err, "path.")
 		}
 
-		return resp, nil
+		return &resultOutput{}, nil
 	})
 
 	// Register PUT /device/{serial}/add handler
@@ -175,21 +397,25 @@ func Register(api huma.API, db *sql.DB) {
 		Summary:     "Add / update a network interface",
 		Description: "Add / update a network interface of the device.",
 		Tags:        []string{"Device"},
+		Security:    tokenSecurity,
 		Middlewares: huma.Middlewares{client_ip_extract},
 	}, func(ctx context.Context, input *struct {
-		Serial string `path:"serial" example:"01:23:45:67:89:ab" doc:"Serial Number of the device to modify"`
-		Body   DeviceInterface
+		conditional.Params
+		Authorization string `header:"Authorization" hidden:"true"`
+		Serial        string `path:"serial" example:"01:23:45:67:89:ab" doc:"Serial Number of the device to modify"`
+		Body          DeviceInterface
 	}) (*resultOutput, error) {
-		ip := middleware.ExtractIp(ctx)
+		ip := middleware.ExtractNetwork(ctx)
 
-		// Add interface
-		resp := &resultOutput{}
-		if !AddAddress(ctx, db, ip, input.Serial, input.Body, true) {
-			resp.Body.Code = 1
-			resp.Body.Error = "Failed to add interface"
+		// Add interface when the token and the conditional headers match
+		err := changeDevice(ctx, store, ip, input.Serial, input.Authorization, &input.Params, func(tx DeviceStore) error {
+			return tx.AddAddress(ctx, ip, input.Serial, input.Body, true)
+		})
+		if err != nil {
+			return nil, utils.HttpError(err, "body.")
 		}
 
-		return resp, nil
+		return &resultOutput{}, nil
 	})
 
 	// Register DELETE /device/{serial}/{mac} handler
@@ -200,21 +426,25 @@ func Register(api huma.API, db *sql.DB) {
 		Summary:     "Remove the network interface",
 		Description: "Remove the network interface from the device.",
 		Tags:        []string{"Device"},
+		Security:    tokenSecurity,
 		Middlewares: huma.Middlewares{client_ip_extract},
 	}, func(ctx context.Context, input *struct {
-		Serial string `path:"serial" example:"01:23:45:67:89:ab" doc:"Serial Number of the device to modify"`
-		Mac    string `path:"mac" example:"01:23:45:67:89:ab" doc:"The MAC address of the network interface"`
+		conditional.Params
+		Authorization string `header:"Authorization" hidden:"true"`
+		Serial        string `path:"serial" example:"01:23:45:67:89:ab" doc:"Serial Number of the device to modify"`
+		Mac           string `path:"mac" example:"01:23:45:67:89:ab" doc:"The MAC address of the network interface"`
 	}) (*resultOutput, error) {
-		ip := middleware.ExtractIp(ctx)
+		ip := middleware.ExtractNetwork(ctx)
 
-		// Remove interface
-		resp := &resultOutput{}
-		if !RemoveAddress(ctx, db, ip, input.Serial, input.Mac, true) {
-			resp.Body.Code = 1
-			resp.Body.Error = "Failed to remove interface"
+		// Remove interface when the token and the conditional headers match
+		err := changeDevice(ctx, store, ip, input.Serial, input.Authorization, &input.Params, func(tx DeviceStore) error {
+			return tx.RemoveAddress(ctx, ip, input.Serial, input.Mac, true)
+		})
+		if err != nil {
+			return nil, utils.HttpError(err, "path.")
 		}
 
-		return resp, nil
+		return &resultOutput{}, nil
 	})
 
 }
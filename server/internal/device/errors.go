@@ -0,0 +1,63 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// Errors returned by the device stores (see also utils.ErrUnavailable)
+var (
+	ErrDeviceNotFound    = fmt.Errorf("device %w", utils.ErrNotFound)
+	ErrInterfaceNotFound = fmt.Errorf("interface %w", utils.ErrNotFound)
+)
+
+// validateDevice checks the device and reports all invalid interfaces
+func validateDevice(dev Device) error {
+	errs := utils.ValidationErrors{}
+
+	// Check required values
+	if dev.Serial == "" {
+		errs = append(errs, &utils.ValidationError{Location: "serial", Value: dev.Serial, Message: "serial number is required"})
+	}
+
+	// Check interfaces
+	for index, iface := range dev.Interfaces {
+		var verr *utils.ValidationError
+		if err := validateInterface(iface); errors.As(err, &verr) {
+			verr.Location = fmt.Sprintf("ifaces[%d].%s", index, verr.Location)
+			errs = append(errs, verr)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateInterface(iface DeviceInterface) error {
+	// Check MAC address
+	if err := validateMac("mac", iface.MacAddress); err != nil {
+		return err
+	}
+
+	// Check IP addresses
+	if iface.Ipv4Address != "" && net.ParseIP(iface.Ipv4Address).To4() == nil {
+		return &utils.ValidationError{Location: "ipv4", Value: iface.Ipv4Address, Message: "invalid IPv4 address"}
+	}
+	if iface.Ipv6Address != "" && net.ParseIP(iface.Ipv6Address) == nil {
+		return &utils.ValidationError{Location: "ipv6", Value: iface.Ipv6Address, Message: "invalid IPv6 address"}
+	}
+
+	return nil
+}
+
+func validateMac(location string, mac string) error {
+	if utils.Uint64FromHwAddress(mac) == 0 {
+		return &utils.ValidationError{Location: location, Value: mac, Message: "invalid MAC address"}
+	}
+	return nil
+}
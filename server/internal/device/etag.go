@@ -0,0 +1,59 @@
+package device
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2/conditional"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// Compute the entity tag of a JSON value (without quotes)
+func computeETag(value any) string {
+	data, _ := json.Marshal(value)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:16])
+}
+
+func quoteETag(etag string) string {
+	return "\"" + etag + "\""
+}
+
+// Get the entity tag of a device list
+func listETag(devices []Device, next *ListCursor) string {
+	// The list content is used since the online status can change without update (see Reaper)
+	return computeETag([]any{devices, next})
+}
+
+// Get the entity tag and modification time of a device
+func deviceETag(dev Device) (string, time.Time) {
+	return computeETag(dev), time.Unix(int64(dev.LastUpdate), 0)
+}
+
+// checkPreconditions checks the conditional headers (If-Match, ...) against the current state of the
+// device before a change: an unknown device has no entity tag. It must be called with the store
+// given by DeviceStore.Change, so the device cannot be modified between the check and the change.
+func checkPreconditions(ctx context.Context, store DeviceStore, ip string, serial string, params *conditional.Params) error {
+	if !params.HasConditionalParams() {
+		return nil
+	}
+
+	// Get current device state
+	etag, modified := "", time.Time{}
+	dev, err := store.Get(ctx, ip, serial)
+	if err == nil {
+		etag, modified = deviceETag(dev)
+	} else if !errors.Is(err, ErrDeviceNotFound) {
+		return utils.HttpError(err, "")
+	}
+
+	if err := params.PreconditionFailed(etag, modified); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/conditional"
+	"github.com/danielgtaylor/huma/v2/humatest"
+)
+
+func TestCheckPreconditions(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.open(t)
+			ctx := context.Background()
+			if err := s.Add(ctx, "1.2.3.4", Device{Serial: "serial", Name: "Device"}); err != nil {
+				t.Fatalf("failed to add device: %s", err)
+			}
+			dev, err := s.Get(ctx, "1.2.3.4", "serial")
+			if err != nil {
+				t.Fatalf("failed to get device: %s", err)
+			}
+			etag, modified := deviceETag(dev)
+
+			for _, test := range []struct {
+				name   string
+				method string
+				serial string
+				params conditional.Params
+				status int
+			}{
+				{"no condition", http.MethodPut, "serial", conditional.Params{}, 0},
+				{"if-match", http.MethodPut, "serial", conditional.Params{IfMatch: []string{quoteETag(etag)}}, 0},
+				{"if-match changed", http.MethodPut, "serial", conditional.Params{IfMatch: []string{`"changed"`}}, http.StatusPreconditionFailed},
+				{"if-match unknown", http.MethodPut, "unknown", conditional.Params{IfMatch: []string{quoteETag(etag)}}, http.StatusPreconditionFailed},
+				{"if-none-match any", http.MethodPut, "serial", conditional.Params{IfNoneMatch: []string{"*"}}, http.StatusPreconditionFailed},
+				{"if-none-match any unknown", http.MethodPut, "unknown", conditional.Params{IfNoneMatch: []string{"*"}}, 0},
+				{"if-unmodified-since", http.MethodPut, "serial", conditional.Params{IfUnmodifiedSince: modified}, 0},
+				{"if-unmodified-since before", http.MethodPut, "serial", conditional.Params{IfUnmodifiedSince: modified.Add(-time.Second)}, http.StatusPreconditionFailed},
+				{"if-none-match read", http.MethodGet, "serial", conditional.Params{IfNoneMatch: []string{quoteETag(etag)}}, http.StatusNotModified},
+				{"if-none-match read changed", http.MethodGet, "serial", conditional.Params{IfNoneMatch: []string{`"changed"`}}, 0},
+			} {
+				t.Run(test.name, func(t *testing.T) {
+					// Resolve the parameters for the request method
+					request := httptest.NewRequest(test.method, "/device/serial", nil)
+					test.params.Resolve(humatest.NewContext(nil, request, httptest.NewRecorder()))
+
+					err := checkPreconditions(ctx, s, "1.2.3.4", test.serial, &test.params)
+					var status huma.StatusError
+					if test.status == 0 && err != nil {
+						t.Fatalf("got %v, want no error", err)
+					} else if test.status != 0 && (!errors.As(err, &status) || status.GetStatus() != test.status) {
+						t.Fatalf("got %v, want status %d", err, test.status)
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package device
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type EventType uint
+
+const (
+	AddEvent EventType = iota
+	RemoveEvent
+	OnlineEvent
+	OfflineEvent
+	InterfaceAddEvent
+	InterfaceRemoveEvent
+)
+
+var eventTypeMap = [...]string{"add", "remove", "online", "offline", "iface_add", "iface_remove"}
+
+func (e EventType) ToString() string {
+	if int(e) < len(eventTypeMap) {
+		return eventTypeMap[e]
+	}
+	return eventTypeMap[0]
+}
+
+// Device event
+type DeviceEvent struct {
+	Type      string           `json:"type" example:"online" enum:"add,remove,online,offline,iface_add,iface_remove" doc:"The event type"`
+	Serial    string           `json:"serial" example:"01:23:45:67:89:ab" doc:"Serial Number of the device"`
+	Device    *Device          `json:"device,omitempty" doc:"The device when type is 'add'"`
+	Interface *DeviceInterface `json:"iface,omitempty" doc:"The network interface when type is 'iface_add' or 'iface_remove' (only MAC address is set on removal)"`
+	Timestamp uint64           `json:"timestamp" example:"0" doc:"The event timestamp as Unix epoch"`
+}
+
+// Broker dispatches the device events to the subscribers of a network
+type Broker struct {
+	mutex       sync.Mutex
+	closed      bool
+	subscribers map[string]map[chan DeviceEvent]struct{}
+}
+
+// Number of pending events per subscriber before dropping events
+const subscriberQueueSize = 32
+
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[string]map[chan DeviceEvent]struct{}),
+	}
+}
+
+// Subscribe returns a channel receiving all events of the network (closed when the broker is closed)
+func (b *Broker) Subscribe(ip string) chan DeviceEvent {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ch := make(chan DeviceEvent, subscriberQueueSize)
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	if b.subscribers[ip] == nil {
+		b.subscribers[ip] = make(map[chan DeviceEvent]struct{})
+	}
+	b.subscribers[ip][ch] = struct{}{}
+	return ch
+}
+
+// Unsubscribe stops the delivery of events to the channel
+func (b *Broker) Unsubscribe(ip string, ch chan DeviceEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.subscribers[ip], ch)
+	if len(b.subscribers[ip]) == 0 {
+		delete(b.subscribers, ip)
+	}
+}
+
+// Close closes the channels of all subscribers (as on server shutdown)
+func (b *Broker) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.closed = true
+	for ip, subscribers := range b.subscribers {
+		for ch := range subscribers {
+			close(ch)
+		}
+		delete(b.subscribers, ip)
+	}
+}
+
+// Publish sends an event to all subscribers of the network (a slow subscriber misses events)
+func (b *Broker) Publish(ip string, event DeviceEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	event.Timestamp = uint64(time.Now().Unix())
+	for ch := range b.subscribers[ip] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Device store publishing an event on every successful change.
+//
+// An event is published on the network of the device and on the networks merged with it, so the
+// subscribers receive the events of all the devices they can list.
+type eventStore struct {
+	DeviceStore
+	broker       *Broker
+	merge_window time.Duration
+	pending      *[]pendingEvent
+}
+
+// Event published once the changes are committed (see Change)
+type pendingEvent struct {
+	networks []string
+	event    DeviceEvent
+}
+
+// NewEventStore wraps a device store to publish its changes on the broker
+func NewEventStore(store DeviceStore, broker *Broker, merge_window time.Duration) DeviceStore {
+	return &eventStore{DeviceStore: store, broker: broker, merge_window: merge_window}
+}
+
+// Get the networks on which the events of a network are published
+func (s *eventStore) networks(ctx context.Context, ip string) []string {
+	networks, err := s.DeviceStore.LinkedNetworks(ctx, ip, s.merge_window)
+	if err != nil {
+		log.WithFields(log.Fields{"ip": ip, "error": err}).Warn("failed to get linked networks")
+		return []string{ip}
+	}
+	return networks
+}
+
+func (s *eventStore) publish(networks []string, event DeviceEvent) {
+	if s.pending != nil {
+		*s.pending = append(*s.pending, pendingEvent{networks: networks, event: event})
+		return
+	}
+	for _, network := range networks {
+		s.broker.Publish(network, event)
+	}
+}
+
+func (s *eventStore) Change(ctx context.Context, ip string, serial string, fn func(tx DeviceStore) error) error {
+	// Keep the events until the changes are committed
+	pending := s.pending
+	if pending == nil {
+		pending = &[]pendingEvent{}
+	}
+	err := s.DeviceStore.Change(ctx, ip, serial, func(tx DeviceStore) error {
+		return fn(&eventStore{DeviceStore: tx, broker: s.broker, merge_window: s.merge_window, pending: pending})
+	})
+	if err != nil || s.pending != nil {
+		return err
+	}
+
+	// Publish events
+	for _, pending := range *pending {
+		for _, network := range pending.networks {
+			s.broker.Publish(network, pending.event)
+		}
+	}
+	return nil
+}
+
+func (s *eventStore) Add(ctx context.Context, ip string, dev Device) error {
+	if err := s.DeviceStore.Add(ctx, ip, dev); err != nil {
+		return err
+	}
+
+	// Publish the stored device (with the kept interfaces and the normalized values)
+	stored, err := s.DeviceStore.Get(ctx, ip, dev.Serial)
+	if err != nil {
+		log.WithFields(log.Fields{"serial": dev.Serial, "error": err}).Warn("failed to get added device")
+		return nil
+	}
+	s.publish(s.networks(ctx, ip), DeviceEvent{Type: AddEvent.ToString(), Serial: stored.Serial, Device: &stored})
+	return nil
+}
+
+func (s *eventStore) Remove(ctx context.Context, ip string, serial string) error {
+	// Get networks before the device link is removed
+	networks := s.networks(ctx, ip)
+	if err := s.DeviceStore.Remove(ctx, ip, serial); err != nil {
+		return err
+	}
+	s.publish(networks, DeviceEvent{Type: RemoveEvent.ToString(), Serial: serial})
+	return nil
+}
+
+func (s *eventStore) UpdateStatus(ctx context.Context, ip string, serial string, online bool) error {
+	if err := s.DeviceStore.UpdateStatus(ctx, ip, serial, online); err != nil {
+		return err
+	}
+	event := OfflineEvent
+	if online {
+		event = OnlineEvent
+	}
+	s.publish(s.networks(ctx, ip), DeviceEvent{Type: event.ToString(), Serial: serial})
+	return nil
+}
+
+func (s *eventStore) AddAddress(ctx context.Context, ip string, serial string, iface DeviceInterface, update bool) error {
+	if err := s.DeviceStore.AddAddress(ctx, ip, serial, iface, update); err != nil {
+		return err
+	}
+	iface = normalizeInterface(iface)
+	s.publish(s.networks(ctx, ip), DeviceEvent{Type: InterfaceAddEvent.ToString(), Serial: serial, Interface: &iface})
+	return nil
+}
+
+func (s *eventStore) RemoveAddress(ctx context.Context, ip string, serial string, hw_address string, update bool) error {
+	if err := s.DeviceStore.RemoveAddress(ctx, ip, serial, hw_address, update); err != nil {
+		return err
+	}
+	mac := utils.Uint64ToHwAddress(utils.Uint64FromHwAddress(hw_address))
+	s.publish(s.networks(ctx, ip), DeviceEvent{Type: InterfaceRemoveEvent.ToString(), Serial: serial, Interface: &DeviceInterface{MacAddress: mac}})
+	return nil
+}
+
+func (s *eventStore) ExpireOnline(ctx context.Context, before uint64) ([]NetworkSerial, error) {
+	devices, err := s.DeviceStore.ExpireOnline(ctx, before)
+	for _, dev := range devices {
+		s.publish(s.networks(ctx, dev.Ip), DeviceEvent{Type: OfflineEvent.ToString(), Serial: dev.Serial})
+	}
+	return devices, err
+}
+
+func (s *eventStore) PurgeOffline(ctx context.Context, before uint64) ([]NetworkSerial, error) {
+	// The networks still linked once the devices are removed are notified
+	devices, err := s.DeviceStore.PurgeOffline(ctx, before)
+	for _, dev := range devices {
+		s.publish(s.networks(ctx, dev.Ip), DeviceEvent{Type: RemoveEvent.ToString(), Serial: dev.Serial})
+	}
+	return devices, err
+}
@@ -0,0 +1,200 @@
+package device
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+type ListSort uint
+
+const (
+	SortById ListSort = iota
+	SortByName
+	SortByLastUpdate
+)
+
+var listSortMap = [...]string{"id", "name", "last_update"}
+
+func (s ListSort) ToString() string {
+	if int(s) < len(listSortMap) {
+		return listSortMap[s]
+	}
+	return listSortMap[0]
+}
+
+func ListSortFromString(str string) uint {
+	for index := range listSortMap {
+		if listSortMap[index] == str {
+			return uint(index)
+		}
+	}
+	return 0
+}
+
+// ListOptions filters, sorts and paginates the device list (the zero value lists all devices in
+// insertion order)
+type ListOptions struct {
+	// Only online or offline devices when set
+	Online *bool
+	// Only devices with this icon when set
+	Icon string
+	// Only devices with at least one interface of this type when set
+	InterfaceType string
+	// Only devices with a name containing this text (case insensitive) when set
+	Name string
+	// Only devices updated since this timestamp when set
+	Since uint64
+
+	// Merge the devices of the networks linked by a common device (same serial number and same
+	// token) updated within this window: a dual-stack home network is seen from its IPv4 address
+	// and from its IPv6 prefix (disabled when 0)
+	MergeWindow time.Duration
+
+	// Sort key and direction (the insertion order is used to break ties)
+	Sort       ListSort
+	Descending bool
+
+	// Maximum number of devices to return (all when 0)
+	Limit uint
+	// Return the devices after this position when set
+	Cursor *ListCursor
+}
+
+// ListCursor is the position of the last device of a page in the sorted device list
+type ListCursor struct {
+	Sort       ListSort `json:"s"`
+	Descending bool     `json:"d,omitempty"`
+	Id         uint     `json:"i"`
+	Name       string   `json:"n,omitempty"`
+	LastUpdate uint64   `json:"u,omitempty"`
+}
+
+// Error returned when a cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+func newListCursor(opts ListOptions, id uint, dev Device) *ListCursor {
+	cursor := &ListCursor{Sort: opts.Sort, Descending: opts.Descending, Id: id}
+	switch opts.Sort {
+	case SortByName:
+		cursor.Name = dev.Name
+	case SortByLastUpdate:
+		cursor.LastUpdate = dev.LastUpdate
+	}
+	return cursor
+}
+
+// Encode returns the cursor as an opaque string
+func (c *ListCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeListCursor parses a cursor returned by Encode
+func DecodeListCursor(str string) (*ListCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	cursor := &ListCursor{}
+	if err := json.Unmarshal(data, cursor); err != nil || int(cursor.Sort) >= len(listSortMap) {
+		return nil, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+func (i *deviceListInput) options() (ListOptions, error) {
+	opts := ListOptions{
+		Icon:          i.Icon,
+		InterfaceType: i.IfaceType,
+		Name:          i.Name,
+		Since:         i.Since,
+		Limit:         i.Limit,
+	}
+
+	// Parse filters
+	if i.Online != "" {
+		online := i.Online == "true"
+		opts.Online = &online
+	}
+
+	// Parse sort
+	if i.Sort != "" {
+		opts.Descending = strings.HasPrefix(i.Sort, "-")
+		opts.Sort = ListSort(ListSortFromString(strings.TrimPrefix(i.Sort, "-")))
+	}
+
+	// Parse cursor (the sort cannot change between pages)
+	if i.Cursor != "" {
+		cursor, err := DecodeListCursor(i.Cursor)
+		if err == nil && (cursor.Sort != opts.Sort || cursor.Descending != opts.Descending) {
+			err = errors.New("cursor does not match sort")
+		}
+		if err != nil {
+			return opts, huma.Error422UnprocessableEntity("invalid cursor", &huma.ErrorDetail{
+				Message:  err.Error(),
+				Location: "query.cursor",
+				Value:    i.Cursor,
+			})
+		}
+		opts.Cursor = cursor
+	}
+
+	return opts, nil
+}
+
+// Link to the next page with the same filters (RFC 8288): the list URL includes the configured
+// server URL when set, as the API can be served behind a reverse proxy with a path prefix.
+func (i *deviceListInput) nextLink(list_url string, next *ListCursor) string {
+	query := url.Values{}
+	for name, value := range map[string]string{
+		"online":     i.Online,
+		"icon":       i.Icon,
+		"iface_type": i.IfaceType,
+		"name":       i.Name,
+		"sort":       i.Sort,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if i.Since != 0 {
+		query.Set("since", strconv.FormatUint(i.Since, 10))
+	}
+	query.Set("limit", strconv.FormatUint(uint64(i.Limit), 10))
+	query.Set("cursor", next.Encode())
+	return "<" + list_url + "?" + query.Encode() + ">; rel=\"next\""
+}
+
+// URL of an operation path, prefixed by the first server URL of the OpenAPI specification
+func operationUrl(api huma.API, path string) string {
+	if servers := api.OpenAPI().Servers; len(servers) > 0 && servers[0].URL != "" {
+		return strings.TrimSuffix(servers[0].URL, "/") + path
+	}
+	return path
+}
+
+// Compare two devices with the sort key of the options, then with their identifiers
+func compareDevices(opts ListOptions, id_a uint, a Device, id_b uint, b Device) int {
+	result := 0
+	switch opts.Sort {
+	case SortByName:
+		result = strings.Compare(a.Name, b.Name)
+	case SortByLastUpdate:
+		result = cmp.Compare(a.LastUpdate, b.LastUpdate)
+	}
+	if result == 0 {
+		result = cmp.Compare(id_a, id_b)
+	}
+	if opts.Descending {
+		return -result
+	}
+	return result
+}
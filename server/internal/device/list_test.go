@@ -0,0 +1,285 @@
+package device
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// Seed a store with home networks of a few devices: one home out of four is dual-stack, its devices
+// are registered with the same token from its IPv4 address and from its IPv6 prefix
+func seedStore(tb testing.TB, store DeviceStore, homes int, devices int) {
+	ctx := context.Background()
+	for home := 0; home < homes; home++ {
+		ipv4 := fmt.Sprintf("10.%d.%d.1", home>>8&0xff, home&0xff)
+		ipv6 := fmt.Sprintf("2001:db8:%x::", home)
+		for index := 0; index < devices; index++ {
+			serial := fmt.Sprintf("%06x%02x", home, index)
+			dev := Device{
+				Serial:     serial,
+				Name:       fmt.Sprintf("Device %d", index),
+				HttpPort:   8080,
+				Online:     true,
+				Interfaces: []DeviceInterface{{Type: "ethernet", Name: "eth0", MacAddress: fmt.Sprintf("02:00:00:%02x:%02x:%02x", home>>8&0xff, home&0xff, index)}},
+			}
+			networks := []string{ipv4}
+			if home%4 == 0 {
+				networks = append(networks, ipv6)
+			}
+			for _, ip := range networks {
+				if err := store.Add(ctx, ip, dev); err != nil {
+					tb.Fatalf("failed to add device: %s", err)
+				} else if _, err := store.SetTokenHash(ctx, ip, serial, "hash-"+serial); err != nil {
+					tb.Fatalf("failed to set token hash: %s", err)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkList(b *testing.B) {
+	for _, store := range testStores {
+		b.Run(store.name, func(b *testing.B) {
+			s := store.open(b)
+			seedStore(b, s, 2000, 4)
+
+			for _, window := range []time.Duration{0, time.Hour} {
+				b.Run(fmt.Sprintf("merge=%s", window), func(b *testing.B) {
+					ctx := context.Background()
+					for i := 0; i < b.N; i++ {
+						list, _, err := s.List(ctx, "10.0.0.1", ListOptions{MergeWindow: window})
+						if err != nil {
+							b.Fatalf("failed to list devices: %s", err)
+						} else if len(list) != 4 {
+							b.Fatalf("got %d devices, want 4", len(list))
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+// Get the serial numbers of a device list
+func listSerials(list []Device) []string {
+	serials := []string{}
+	for _, dev := range list {
+		serials = append(serials, dev.Serial)
+	}
+	return serials
+}
+
+func TestListPages(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.open(t)
+			ctx := context.Background()
+			for _, dev := range []Device{
+				{Serial: "a", Name: "Kitchen", Online: true},
+				{Serial: "b", Name: "Bedroom"},
+				{Serial: "c", Name: "Living room", Online: true},
+				{Serial: "d", Name: "Bedroom", Online: true},
+				{Serial: "e", Name: "Attic"},
+			} {
+				if err := s.Add(ctx, "1.2.3.4", dev); err != nil {
+					t.Fatalf("failed to add device: %s", err)
+				}
+			}
+
+			for _, test := range []struct {
+				name  string
+				input deviceListInput
+				want  []string
+			}{
+				{"insertion", deviceListInput{}, []string{"a", "b", "c", "d", "e"}},
+				{"name", deviceListInput{Sort: "name"}, []string{"e", "b", "d", "a", "c"}},
+				{"name descending", deviceListInput{Sort: "-name"}, []string{"c", "a", "d", "b", "e"}},
+				{"last update", deviceListInput{Sort: "last_update"}, []string{"a", "b", "c", "d", "e"}},
+				{"last update descending", deviceListInput{Sort: "-last_update"}, []string{"e", "d", "c", "b", "a"}},
+				{"online", deviceListInput{Online: "true", Sort: "name"}, []string{"d", "a", "c"}},
+				{"name filter", deviceListInput{Name: "ROOM", Sort: "-name"}, []string{"c", "d", "b"}},
+			} {
+				t.Run(test.name, func(t *testing.T) {
+					// Walk the pages of two devices
+					got := []string{}
+					input := test.input
+					input.Limit = 2
+					for page := 0; page < len(test.want); page++ {
+						opts, err := input.options()
+						if err != nil {
+							t.Fatalf("invalid options: %s", err)
+						}
+						list, next, err := s.List(ctx, "1.2.3.4", opts)
+						if err != nil {
+							t.Fatalf("failed to list devices: %s", err)
+						}
+						got = append(got, listSerials(list)...)
+						if next == nil {
+							break
+						}
+						input.Cursor = next.Encode()
+					}
+					if !reflect.DeepEqual(got, test.want) {
+						t.Fatalf("got %v, want %v", got, test.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestListMerge(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.open(t)
+			ctx := context.Background()
+			for _, test := range []struct {
+				ip     string
+				serial string
+				hash   string
+			}{
+				{"1.2.3.4", "dual", "hash-dual"},
+				{"2001:db8::", "dual", "hash-dual"},
+				{"1.2.3.4", "ipv4", "hash-ipv4"},
+				{"2001:db8::", "ipv6", "hash-ipv6"},
+				{"2001:db8:1::", "ipv4", "hash-other"},
+				{"2001:db8:2::", "legacy", ""},
+				{"1.2.3.4", "legacy", ""},
+			} {
+				if err := s.Add(ctx, test.ip, Device{Serial: test.serial, Name: test.serial}); err != nil {
+					t.Fatalf("failed to add device: %s", err)
+				} else if test.hash == "" {
+					continue
+				} else if _, err := s.SetTokenHash(ctx, test.ip, test.serial, test.hash); err != nil {
+					t.Fatalf("failed to set token hash: %s", err)
+				}
+			}
+
+			for _, test := range []struct {
+				name     string
+				ip       string
+				window   time.Duration
+				want     []string
+				networks []string
+			}{
+				{"disabled", "1.2.3.4", 0, []string{"dual", "ipv4", "legacy"}, []string{"1.2.3.4"}},
+				{"ipv4", "1.2.3.4", time.Hour, []string{"dual", "ipv4", "ipv6", "legacy"}, []string{"1.2.3.4", "2001:db8::"}},
+				{"ipv6", "2001:db8::", time.Hour, []string{"dual", "ipv4", "ipv6", "legacy"}, []string{"2001:db8::", "1.2.3.4"}},
+				{"other token", "2001:db8:1::", time.Hour, []string{"ipv4"}, []string{"2001:db8:1::"}},
+				{"no token", "2001:db8:2::", time.Hour, []string{"legacy"}, []string{"2001:db8:2::"}},
+				{"unknown", "5.6.7.8", time.Hour, []string{}, []string{"5.6.7.8"}},
+			} {
+				t.Run(test.name, func(t *testing.T) {
+					list, _, err := s.List(ctx, test.ip, ListOptions{MergeWindow: test.window})
+					if err != nil {
+						t.Fatalf("failed to list devices: %s", err)
+					} else if got := listSerials(list); !reflect.DeepEqual(got, test.want) {
+						t.Fatalf("got %v, want %v", got, test.want)
+					}
+					if networks, err := s.LinkedNetworks(ctx, test.ip, test.window); err != nil {
+						t.Fatalf("failed to get linked networks: %s", err)
+					} else if !reflect.DeepEqual(networks, test.networks) {
+						t.Fatalf("got networks %v, want %v", networks, test.networks)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestDecodeListCursor(t *testing.T) {
+	valid := &ListCursor{Sort: SortByName, Descending: true, Id: 12, Name: "Kitchen"}
+	for _, test := range []struct {
+		name   string
+		cursor string
+		want   *ListCursor
+	}{
+		{"valid", valid.Encode(), valid},
+		{"last update", (&ListCursor{Sort: SortByLastUpdate, Id: 3, LastUpdate: 1700000000}).Encode(), &ListCursor{Sort: SortByLastUpdate, Id: 3, LastUpdate: 1700000000}},
+		{"invalid base64", "!!!", nil},
+		{"invalid JSON", base64.RawURLEncoding.EncodeToString([]byte("{")), nil},
+		{"unknown sort", base64.RawURLEncoding.EncodeToString([]byte(`{"s":3,"i":1}`)), nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := DecodeListCursor(test.cursor)
+			if test.want == nil && !errors.Is(err, ErrInvalidCursor) {
+				t.Fatalf("got %+v, %v, want %v", got, err, ErrInvalidCursor)
+			} else if test.want != nil && (err != nil || !reflect.DeepEqual(got, test.want)) {
+				t.Fatalf("got %+v, %v, want %+v", got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestListOptionsCursor(t *testing.T) {
+	by_name := (&ListCursor{Sort: SortByName, Id: 1, Name: "Kitchen"}).Encode()
+	for _, test := range []struct {
+		name  string
+		input deviceListInput
+		valid bool
+	}{
+		{"same sort", deviceListInput{Sort: "name", Cursor: by_name}, true},
+		{"other sort", deviceListInput{Sort: "last_update", Cursor: by_name}, false},
+		{"other direction", deviceListInput{Sort: "-name", Cursor: by_name}, false},
+		{"invalid", deviceListInput{Sort: "name", Cursor: "invalid"}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			opts, err := test.input.options()
+			var status huma.StatusError
+			if test.valid && (err != nil || opts.Cursor == nil) {
+				t.Fatalf("got %+v, %v, want a cursor", opts.Cursor, err)
+			} else if !test.valid && (!errors.As(err, &status) || status.GetStatus() != http.StatusUnprocessableEntity) {
+				t.Fatalf("got %v, want status %d", err, http.StatusUnprocessableEntity)
+			}
+		})
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	next := &ListCursor{Sort: SortByName, Id: 7, Name: "Kitchen & bed"}
+	for _, test := range []struct {
+		name     string
+		list_url string
+		input    deviceListInput
+		want     url.Values
+	}{
+		{"no filter", "/device/list", deviceListInput{Sort: "name", Limit: 10}, url.Values{"sort": {"name"}, "limit": {"10"}}},
+		{"filters", "/device/list", deviceListInput{Online: "true", Icon: "bed", IfaceType: "wifi", Name: "a&b", Since: 1700000000, Sort: "name", Limit: 2}, url.Values{"online": {"true"}, "icon": {"bed"}, "iface_type": {"wifi"}, "name": {"a&b"}, "since": {"1700000000"}, "sort": {"name"}, "limit": {"2"}}},
+		{"server url", "https://example.com/api/device/list", deviceListInput{Sort: "name", Limit: 1}, url.Values{"sort": {"name"}, "limit": {"1"}}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			// Parse link
+			link := test.input.nextLink(test.list_url, next)
+			target, found := strings.CutSuffix(link, `>; rel="next"`)
+			if !found || !strings.HasPrefix(target, "<") {
+				t.Fatalf("invalid link %q", link)
+			}
+			parsed, err := url.Parse(target[1:])
+			if err != nil {
+				t.Fatalf("invalid link %q: %s", link, err)
+			} else if path := strings.TrimSuffix(target[1:], "?"+parsed.RawQuery); path != test.list_url {
+				t.Fatalf("got URL %q, want %q", path, test.list_url)
+			}
+
+			// Check query and cursor
+			query := parsed.Query()
+			cursor, err := DecodeListCursor(query.Get("cursor"))
+			if err != nil || !reflect.DeepEqual(cursor, next) {
+				t.Fatalf("got cursor %+v, %v, want %+v", cursor, err, next)
+			}
+			query.Del("cursor")
+			if !reflect.DeepEqual(query, test.want) {
+				t.Fatalf("got query %v, want %v", query, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,605 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// In-memory device key
+type memoryKey struct {
+	ip     string
+	serial string
+}
+
+// In-memory device entry
+type memoryDevice struct {
+	id         uint
+	device     Device
+	token_hash string
+}
+
+// Read / write lock of the in-memory store
+type memoryLock interface {
+	sync.Locker
+	RLock()
+	RUnlock()
+}
+
+// Lock already held by the caller (see Change)
+type heldLock struct{}
+
+func (heldLock) Lock()    {}
+func (heldLock) Unlock()  {}
+func (heldLock) RLock()   {}
+func (heldLock) RUnlock() {}
+
+// In-memory device store
+type memoryStore struct {
+	mutex   memoryLock
+	next_id *uint
+	devices map[memoryKey]*memoryDevice
+
+	// Device entries indexed by network then serial number, and by serial number then network
+	networks map[string]map[string]*memoryDevice
+	serials  map[string]map[string]*memoryDevice
+}
+
+// NewMemoryStore creates a device store kept in memory (all devices are lost on exit)
+func NewMemoryStore() DeviceStore {
+	return &memoryStore{
+		mutex:   &sync.RWMutex{},
+		next_id: new(uint),
+		devices: make(map[memoryKey]*memoryDevice),
+
+		networks: make(map[string]map[string]*memoryDevice),
+		serials:  make(map[string]map[string]*memoryDevice),
+	}
+}
+
+// Add an entry to a two-level index
+func addIndex(index map[string]map[string]*memoryDevice, first string, second string, entry *memoryDevice) {
+	entries, found := index[first]
+	if !found {
+		entries = make(map[string]*memoryDevice)
+		index[first] = entries
+	}
+	entries[second] = entry
+}
+
+// Remove an entry from a two-level index
+func removeIndex(index map[string]map[string]*memoryDevice, first string, second string) {
+	delete(index[first], second)
+	if len(index[first]) == 0 {
+		delete(index, first)
+	}
+}
+
+// Insert a device entry (the lock must be held)
+func (s *memoryStore) insert(key memoryKey, entry *memoryDevice) {
+	s.devices[key] = entry
+	addIndex(s.networks, key.ip, key.serial, entry)
+	addIndex(s.serials, key.serial, key.ip, entry)
+}
+
+// Delete a device entry (the lock must be held)
+func (s *memoryStore) delete(key memoryKey) {
+	delete(s.devices, key)
+	removeIndex(s.networks, key.ip, key.serial)
+	removeIndex(s.serials, key.serial, key.ip)
+}
+
+func memoryKeyFromIp(ip string, serial string) (memoryKey, error) {
+	// Normalize IPv4 / IPv6 address as done by the database
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return memoryKey{}, fmt.Errorf("invalid network address %q", ip)
+	}
+	return memoryKey{ip: addr.String(), serial: serial}, nil
+}
+
+// Compare network addresses (IPv4 addresses before IPv6 ones)
+func compareNetworks(a string, b string) int {
+	a_ip, b_ip := net.ParseIP(a), net.ParseIP(b)
+	if a_ipv4, b_ipv4 := a_ip.To4(), b_ip.To4(); a_ipv4 != nil && b_ipv4 != nil {
+		return bytes.Compare(a_ipv4, b_ipv4)
+	} else if a_ipv4 != nil {
+		return -1
+	} else if b_ipv4 != nil {
+		return 1
+	}
+	return bytes.Compare(a_ip, b_ip)
+}
+
+func normalizeInterface(iface DeviceInterface) DeviceInterface {
+	// Convert values as done by the database
+	iface.Type = InterfaceType.ToString(InterfaceType(InterfaceTypeFromString(iface.Type)))
+	iface.MacAddress = utils.Uint64ToHwAddress(utils.Uint64FromHwAddress(iface.MacAddress))
+	if addr := net.ParseIP(iface.Ipv4Address).To4(); addr != nil {
+		iface.Ipv4Address = addr.String()
+	} else {
+		iface.Ipv4Address = ""
+	}
+	if addr := net.ParseIP(iface.Ipv6Address); addr != nil {
+		iface.Ipv6Address = addr.String()
+	} else {
+		iface.Ipv6Address = ""
+	}
+	return iface
+}
+
+func (e *memoryDevice) match(opts ListOptions) bool {
+	// Check device filters
+	dev := &e.device
+	if (opts.Online != nil && dev.Online != *opts.Online) ||
+		(opts.Icon != "" && dev.Icon != opts.Icon) ||
+		(opts.Name != "" && !strings.Contains(strings.ToLower(dev.Name), strings.ToLower(opts.Name))) ||
+		dev.LastUpdate < opts.Since {
+		return false
+	}
+
+	// Check interface filter
+	if opts.InterfaceType == "" {
+		return true
+	}
+	for _, iface := range dev.Interfaces {
+		if iface.Type == opts.InterfaceType {
+			return true
+		}
+	}
+	return false
+}
+
+// Check two entries are the same device: same serial number and same token (the serial number and
+// the MAC addresses are sent by the client, only the token proves the device is the same)
+func (entry *memoryDevice) sameDevice(other *memoryDevice) bool {
+	return entry.token_hash != "" && entry.device.Serial == other.device.Serial && entry.token_hash == other.token_hash
+}
+
+// Get the network and the networks linked to it (see ListOptions.MergeWindow)
+func (s *memoryStore) listNetworks(ip string, window time.Duration) map[string]bool {
+	networks := map[string]bool{ip: true}
+	if window <= 0 {
+		return networks
+	}
+
+	// Find the same devices updated within the window on other networks
+	seconds := uint64(window.Seconds())
+	for serial, entry := range s.networks[ip] {
+		for other_ip, other := range s.serials[serial] {
+			if other_ip != ip && entry.sameDevice(other) &&
+				other.device.LastUpdate+seconds >= entry.device.LastUpdate && other.device.LastUpdate <= entry.device.LastUpdate+seconds {
+				networks[other_ip] = true
+			}
+		}
+	}
+	return networks
+}
+
+// Check a more recent entry of the device is available in the networks
+func (s *memoryStore) hasNewerEntry(networks map[string]bool, entry *memoryDevice) bool {
+	for ip, other := range s.serials[entry.device.Serial] {
+		if networks[ip] &&
+			(other.device.LastUpdate > entry.device.LastUpdate || (other.device.LastUpdate == entry.device.LastUpdate && other.id > entry.id)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) List(ctx context.Context, ip string, opts ListOptions) ([]Device, *ListCursor, error) {
+	// Create device list
+	list := []Device{}
+
+	key, err := memoryKeyFromIp(ip, "")
+	if err != nil {
+		return list, nil, nil
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Find matching devices after the cursor (only the last entry of a device seen on many networks)
+	networks := s.listNetworks(key.ip, opts.MergeWindow)
+	entries := []*memoryDevice{}
+	for network := range networks {
+		for _, entry := range s.networks[network] {
+			if !entry.match(opts) {
+				continue
+			}
+			if len(networks) > 1 && s.hasNewerEntry(networks, entry) {
+				continue
+			}
+			if opts.Cursor != nil {
+				cursor := Device{Name: opts.Cursor.Name, LastUpdate: opts.Cursor.LastUpdate}
+				if compareDevices(opts, entry.id, entry.device, opts.Cursor.Id, cursor) <= 0 {
+					continue
+				}
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	// Generate sorted list
+	sort.Slice(entries, func(i, j int) bool {
+		return compareDevices(opts, entries[i].id, entries[i].device, entries[j].id, entries[j].device) < 0
+	})
+	var next *ListCursor
+	if opts.Limit > 0 && uint(len(entries)) > opts.Limit {
+		entries = entries[:opts.Limit]
+		last := entries[len(entries)-1]
+		next = newListCursor(opts, last.id, last.device)
+	}
+	for _, entry := range entries {
+		dev := entry.device
+		dev.Interfaces = append([]DeviceInterface{}, entry.device.Interfaces...)
+		list = append(list, dev)
+	}
+
+	return list, next, nil
+}
+
+func (s *memoryStore) ListNetworks(ctx context.Context) ([]Network, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Count devices per network
+	networks := map[string]*Network{}
+	for key, entry := range s.devices {
+		network, found := networks[key.ip]
+		if !found {
+			network = &Network{Ip: key.ip}
+			networks[key.ip] = network
+		}
+		network.Devices++
+		if entry.device.Online {
+			network.Online++
+		}
+	}
+
+	// Generate list sorted by IP address
+	list := []Network{}
+	for _, network := range networks {
+		list = append(list, *network)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return compareNetworks(list[i].Ip, list[j].Ip) < 0
+	})
+
+	return list, nil
+}
+
+func (s *memoryStore) Search(ctx context.Context, ip string, query string) ([]NetworkDevice, error) {
+	// Get network when set
+	network := ""
+	if ip != "" {
+		key, err := memoryKeyFromIp(ip, "")
+		if err != nil {
+			return []NetworkDevice{}, nil
+		}
+		network = key.ip
+	}
+	query = strings.ToLower(query)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Find matching devices
+	keys := []memoryKey{}
+	for key, entry := range s.devices {
+		if (network == "" || key.ip == network) &&
+			(strings.Contains(strings.ToLower(entry.device.Serial), query) || strings.Contains(strings.ToLower(entry.device.Name), query)) {
+			keys = append(keys, key)
+		}
+	}
+
+	// Generate list sorted by IP address and insertion
+	sort.Slice(keys, func(i, j int) bool {
+		if cmp := compareNetworks(keys[i].ip, keys[j].ip); cmp != 0 {
+			return cmp < 0
+		}
+		return s.devices[keys[i]].id < s.devices[keys[j]].id
+	})
+	list := []NetworkDevice{}
+	for _, key := range keys {
+		dev := s.devices[key].device
+		dev.Interfaces = append([]DeviceInterface{}, dev.Interfaces...)
+		list = append(list, NetworkDevice{Ip: key.ip, Device: dev})
+	}
+
+	return list, nil
+}
+
+func (s *memoryStore) Add(ctx context.Context, ip string, dev Device) error {
+	// Check values before any change
+	if err := validateDevice(dev); err != nil {
+		return err
+	}
+	key, err := memoryKeyFromIp(ip, dev.Serial)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Add or update device
+	entry, found := s.devices[key]
+	if !found {
+		*s.next_id++
+		entry = &memoryDevice{id: *s.next_id}
+		s.insert(key, entry)
+	}
+	ifaces := entry.device.Interfaces
+	entry.device = Device{
+		Serial:      dev.Serial,
+		Name:        dev.Name,
+		Description: dev.Description,
+		Icon:        Icon.ToString(Icon(IconFromString(dev.Icon))),
+		Location:    dev.Location,
+		HttpPort:    dev.HttpPort,
+		HttpsPort:   dev.HttpsPort,
+		Online:      dev.Online,
+		LastUpdate:  uint64(time.Now().Unix()),
+		Interfaces:  ifaces,
+	}
+	if entry.device.Interfaces == nil {
+		entry.device.Interfaces = []DeviceInterface{}
+	}
+
+	// Replace interfaces
+	if dev.Interfaces != nil {
+		entry.device.Interfaces = []DeviceInterface{}
+		for _, iface := range dev.Interfaces {
+			entry.setInterface(normalizeInterface(iface))
+		}
+	}
+
+	return nil
+}
+
+func (e *memoryDevice) setInterface(iface DeviceInterface) {
+	for index := range e.device.Interfaces {
+		if e.device.Interfaces[index].MacAddress == iface.MacAddress {
+			e.device.Interfaces[index] = iface
+			return
+		}
+	}
+	e.device.Interfaces = append(e.device.Interfaces, iface)
+}
+
+// Get a device entry (the lock must be held)
+func (s *memoryStore) getDevice(ip string, serial string) (*memoryDevice, error) {
+	key, err := memoryKeyFromIp(ip, serial)
+	if err != nil {
+		return nil, ErrDeviceNotFound
+	}
+	entry, found := s.devices[key]
+	if !found {
+		return nil, ErrDeviceNotFound
+	}
+	return entry, nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, ip string, serial string) (Device, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Get device
+	entry, err := s.getDevice(ip, serial)
+	if err != nil {
+		return Device{}, err
+	}
+	dev := entry.device
+	dev.Interfaces = append([]DeviceInterface{}, entry.device.Interfaces...)
+	return dev, nil
+}
+
+func (s *memoryStore) Remove(ctx context.Context, ip string, serial string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Remove device
+	key, err := memoryKeyFromIp(ip, serial)
+	if err != nil {
+		return ErrDeviceNotFound
+	}
+	if _, found := s.devices[key]; !found {
+		return ErrDeviceNotFound
+	}
+	s.delete(key)
+	return nil
+}
+
+func (s *memoryStore) UpdateStatus(ctx context.Context, ip string, serial string, online bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Update status
+	entry, err := s.getDevice(ip, serial)
+	if err != nil {
+		return err
+	}
+	entry.device.Online = online
+	entry.device.LastUpdate = uint64(time.Now().Unix())
+	return nil
+}
+
+func (s *memoryStore) AddAddress(ctx context.Context, ip string, serial string, iface DeviceInterface, update bool) error {
+	// Check values
+	if err := validateInterface(iface); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Add or update address
+	entry, err := s.getDevice(ip, serial)
+	if err != nil {
+		return err
+	}
+	entry.setInterface(normalizeInterface(iface))
+
+	// Update device
+	if update {
+		entry.device.Online = true
+		entry.device.LastUpdate = uint64(time.Now().Unix())
+	}
+
+	return nil
+}
+
+func (s *memoryStore) RemoveAddress(ctx context.Context, ip string, serial string, hw_address string, update bool) error {
+	// Check values
+	if err := validateMac("mac", hw_address); err != nil {
+		return err
+	}
+	mac := utils.Uint64ToHwAddress(utils.Uint64FromHwAddress(hw_address))
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, err := s.getDevice(ip, serial)
+	if err != nil {
+		return err
+	}
+
+	// Remove address
+	removed := false
+	ifaces := []DeviceInterface{}
+	for _, iface := range entry.device.Interfaces {
+		if iface.MacAddress == mac {
+			removed = true
+			continue
+		}
+		ifaces = append(ifaces, iface)
+	}
+	if !removed {
+		return ErrInterfaceNotFound
+	}
+	entry.device.Interfaces = ifaces
+
+	// Update device
+	if update {
+		entry.device.Online = true
+		entry.device.LastUpdate = uint64(time.Now().Unix())
+	}
+
+	return nil
+}
+
+func (s *memoryStore) RemoveAddresses(ctx context.Context, ip string, serial string, update bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Remove addresses
+	entry, err := s.getDevice(ip, serial)
+	if err != nil {
+		return err
+	}
+	entry.device.Interfaces = []DeviceInterface{}
+
+	// Update device
+	if update {
+		entry.device.Online = true
+		entry.device.LastUpdate = uint64(time.Now().Unix())
+	}
+
+	return nil
+}
+
+func (s *memoryStore) Change(ctx context.Context, ip string, serial string, fn func(tx DeviceStore) error) error {
+	if _, held := s.mutex.(heldLock); held {
+		return fn(s)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Apply changes with the lock held
+	return fn(&memoryStore{mutex: heldLock{}, next_id: s.next_id, devices: s.devices, networks: s.networks, serials: s.serials})
+}
+
+func (s *memoryStore) GetTokenHash(ctx context.Context, ip string, serial string) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Get token hash
+	if entry, err := s.getDevice(ip, serial); err == nil {
+		return entry.token_hash, nil
+	}
+	return "", nil
+}
+
+func (s *memoryStore) SetTokenHash(ctx context.Context, ip string, serial string, hash string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Update token hash
+	entry, err := s.getDevice(ip, serial)
+	if err != nil || entry.token_hash != "" {
+		return false, nil
+	}
+	entry.token_hash = hash
+	return true, nil
+}
+
+func (s *memoryStore) LinkedNetworks(ctx context.Context, ip string, window time.Duration) ([]string, error) {
+	key, err := memoryKeyFromIp(ip, "")
+	if err != nil {
+		return []string{ip}, nil
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Generate list with the network first
+	list := []string{key.ip}
+	for network := range s.listNetworks(key.ip, window) {
+		if network != key.ip {
+			list = append(list, network)
+		}
+	}
+	sort.Slice(list[1:], func(i, j int) bool {
+		return compareNetworks(list[1+i], list[1+j]) < 0
+	})
+	return list, nil
+}
+
+func (s *memoryStore) ExpireOnline(ctx context.Context, before uint64) ([]NetworkSerial, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Set devices offline (the last update timestamp is kept)
+	list := []NetworkSerial{}
+	for key, entry := range s.devices {
+		if entry.device.Online && entry.device.LastUpdate < before {
+			entry.device.Online = false
+			list = append(list, NetworkSerial{Ip: key.ip, Serial: key.serial})
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryStore) PurgeOffline(ctx context.Context, before uint64) ([]NetworkSerial, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Remove devices
+	list := []NetworkSerial{}
+	for key, entry := range s.devices {
+		if !entry.device.Online && entry.device.LastUpdate < before {
+			s.delete(key)
+			list = append(list, NetworkSerial{Ip: key.ip, Serial: key.serial})
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,222 @@
+package device
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Device tables migrations per dialect (append only: never modify an applied migration)
+var migrations = map[utils.Dialect][]utils.Migration{
+	utils.MySQL:      mysqlMigrations,
+	utils.SQLite:     sqliteMigrations,
+	utils.PostgreSQL: postgresMigrations,
+}
+
+var mysqlMigrations = []utils.Migration{
+	{
+		Version:     1,
+		Description: "create device and device_iface tables",
+		Up: utils.Statements(
+			`CREATE TABLE IF NOT EXISTS device (
+  id INT(11) NOT NULL AUTO_INCREMENT,
+  ip INT(10) unsigned NOT NULL,
+  serial VARCHAR(17) NOT NULL,
+  name VARCHAR(128) NOT NULL,
+  description VARCHAR(256),
+  icon TINYINT(3) unsigned NOT NULL DEFAULT 0,
+  location VARCHAR(128),
+  http_port MEDIUMINT(9) NOT NULL,
+  https_port MEDIUMINT(9) NOT NULL DEFAULT 0,
+  online BOOL DEFAULT FALSE,
+  last_update BIGINT(4) UNSIGNED NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY serial_ip (serial,ip),
+  KEY serial (serial),
+  KEY ip (ip)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_uca1400_ai_ci;`,
+			`CREATE TABLE IF NOT EXISTS device_iface (
+  id INT(11) NOT NULL AUTO_INCREMENT,
+  device_id INT(11) NOT NULL,
+  ipv4 INT(10) UNSIGNED,
+  ipv6 VARBINARY(16),
+  mac BIGINT(20) UNSIGNED NOT NULL,
+  name VARCHAR(128) NOT NULL DEFAULT 'Unknown',
+  type INT(11) NOT NULL DEFAULT 0,
+  PRIMARY KEY (id),
+  UNIQUE KEY device_id_mac (device_id,mac),
+  CONSTRAINT device_iface_constraint FOREIGN KEY (device_id) REFERENCES device (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_uca1400_ai_ci;`,
+		),
+	},
+	{
+		Version:     2,
+		Description: "add device token",
+		Up:          utils.Statements(`ALTER TABLE device ADD COLUMN token_hash CHAR(64) AFTER location;`),
+	},
+	{
+		Version:     3,
+		Description: "store IPv4 / IPv6 network address",
+		Up:          mysqlMigrateNetwork,
+	},
+}
+
+// mysqlColumnType returns the data type of a device table column, or an empty string when the
+// column does not exist
+func mysqlColumnType(tx *sql.Tx, column string) (string, error) {
+	var data_type string
+	err := tx.QueryRow(`SELECT DATA_TYPE FROM information_schema.COLUMNS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'device' AND COLUMN_NAME = ?`, column).Scan(&data_type)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return data_type, err
+}
+
+// mysqlMigrateNetwork converts the IPv4 integer column to a binary network address.
+//
+// MySQL / MariaDB implicitly commits each DDL statement, so every step checks the current schema
+// and the migration can be applied again after a failure at any step.
+func mysqlMigrateNetwork(tx *sql.Tx) error {
+	// Already converted (only the version update failed)
+	if ip_type, err := mysqlColumnType(tx, "ip"); err != nil {
+		return err
+	} else if ip_type == "varbinary" {
+		return nil
+	}
+
+	// Add the temporary network column
+	if network_type, err := mysqlColumnType(tx, "network"); err != nil {
+		return err
+	} else if network_type == "" {
+		if _, err := tx.Exec(`ALTER TABLE device ADD COLUMN network VARBINARY(16) AFTER ip;`); err != nil {
+			return err
+		}
+	}
+
+	// Convert the addresses and replace the column in a single statement
+	return utils.Statements(
+		`UPDATE device SET network = INET6_ATON(INET_NTOA(ip));`,
+		`ALTER TABLE device DROP INDEX serial_ip, DROP INDEX ip, DROP COLUMN ip,
+  CHANGE network ip VARBINARY(16) NOT NULL, ADD UNIQUE KEY serial_ip (serial,ip), ADD KEY ip (ip);`,
+	)(tx)
+}
+
+var sqliteMigrations = []utils.Migration{
+	{
+		Version:     1,
+		Description: "create device and device_iface tables",
+		Up: utils.Statements(
+			`CREATE TABLE IF NOT EXISTS device (
+  id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  ip INTEGER NOT NULL,
+  serial VARCHAR(17) NOT NULL,
+  name VARCHAR(128) NOT NULL,
+  description VARCHAR(256),
+  icon INTEGER NOT NULL DEFAULT 0,
+  location VARCHAR(128),
+  http_port INTEGER NOT NULL,
+  https_port INTEGER NOT NULL DEFAULT 0,
+  online BOOLEAN DEFAULT FALSE,
+  last_update INTEGER NOT NULL,
+  UNIQUE (serial, ip)
+);`,
+			`CREATE INDEX IF NOT EXISTS device_serial ON device (serial);`,
+			`CREATE INDEX IF NOT EXISTS device_ip ON device (ip);`,
+			`CREATE TABLE IF NOT EXISTS device_iface (
+  id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  device_id INTEGER NOT NULL REFERENCES device (id) ON DELETE CASCADE,
+  ipv4 INTEGER,
+  ipv6 BLOB,
+  mac INTEGER NOT NULL,
+  name VARCHAR(128) NOT NULL DEFAULT 'Unknown',
+  type INTEGER NOT NULL DEFAULT 0,
+  UNIQUE (device_id, mac)
+);`,
+		),
+	},
+	{
+		Version:     2,
+		Description: "add device token",
+		Up:          utils.Statements(`ALTER TABLE device ADD COLUMN token_hash CHAR(64);`),
+	},
+	{
+		// SQLite columns are dynamically typed: the IPv4 integers are replaced in place by the
+		// INET6_ATON() blobs, so the table does not need to be rebuilt
+		Version:     3,
+		Description: "store IPv4 / IPv6 network address",
+		Up:          utils.Statements(`UPDATE device SET ip = INET6_ATON(INET_NTOA(ip));`),
+	},
+}
+
+var postgresMigrations = []utils.Migration{
+	{
+		Version:     1,
+		Description: "create device and device_iface tables",
+		Up: utils.Statements(
+			`CREATE TABLE IF NOT EXISTS device (
+  id SERIAL PRIMARY KEY,
+  ip inet NOT NULL,
+  serial VARCHAR(17) NOT NULL,
+  name VARCHAR(128) NOT NULL,
+  description VARCHAR(256),
+  icon SMALLINT NOT NULL DEFAULT 0,
+  location VARCHAR(128),
+  http_port INTEGER NOT NULL,
+  https_port INTEGER NOT NULL DEFAULT 0,
+  online BOOLEAN DEFAULT FALSE,
+  last_update BIGINT NOT NULL,
+  CONSTRAINT serial_ip UNIQUE (serial, ip)
+);`,
+			`CREATE INDEX IF NOT EXISTS device_serial ON device (serial);`,
+			`CREATE INDEX IF NOT EXISTS device_ip ON device (ip);`,
+			`CREATE TABLE IF NOT EXISTS device_iface (
+  id SERIAL PRIMARY KEY,
+  device_id INTEGER NOT NULL REFERENCES device (id) ON DELETE CASCADE,
+  ipv4 inet,
+  ipv6 inet,
+  mac macaddr NOT NULL,
+  name VARCHAR(128) NOT NULL DEFAULT 'Unknown',
+  type INTEGER NOT NULL DEFAULT 0,
+  CONSTRAINT device_id_mac UNIQUE (device_id, mac)
+);`,
+		),
+	},
+	{
+		Version:     2,
+		Description: "add device token",
+		Up:          utils.Statements(`ALTER TABLE device ADD COLUMN token_hash CHAR(64);`),
+	},
+	{
+		// The inet type already stores IPv4 and IPv6 addresses
+		Version:     3,
+		Description: "store IPv4 / IPv6 network address",
+		Up:          utils.Statements(),
+	},
+}
+
+func InitializeTables(db *sql.DB, dialect utils.Dialect) bool {
+	// Upgrade tables to last version
+	if err := utils.Migrate(db, dialect, "device", migrations[dialect]); err != nil {
+		log.Errorf("failed to migrate Device tables: %s", err)
+		return false
+	}
+
+	return true
+}
+
+// CheckTables checks the Device tables are migrated to the last version
+func CheckTables(db *sql.DB, dialect utils.Dialect) error {
+	version, err := utils.GetTableVersion(db, dialect, "device")
+	if err != nil {
+		return fmt.Errorf("failed to get device tables version: %w", err)
+	}
+	expected := uint(len(migrations[dialect]))
+	if version != expected {
+		return fmt.Errorf("device tables version is %d instead of %d", version, expected)
+	}
+	return nil
+}
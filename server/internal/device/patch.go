@@ -0,0 +1,83 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// Apply a JSON merge patch (RFC 7396) on a decoded JSON value
+func mergePatch(target any, patch any) any {
+	// Non-object patch replaces the target
+	patch_object, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	target_object, ok := target.(map[string]any)
+	if !ok {
+		target_object = map[string]any{}
+	}
+
+	// Merge members: null removes the member
+	for name, value := range patch_object {
+		if value == nil {
+			delete(target_object, name)
+		} else {
+			target_object[name] = mergePatch(target_object[name], value)
+		}
+	}
+
+	return target_object
+}
+
+// patchDevice applies a JSON merge patch on a device: the interfaces are kept unset when not
+// patched, so the store leaves them untouched.
+func patchDevice(dev Device, patch map[string]any) (Device, error) {
+	// Convert device to JSON value
+	data, err := json.Marshal(dev)
+	if err != nil {
+		return dev, err
+	}
+	var target any
+	if err := json.Unmarshal(data, &target); err != nil {
+		return dev, err
+	}
+
+	// Apply patch and convert back to device
+	if data, err = json.Marshal(mergePatch(target, patch)); err != nil {
+		return dev, err
+	}
+	patched := Device{}
+	if err := json.Unmarshal(data, &patched); err != nil {
+		var type_err *json.UnmarshalTypeError
+		if errors.As(err, &type_err) {
+			return dev, &utils.ValidationError{Location: type_err.Field, Value: patch[strings.Split(type_err.Field, ".")[0]], Message: "expected " + type_err.Type.String()}
+		}
+		return dev, err
+	}
+
+	// Serial number identifies the device
+	if patched.Serial != dev.Serial {
+		return dev, &utils.ValidationError{Location: "serial", Value: patched.Serial, Message: "serial number cannot be changed"}
+	}
+	if _, found := patch["ifaces"]; !found {
+		patched.Interfaces = nil
+	} else if patched.Interfaces == nil {
+		patched.Interfaces = []DeviceInterface{}
+	}
+
+	// Check enumerations (not validated by the API for a patch)
+	if patched.Icon != "" && Icon.ToString(Icon(IconFromString(patched.Icon))) != patched.Icon {
+		return dev, &utils.ValidationError{Location: "icon", Value: patched.Icon, Message: "unknown icon"}
+	}
+	for index, iface := range patched.Interfaces {
+		if iface.Type != "" && InterfaceType.ToString(InterfaceType(InterfaceTypeFromString(iface.Type))) != iface.Type {
+			return dev, &utils.ValidationError{Location: fmt.Sprintf("ifaces[%d].type", index), Value: iface.Type, Message: "unknown interface type"}
+		}
+	}
+
+	return patched, nil
+}
@@ -0,0 +1,90 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// Decode a JSON value of a test
+func decodeJSON(t *testing.T, data string) any {
+	var value any
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatalf("invalid JSON %q: %s", data, err)
+	}
+	return value
+}
+
+func TestMergePatch(t *testing.T) {
+	// Test cases of RFC 7396 appendix A
+	for _, test := range []struct {
+		target string
+		patch  string
+		want   string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	} {
+		t.Run(test.target+" "+test.patch, func(t *testing.T) {
+			got := mergePatch(decodeJSON(t, test.target), decodeJSON(t, test.patch))
+			if want := decodeJSON(t, test.want); !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPatchDevice(t *testing.T) {
+	dev := Device{
+		Serial:     "serial",
+		Name:       "Living room",
+		Icon:       "living",
+		HttpPort:   8080,
+		Interfaces: []DeviceInterface{{Type: "ethernet", Name: "eth0", MacAddress: "01:23:45:67:89:ab"}},
+	}
+
+	for _, test := range []struct {
+		name     string
+		patch    string
+		want     Device
+		location string
+	}{
+		{"name", `{"name":"Kitchen"}`, Device{Serial: "serial", Name: "Kitchen", Icon: "living", HttpPort: 8080}, ""},
+		{"remove icon", `{"icon":null}`, Device{Serial: "serial", Name: "Living room", HttpPort: 8080}, ""},
+		{"same serial", `{"serial":"serial"}`, Device{Serial: "serial", Name: "Living room", Icon: "living", HttpPort: 8080}, ""},
+		{"remove interfaces", `{"ifaces":null}`, Device{Serial: "serial", Name: "Living room", Icon: "living", HttpPort: 8080, Interfaces: []DeviceInterface{}}, ""},
+		{"replace interfaces", `{"ifaces":[{"name":"wlan0","mac":"01:23:45:67:89:ac","type":"wifi"}]}`, Device{Serial: "serial", Name: "Living room", Icon: "living", HttpPort: 8080, Interfaces: []DeviceInterface{{Type: "wifi", Name: "wlan0", MacAddress: "01:23:45:67:89:ac"}}}, ""},
+		{"change serial", `{"serial":"other"}`, dev, "serial"},
+		{"invalid type", `{"http_port":"80"}`, dev, "http_port"},
+		{"unknown icon", `{"icon":"garage"}`, dev, "icon"},
+		{"unknown interface type", `{"ifaces":[{"name":"usb0","mac":"01:23:45:67:89:ac","type":"usb"}]}`, dev, "ifaces[0].type"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := patchDevice(dev, decodeJSON(t, test.patch).(map[string]any))
+			var verr *utils.ValidationError
+			if test.location == "" && err != nil {
+				t.Fatalf("got %v, want no error", err)
+			} else if test.location != "" && (!errors.As(err, &verr) || verr.Location != test.location) {
+				t.Fatalf("got %v, want validation error on %q", err, test.location)
+			} else if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package device
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Reaper is a background worker detecting the devices which stopped to send heartbeats.
+//
+// An online device not updated during the heartbeat timeout is set offline, and an offline device
+// not updated during the retention period is removed from the registry.
+type Reaper struct {
+	store     DeviceStore
+	timeout   time.Duration
+	retention time.Duration
+	interval  time.Duration
+}
+
+// NewReaper creates a reaper: a zero timeout or retention disables the related action
+func NewReaper(store DeviceStore, timeout time.Duration, retention time.Duration) *Reaper {
+	// Check often enough to detect an offline device within 1.5 x timeout
+	interval := timeout / 2
+	if timeout <= 0 {
+		interval = retention / 2
+	}
+	if interval > time.Minute {
+		interval = time.Minute
+	} else if interval < time.Second {
+		interval = time.Second
+	}
+
+	return &Reaper{
+		store:     store,
+		timeout:   timeout,
+		retention: retention,
+		interval:  interval,
+	}
+}
+
+// Run executes the reaper until the context is canceled
+func (r *Reaper) Run(ctx context.Context) {
+	if r.timeout <= 0 && r.retention <= 0 {
+		return
+	}
+
+	log.Infof("device reaper started: timeout = %s, retention = %s", r.timeout, r.retention)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.Reap(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reap executes a single pass of the reaper
+func (r *Reaper) Reap(ctx context.Context) {
+	now := time.Now()
+
+	// Set devices without heartbeat offline
+	if r.timeout > 0 {
+		if devices, err := r.store.ExpireOnline(ctx, uint64(now.Add(-r.timeout).Unix())); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("failed to expire online devices")
+		} else if len(devices) > 0 {
+			log.Infof("%d device(s) set offline after heartbeat timeout", len(devices))
+		}
+	}
+
+	// Remove devices offline since too long
+	if r.retention > 0 {
+		if devices, err := r.store.PurgeOffline(ctx, uint64(now.Add(-r.retention).Unix())); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("failed to purge offline devices")
+		} else if len(devices) > 0 {
+			log.Infof("%d offline device(s) removed after retention period", len(devices))
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package device
+
+import (
+	"context"
+	"time"
+)
+
+// Network
+type Network struct {
+	Ip      string `json:"ip" example:"203.0.113.10" doc:"The public IP address of the network (the prefix address for IPv6)"`
+	Devices uint   `json:"devices" example:"3" doc:"The number of devices registered on the network"`
+	Online  uint   `json:"online" example:"2" doc:"The number of online devices on the network"`
+}
+
+// Device with its network
+type NetworkDevice struct {
+	Ip     string `json:"ip" example:"203.0.113.10" doc:"The public IP address of the network (the prefix address for IPv6)"`
+	Device Device `json:"device" doc:"The device"`
+}
+
+// Device of a network identified by its serial number
+type NetworkSerial struct {
+	Ip     string
+	Serial string
+}
+
+// DeviceStore is the storage backend of the device registry.
+//
+// All operations are scoped to the network of the client (see middleware.IpConfig.NetworkFromIp): its public
+// IPv4 address or its IPv6 prefix, which identifies the local network of the devices. The errors are
+// ErrDeviceNotFound, ErrInterfaceNotFound, utils.ErrUnavailable, a *utils.ValidationError,
+// utils.ValidationErrors (all invalid values), a context error or another database error.
+type DeviceStore interface {
+	// List the devices of the network (the next cursor is set when more devices are available)
+	List(ctx context.Context, ip string, opts ListOptions) ([]Device, *ListCursor, error)
+	// Get a device of the network
+	Get(ctx context.Context, ip string, serial string) (Device, error)
+	// Add or reset a device, and replace its interfaces when set (all or nothing is changed)
+	Add(ctx context.Context, ip string, dev Device) error
+	// Remove a device and all its interfaces
+	Remove(ctx context.Context, ip string, serial string) error
+	// Update the online status and the timestamp of a device
+	UpdateStatus(ctx context.Context, ip string, serial string, online bool) error
+	// Add or update a network interface of a device
+	AddAddress(ctx context.Context, ip string, serial string, iface DeviceInterface, update bool) error
+	// Remove a network interface of a device
+	RemoveAddress(ctx context.Context, ip string, serial string, hw_address string, update bool) error
+	// Remove all network interfaces of a device
+	RemoveAddresses(ctx context.Context, ip string, serial string, update bool) error
+
+	// Run the changes of a device atomically with the checks of its current state: the callback gets
+	// the store bound to a transaction locking the device (rolled back on error), or holding the lock
+	// of the memory store.
+	Change(ctx context.Context, ip string, serial string, fn func(tx DeviceStore) error) error
+
+	// Get the token hash of a device (empty when the device or its token is not found)
+	GetTokenHash(ctx context.Context, ip string, serial string) (string, error)
+	// Set the token hash of a device without token (false when the device has a token)
+	SetTokenHash(ctx context.Context, ip string, serial string, hash string) (bool, error)
+
+	// List all networks with their device counts
+	ListNetworks(ctx context.Context) ([]Network, error)
+	// Search the devices of all networks (or of a network when set) by serial or name
+	Search(ctx context.Context, ip string, query string) ([]NetworkDevice, error)
+
+	// Get the network followed by the networks merged with it (see ListOptions.MergeWindow)
+	LinkedNetworks(ctx context.Context, ip string, window time.Duration) ([]string, error)
+
+	// Set offline the online devices of all networks not updated since the timestamp, and return them
+	ExpireOnline(ctx context.Context, before uint64) ([]NetworkSerial, error)
+	// Remove the offline devices of all networks not updated since the timestamp, and return them
+	PurgeOffline(ctx context.Context, before uint64) ([]NetworkSerial, error)
+}
@@ -0,0 +1,37 @@
+package device
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// Open a SQLite database with the device tables in a temporary directory
+func openTestDatabase(tb testing.TB) *sql.DB {
+	dsn := "file:" + filepath.Join(tb.TempDir(), "test.db") + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		tb.Fatalf("failed to open database: %s", err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	// Create tables
+	if err := utils.InitializeVersionTable(db, utils.SQLite); err != nil {
+		tb.Fatalf("failed to create version table: %s", err)
+	}
+	if !InitializeTables(db, utils.SQLite) {
+		tb.Fatal("failed to create device tables")
+	}
+	return db
+}
+
+// Stores under test: each test runs against the in-memory store and the SQLite store
+var testStores = []struct {
+	name string
+	open func(tb testing.TB) DeviceStore
+}{
+	{"memory", func(tb testing.TB) DeviceStore { return NewMemoryStore() }},
+	{"sqlite", func(tb testing.TB) DeviceStore { return NewSQLStore(openTestDatabase(tb), utils.SQLite) }},
+}
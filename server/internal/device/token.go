@@ -0,0 +1,103 @@
+package device
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// Name of the device token security scheme in OpenAPI
+const tokenSecurityScheme = "deviceToken"
+
+// Security requirement of the operations modifying a device
+var tokenSecurity = []map[string][]string{{tokenSecurityScheme: {}}}
+
+func registerTokenSecurityScheme(api huma.API) {
+	components := api.OpenAPI().Components
+	if components.SecuritySchemes == nil {
+		components.SecuritySchemes = map[string]*huma.SecurityScheme{}
+	}
+	components.SecuritySchemes[tokenSecurityScheme] = &huma.SecurityScheme{
+		Type:        "http",
+		Scheme:      "bearer",
+		Description: "The secret token returned on the first registration of the device.",
+	}
+}
+
+func generateToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func bearerToken(authorization string) string {
+	scheme, token, found := strings.Cut(authorization, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// CheckToken verifies the token matches the device one: when the device has no token (legacy device
+// or not yet registered), no token is required.
+//
+// This is a trust on first use scheme: a device row without token (registered by a legacy client or
+// before the tokens were introduced) can be claimed by the first client of the same network calling
+// PUT /device/add with its serial number, which receives the token. The legitimate device is then
+// rejected until an administrator removes the claimed device (DELETE /admin/device/{ip}/{serial}).
+//
+// Before a change, it must be called with the store given by DeviceStore.Change (see changeDevice),
+// so the token cannot be set between the check and the change.
+func CheckToken(ctx context.Context, store DeviceStore, ip string, serial string, token string) error {
+	// Get token hash
+	hash, err := store.GetTokenHash(ctx, ip, serial)
+	if err != nil {
+		return utils.HttpError(err, "")
+	} else if hash == "" {
+		return nil
+	}
+
+	// Compare hashes
+	if token == "" || subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(hash)) != 1 {
+		return huma.Error401Unauthorized("invalid device token")
+	}
+
+	return nil
+}
+
+// issueToken generates a new token for a device without token (empty if the device has a token).
+//
+// A device registered on another network sends its token, which is kept for this network instead:
+// the networks of a same device are linked by its token (see ListOptions.MergeWindow), so it is
+// never returned. It must be called with the store given by DeviceStore.Change, in the transaction
+// registering the device.
+func issueToken(ctx context.Context, store DeviceStore, ip string, serial string, sent string) (string, error) {
+	if sent != "" {
+		_, err := store.SetTokenHash(ctx, ip, serial, hashToken(sent))
+		return "", err
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	if ok, err := store.SetTokenHash(ctx, ip, serial, hashToken(token)); err != nil || !ok {
+		return "", err
+	}
+	return token, nil
+}
@@ -0,0 +1,157 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/conditional"
+)
+
+func TestCheckToken(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.open(t)
+			ctx := context.Background()
+
+			// Device without token (legacy) and device with token
+			for _, serial := range []string{"legacy", "registered"} {
+				if err := s.Add(ctx, "1.2.3.4", Device{Serial: serial, Name: serial}); err != nil {
+					t.Fatalf("failed to add device: %s", err)
+				}
+			}
+			token, err := issueToken(ctx, s, "1.2.3.4", "registered", "")
+			if err != nil || token == "" {
+				t.Fatalf("failed to issue token: %q, %v", token, err)
+			}
+
+			for _, test := range []struct {
+				name   string
+				ip     string
+				serial string
+				token  string
+				status int
+			}{
+				{"unknown device", "1.2.3.4", "unknown", "", 0},
+				{"unknown device with token", "1.2.3.4", "unknown", token, 0},
+				{"legacy device", "1.2.3.4", "legacy", "", 0},
+				{"legacy device with token", "1.2.3.4", "legacy", "token", 0},
+				{"valid token", "1.2.3.4", "registered", token, 0},
+				{"missing token", "1.2.3.4", "registered", "", http.StatusUnauthorized},
+				{"invalid token", "1.2.3.4", "registered", token + "x", http.StatusUnauthorized},
+				{"token hash", "1.2.3.4", "registered", hashToken(token), http.StatusUnauthorized},
+				{"other network", "5.6.7.8", "registered", "", 0},
+			} {
+				t.Run(test.name, func(t *testing.T) {
+					err := CheckToken(ctx, s, test.ip, test.serial, test.token)
+					var status huma.StatusError
+					if test.status == 0 && err != nil {
+						t.Fatalf("got %v, want no error", err)
+					} else if test.status != 0 && (!errors.As(err, &status) || status.GetStatus() != test.status) {
+						t.Fatalf("got %v, want status %d", err, test.status)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestIssueToken(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.open(t)
+			ctx := context.Background()
+			for _, ip := range []string{"1.2.3.4", "2001:db8::"} {
+				if err := s.Add(ctx, ip, Device{Serial: "serial", Name: "Device"}); err != nil {
+					t.Fatalf("failed to add device: %s", err)
+				}
+			}
+
+			// First registration returns a token, then the device has a token
+			token, err := issueToken(ctx, s, "1.2.3.4", "serial", "")
+			if err != nil || token == "" {
+				t.Fatalf("first issue: got %q, %v, want a token", token, err)
+			}
+			if again, err := issueToken(ctx, s, "1.2.3.4", "serial", ""); err != nil || again != "" {
+				t.Fatalf("second issue: got %q, %v, want no token", again, err)
+			}
+
+			// Token sent on another network is kept, so the networks are linked
+			if sent, err := issueToken(ctx, s, "2001:db8::", "serial", token); err != nil || sent != "" {
+				t.Fatalf("issue with sent token: got %q, %v, want no token", sent, err)
+			}
+			if err := CheckToken(ctx, s, "2001:db8::", "serial", token); err != nil {
+				t.Fatalf("sent token is not valid: %s", err)
+			}
+		})
+	}
+}
+
+func TestChangeDevice(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.open(t)
+			ctx := context.Background()
+
+			// Concurrent first registrations: a single one receives the token, the others are rejected
+			tokens := make([]string, 8)
+			errs := make([]error, len(tokens))
+			var wg sync.WaitGroup
+			for i := range tokens {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs[i] = changeDevice(ctx, s, "1.2.3.4", "serial", "", &conditional.Params{}, func(tx DeviceStore) error {
+						if err := tx.Add(ctx, "1.2.3.4", Device{Serial: "serial", Name: "Device"}); err != nil {
+							return err
+						}
+						token, err := issueToken(ctx, tx, "1.2.3.4", "serial", "")
+						tokens[i] = token
+						return err
+					})
+				}()
+			}
+			wg.Wait()
+			token := ""
+			for i, issued := range tokens {
+				var status huma.StatusError
+				if errs[i] == nil && issued != "" && token == "" {
+					token = issued
+				} else if !errors.As(errs[i], &status) || status.GetStatus() != http.StatusUnauthorized {
+					t.Fatalf("registration %d: got %q, %v, want status %d", i, issued, errs[i], http.StatusUnauthorized)
+				}
+			}
+			if token == "" {
+				t.Fatalf("got no token, want one")
+			}
+
+			// Changes are applied only with the device token
+			for _, test := range []struct {
+				name          string
+				authorization string
+				status        int
+			}{
+				{"missing token", "", http.StatusUnauthorized},
+				{"invalid token", "Bearer " + token + "x", http.StatusUnauthorized},
+				{"valid token", "Bearer " + token, 0},
+			} {
+				t.Run(test.name, func(t *testing.T) {
+					called := false
+					err := changeDevice(ctx, s, "1.2.3.4", "serial", test.authorization, &conditional.Params{}, func(tx DeviceStore) error {
+						called = true
+						return nil
+					})
+					var status huma.StatusError
+					if test.status == 0 && (err != nil || !called) {
+						t.Fatalf("got %v and called %t, want changes applied", err, called)
+					} else if test.status != 0 && (called || !errors.As(err, &status) || status.GetStatus() != test.status) {
+						t.Fatalf("got %v and called %t, want status %d", err, called, test.status)
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package device
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+
+	"github.com/dillya/melo-webapi/internal/utils/middleware"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WebSocket client request
+type WebSocketRequest struct {
+	Type    string   `json:"type" example:"subscribe" enum:"subscribe,heartbeat" doc:"The request type"`
+	Serials []string `json:"serials,omitempty" doc:"Serial Numbers of the devices to receive events from when type is 'subscribe' (all when empty)"`
+	Events  []string `json:"events,omitempty" doc:"Event types to receive when type is 'subscribe' (all when empty)"`
+	Serial  string   `json:"serial,omitempty" example:"01:23:45:67:89:ab" doc:"Serial Number of the device to set online when type is 'heartbeat'"`
+	Token   string   `json:"token,omitempty" doc:"The secret token of the device when type is 'heartbeat'"`
+}
+
+const (
+	// Maximum size of a client request
+	wsMaxRequestSize = 4096
+	// Time allowed to write a message to the client
+	wsWriteTimeout = 10 * time.Second
+	// Time allowed to read the next client message or pong
+	wsReadTimeout = 60 * time.Second
+	// Period of the pings sent to the client (must be less than read timeout)
+	wsPingPeriod = 30 * time.Second
+)
+
+// The WebSocket is used by Melo UIs served from any origin (as with CORS policy)
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// Event filter set with a 'subscribe' request
+type eventFilter struct {
+	serials []string
+	events  []string
+}
+
+func (f eventFilter) match(event DeviceEvent) bool {
+	return (len(f.serials) == 0 || slices.Contains(f.serials, event.Serial)) &&
+		(len(f.events) == 0 || slices.Contains(f.events, event.Type))
+}
+
+func RegisterWebSocket(api huma.API, router chi.Router, store DeviceStore, broker *Broker, client_ip middleware.IpConfig) {
+	// Document the handler in OpenAPI (WebSocket is not supported by Huma)
+	registry := api.OpenAPI().Components.Schemas
+	api.OpenAPI().AddOperation(&huma.Operation{
+		OperationID: "deviceWebSocket",
+		Method:      http.MethodGet,
+		Path:        "/device/ws",
+		Summary:     "Device events WebSocket",
+		Description: "Open a WebSocket to receive the changes of the devices registered on the local network. " +
+			"The client sends 'subscribe' requests to filter the events by device or by type, and 'heartbeat' requests " +
+			"to set a device online and update timestamp.",
+		Tags: []string{"Device"},
+		RequestBody: &huma.RequestBody{
+			Description: "The client requests sent as WebSocket text messages.",
+			Content: map[string]*huma.MediaType{
+				"application/json": {Schema: registry.Schema(reflect.TypeOf(WebSocketRequest{}), true, "")},
+			},
+		},
+		Responses: map[string]*huma.Response{
+			"101": {
+				Description: "Switching to WebSocket: the device events are sent as WebSocket text messages.",
+				Content: map[string]*huma.MediaType{
+					"application/json": {Schema: registry.Schema(reflect.TypeOf(DeviceEvent{}), true, "")},
+				},
+			},
+		},
+	})
+
+	// Register GET /device/ws handler
+	router.With(middleware.GetHttpIpExtractor(client_ip)).Get("/device/ws", func(w http.ResponseWriter, r *http.Request) {
+		serveWebSocket(w, r, store, broker)
+	})
+}
+
+func serveWebSocket(w http.ResponseWriter, r *http.Request, store DeviceStore, broker *Broker) {
+	ip := middleware.ExtractNetwork(r.Context())
+
+	// Upgrade connection (an error response is sent on failure)
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Debug("failed to upgrade WebSocket")
+		return
+	}
+	defer conn.Close()
+
+	// Subscribe to network events
+	events := broker.Subscribe(ip)
+	defer broker.Unsubscribe(ip, events)
+
+	// Handle client requests
+	filters := make(chan eventFilter)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		readWebSocket(conn, func(req WebSocketRequest) bool {
+			switch req.Type {
+			case "subscribe":
+				select {
+				case filters <- eventFilter{serials: req.Serials, events: req.Events}:
+				case <-r.Context().Done():
+				}
+			case "heartbeat":
+				if req.Serial == "" || CheckToken(r.Context(), store, ip, req.Serial, req.Token) != nil {
+					return false
+				}
+				return store.UpdateStatus(r.Context(), ip, req.Serial, true) == nil
+			default:
+				return false
+			}
+			return true
+		})
+	}()
+
+	// Forward events until client disconnection
+	filter := eventFilter{}
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case filter = <-filters:
+		case event, ok := <-events:
+			if !ok {
+				// Server is shutting down
+				reason := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown")
+				conn.WriteControl(websocket.CloseMessage, reason, time.Now().Add(wsWriteTimeout))
+				return
+			}
+			if !filter.match(event) {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func readWebSocket(conn *websocket.Conn, handle func(req WebSocketRequest) bool) {
+	// Close connection when client is not responding
+	conn.SetReadLimit(wsMaxRequestSize)
+	conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	})
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+
+		// Parse and handle request
+		var req WebSocketRequest
+		if err := json.Unmarshal(message, &req); err != nil || !handle(req) {
+			reason := websocket.FormatCloseMessage(websocket.CloseUnsupportedData, "invalid request")
+			conn.WriteControl(websocket.CloseMessage, reason, time.Now().Add(wsWriteTimeout))
+			return
+		}
+	}
+}
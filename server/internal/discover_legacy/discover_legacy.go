@@ -2,12 +2,13 @@ package discover_legacy
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
 
 	"github.com/dillya/melo-webapi/internal/device"
+	"github.com/dillya/melo-webapi/internal/utils"
 	"github.com/dillya/melo-webapi/internal/utils/middleware"
 
 	"github.com/danielgtaylor/huma/v2"
@@ -40,6 +41,20 @@ func createQueryError(query string, value any) error {
 	})
 }
 
+func convertStoreError(err error) error {
+	// Use legacy query names in validation errors
+	var verr *utils.ValidationError
+	if errors.As(err, &verr) {
+		switch verr.Location {
+		case "mac":
+			verr.Location = "hw_address"
+		case "ipv4":
+			verr.Location = "address"
+		}
+	}
+	return utils.HttpError(err, "query.")
+}
+
 func convertInterface(ifaces []device.DeviceInterface) []legacyDeviceInterface {
 	// Convert interface list
 	list := []legacyDeviceInterface{}
@@ -56,9 +71,12 @@ func convertInterface(ifaces []device.DeviceInterface) []legacyDeviceInterface {
 	return list
 }
 
-func listDevice(ctx context.Context, db *sql.DB, ip string) []legacyDevice {
+func listDevice(ctx context.Context, store device.DeviceStore, ip string) ([]legacyDevice, error) {
 	// List devices
-	devices := device.List(ctx, db, ip)
+	devices, _, err := store.List(ctx, ip, device.ListOptions{})
+	if err != nil {
+		return nil, convertStoreError(err)
+	}
 
 	// Convert list to legacy one
 	list := []legacyDevice{}
@@ -72,10 +90,20 @@ func listDevice(ctx context.Context, db *sql.DB, ip string) []legacyDevice {
 		})
 	}
 
-	return list
+	return list, nil
+}
+
+// Apply a change to a device: devices registered with a token cannot be modified with legacy API
+func changeDevice(ctx context.Context, store device.DeviceStore, ip string, serial string, fn func(tx device.DeviceStore) error) error {
+	return store.Change(ctx, ip, serial, func(tx device.DeviceStore) error {
+		if err := device.CheckToken(ctx, tx, ip, serial, ""); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
 }
 
-func Register(api huma.API, db *sql.DB) {
+func Register(api huma.API, store device.DeviceStore, client_ip middleware.IpConfig) {
 	// Register responses to the API (same handler is shared for many kind of responses)
 	registry := api.OpenAPI().Components.Schemas
 	schema := &huma.Schema{
@@ -92,7 +120,7 @@ func Register(api huma.API, db *sql.DB) {
 		Summary:     "[Deprecated] Discover device API",
 		Description: "List, add and remove devices and their interfaces.",
 		Deprecated:  true,
-		Middlewares: huma.Middlewares{middleware.GetIpExtractor()},
+		Middlewares: huma.Middlewares{middleware.GetIpExtractor(client_ip)},
 		Responses: map[string]*huma.Response{
 			"200": {
 				Content: map[string]*huma.MediaType{
@@ -117,12 +145,12 @@ func Register(api huma.API, db *sql.DB) {
 		var err error = nil
 
 		// Get IP address of the remote
-		ip := middleware.ExtractIp(ctx)
+		ip := middleware.ExtractNetwork(ctx)
 
 		// Parse the action
 		switch input.Action {
 		case "list":
-			resp.Body = listDevice(ctx, db, ip)
+			resp.Body, err = listDevice(ctx, store, ip)
 		case "add_device":
 			// Check required query
 			if input.Serial == "" {
@@ -131,8 +159,10 @@ func Register(api huma.API, db *sql.DB) {
 				err = createQueryError("name", input.Name)
 			} else if input.HttpPort == 0 {
 				err = createQueryError("port", input.HttpPort)
-			} else if !device.Add(ctx, db, ip, device.Device{Serial: input.Serial, Name: input.Name, HttpPort: input.HttpPort}) {
-				err = huma.Error500InternalServerError("failed to add device")
+			} else if err = changeDevice(ctx, store, ip, input.Serial, func(tx device.DeviceStore) error {
+				return tx.Add(ctx, ip, device.Device{Serial: input.Serial, Name: input.Name, HttpPort: input.HttpPort})
+			}); err != nil {
+				err = convertStoreError(err)
 			} else {
 				resp.Body = struct{}{}
 			}
@@ -140,8 +170,10 @@ func Register(api huma.API, db *sql.DB) {
 			// Check required query
 			if input.Serial == "" {
 				err = createQueryError("serial", input.Serial)
-			} else if !device.Remove(ctx, db, ip, input.Serial) {
-				err = huma.Error404NotFound("device not found")
+			} else if err = changeDevice(ctx, store, ip, input.Serial, func(tx device.DeviceStore) error {
+				return tx.Remove(ctx, ip, input.Serial)
+			}); err != nil {
+				err = convertStoreError(err)
 			} else {
 				resp.Body = struct{}{}
 			}
@@ -153,8 +185,10 @@ func Register(api huma.API, db *sql.DB) {
 				err = createQueryError("hw_address", input.HwAddress)
 			} else if input.Address == "" {
 				err = createQueryError("address", input.Address)
-			} else if !device.AddAddress(ctx, db, ip, input.Serial, device.DeviceInterface{MacAddress: input.HwAddress, Ipv4Address: input.Address}, true) {
-				err = huma.Error500InternalServerError("failed to add address")
+			} else if err = changeDevice(ctx, store, ip, input.Serial, func(tx device.DeviceStore) error {
+				return tx.AddAddress(ctx, ip, input.Serial, device.DeviceInterface{MacAddress: input.HwAddress, Ipv4Address: input.Address}, true)
+			}); err != nil {
+				err = convertStoreError(err)
 			} else {
 				resp.Body = struct{}{}
 			}
@@ -164,8 +198,10 @@ func Register(api huma.API, db *sql.DB) {
 				err = createQueryError("serial", input.Serial)
 			} else if input.HwAddress == "" {
 				err = createQueryError("hw_address", input.HwAddress)
-			} else if !device.RemoveAddress(ctx, db, ip, input.Serial, input.HwAddress, true) {
-				err = huma.Error404NotFound("address not found")
+			} else if err = changeDevice(ctx, store, ip, input.Serial, func(tx device.DeviceStore) error {
+				return tx.RemoveAddress(ctx, ip, input.Serial, input.HwAddress, true)
+			}); err != nil {
+				err = convertStoreError(err)
 			} else {
 				resp.Body = struct{}{}
 			}
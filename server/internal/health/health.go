@@ -0,0 +1,68 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// Check is a readiness check: the server is not ready when an error is returned
+type Check func(ctx context.Context) error
+
+// Health status
+type statusOutput struct {
+	Body struct {
+		Status string `json:"status" example:"ok" doc:"The status of the server"`
+	}
+}
+
+func Register(api huma.API, checks map[string]Check) {
+	// Sort checks for stable errors
+	names := []string{}
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Register GET /healthz handler
+	huma.Register(api, huma.Operation{
+		OperationID: "getHealth",
+		Method:      http.MethodGet,
+		Path:        "/healthz",
+		Summary:     "Liveness probe",
+		Description: "Check the server process is alive.",
+		Tags:        []string{"Health"},
+	}, func(ctx context.Context, input *struct{}) (*statusOutput, error) {
+		resp := &statusOutput{}
+		resp.Body.Status = "ok"
+		return resp, nil
+	})
+
+	// Register GET /readyz handler
+	huma.Register(api, huma.Operation{
+		OperationID: "getReadiness",
+		Method:      http.MethodGet,
+		Path:        "/readyz",
+		Summary:     "Readiness probe",
+		Description: "Check the server is ready to handle requests: the database is reachable and up to date, and the server is not shutting down.",
+		Tags:        []string{"Health"},
+		Errors:      []int{http.StatusServiceUnavailable},
+	}, func(ctx context.Context, input *struct{}) (*statusOutput, error) {
+		// Run all checks
+		details := []error{}
+		for _, name := range names {
+			if err := checks[name](ctx); err != nil {
+				details = append(details, &huma.ErrorDetail{Message: err.Error(), Location: name})
+			}
+		}
+		if len(details) > 0 {
+			return nil, huma.Error503ServiceUnavailable("not ready", details...)
+		}
+
+		resp := &statusOutput{}
+		resp.Body.Status = "ready"
+		return resp, nil
+	})
+}
@@ -0,0 +1,71 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/humatest"
+)
+
+func TestLiveness(t *testing.T) {
+	// Liveness does not run the readiness checks
+	_, api := humatest.New(t)
+	Register(api, map[string]Check{"database": func(ctx context.Context) error { return errors.New("unreachable") }})
+	if resp := api.Get("/healthz"); resp.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", resp.Code, http.StatusOK)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	// Create a check failing with an error (successful when empty)
+	check := func(message string) Check {
+		return func(ctx context.Context) error {
+			if message == "" {
+				return nil
+			}
+			return errors.New(message)
+		}
+	}
+
+	for _, test := range []struct {
+		name    string
+		checks  map[string]Check
+		status  int
+		details []huma.ErrorDetail
+	}{
+		{"no check", nil, http.StatusOK, nil},
+		{"ready", map[string]Check{"server": check(""), "database": check("")}, http.StatusOK, nil},
+		{"shutting down", map[string]Check{"server": check("server is shutting down"), "database": check("")}, http.StatusServiceUnavailable, []huma.ErrorDetail{
+			{Message: "server is shutting down", Location: "server"},
+		}},
+		{"sorted failures", map[string]Check{"server": check("server is shutting down"), "database": check("unreachable"), "artifacts": check("")}, http.StatusServiceUnavailable, []huma.ErrorDetail{
+			{Message: "unreachable", Location: "database"},
+			{Message: "server is shutting down", Location: "server"},
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, api := humatest.New(t)
+			Register(api, test.checks)
+			resp := api.Get("/readyz")
+			if resp.Code != test.status {
+				t.Fatalf("got status %d, want %d", resp.Code, test.status)
+			} else if test.status != http.StatusOK {
+				var model huma.ErrorModel
+				if err := json.Unmarshal(resp.Body.Bytes(), &model); err != nil {
+					t.Fatalf("invalid error: %s", err)
+				} else if len(model.Errors) != len(test.details) {
+					t.Fatalf("got %d errors, want %d", len(model.Errors), len(test.details))
+				}
+				for i, detail := range model.Errors {
+					if detail.Message != test.details[i].Message || detail.Location != test.details[i].Location {
+						t.Fatalf("got error %+v, want %+v", detail, test.details[i])
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// SQL plugin store
+type sqlStore struct {
+	db      *sql.DB
+	queries sqlQueries
+}
+
+// Dialect specific queries
+type sqlQueries struct {
+	list_plugins        string
+	get_plugin          string
+	get_plugin_id       string
+	add_plugin          string
+	remove_plugin       string
+	touch_plugin        string
+	list_versions       string
+	add_version         string
+	remove_version      string
+	compatible_versions string
+}
+
+// Plugin and version columns to use with pluginDest / versionDest
+const pluginColumns = "plugin.name, plugin.title, plugin.description, plugin.author, plugin.homepage, plugin.last_update"
+const versionColumns = "plugin_version.version, plugin_version.url, plugin_version.size, plugin_version.sha256, plugin_version.notes, " +
+	"plugin_version.min_melo, plugin_version.max_melo, plugin_version.published, plugin_version.signature, plugin_version.signature_key"
+
+func newSqlQueries(d utils.Dialect) sqlQueries {
+	return sqlQueries{
+		list_plugins: d.Rebind("SELECT " + pluginColumns + " FROM plugin" +
+			" WHERE LOWER(name) LIKE ? ESCAPE '!' OR LOWER(title) LIKE ? ESCAPE '!' OR LOWER(description) LIKE ? ESCAPE '!' ORDER BY name"),
+		get_plugin:    d.Rebind("SELECT plugin.id, " + pluginColumns + " FROM plugin WHERE name=?"),
+		get_plugin_id: d.Rebind("SELECT id FROM plugin WHERE name=?"),
+		add_plugin: d.Rebind(`INSERT INTO plugin
+(name, title, description, author, homepage, last_update)
+VALUES (?, ?, ?, ?, ?, ?)
+` + d.Upsert([]string{"name"}, "title", "description", "author", "homepage", "last_update")),
+		remove_plugin: d.Rebind("DELETE FROM plugin WHERE name=?"),
+		touch_plugin:  d.Rebind("UPDATE plugin SET last_update=? WHERE id=?"),
+		list_versions: d.Rebind("SELECT " + versionColumns + " FROM plugin_version WHERE plugin_id=?"),
+		add_version: d.Rebind(`INSERT INTO plugin_version
+(plugin_id, version, url, size, sha256, notes, min_melo, max_melo, min_melo_key, max_melo_key, published, signature, signature_key)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+` + d.Upsert([]string{"plugin_id", "version"}, "url", "size", "sha256", "notes", "min_melo", "max_melo", "min_melo_key", "max_melo_key", "signature", "signature_key")),
+		remove_version: d.Rebind("DELETE FROM plugin_version WHERE plugin_id=? AND version=?"),
+		compatible_versions: d.Rebind("SELECT " + pluginColumns + ", " + versionColumns + " FROM plugin" +
+			" JOIN plugin_version ON plugin_version.plugin_id=plugin.id" +
+			" WHERE plugin_version.min_melo_key <= ? AND (plugin_version.max_melo_key = '' OR plugin_version.max_melo_key >= ?)" +
+			" ORDER BY plugin.name"),
+	}
+}
+
+// NewSQLStore creates a plugin store backed by a SQL database (MySQL / MariaDB, SQLite or PostgreSQL)
+func NewSQLStore(db *sql.DB, dialect utils.Dialect) PluginStore {
+	return &sqlStore{db: db, queries: newSqlQueries(dialect)}
+}
+
+// Scan destinations of the pluginColumns
+func pluginDest(plugin *Plugin) []any {
+	return []any{&plugin.Name, &plugin.Title, &plugin.Description, &plugin.Author, &plugin.Homepage, &plugin.LastUpdate}
+}
+
+// Scan destinations of the versionColumns (the nullable notes are scanned separately)
+func versionDest(version *PluginVersion, notes *[]byte) []any {
+	return []any{&version.Version, &version.Url, &version.Size, &version.Sha256, notes, &version.MinMelo, &version.MaxMelo, &version.Date, &version.Signature, &version.SignatureKey}
+}
+
+// Get the identifier of a plugin
+func (s *sqlStore) getPluginId(ctx context.Context, tx *sql.Tx, name string) (uint, error) {
+	var id uint
+	err := tx.QueryRowContext(ctx, s.queries.get_plugin_id, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrPluginNotFound
+	} else if err != nil {
+		return 0, utils.DbError(ctx, s.db, err)
+	}
+	return id, nil
+}
+
+func (s *sqlStore) List(ctx context.Context, query string) ([]Plugin, error) {
+	// Fetch matching plugins
+	pattern := "%" + utils.EscapeLike(strings.ToLower(query)) + "%"
+	rows, err := s.db.QueryContext(ctx, s.queries.list_plugins, pattern, pattern, pattern)
+	if err != nil {
+		return nil, utils.DbError(ctx, s.db, err)
+	}
+	defer rows.Close()
+
+	list := []Plugin{}
+	for rows.Next() {
+		plugin := Plugin{}
+		if err := rows.Scan(pluginDest(&plugin)...); err != nil {
+			return nil, err
+		}
+		list = append(list, plugin)
+	}
+
+	return list, rows.Err()
+}
+
+func (s *sqlStore) Get(ctx context.Context, name string) (Plugin, error) {
+	// Fetch plugin
+	var id uint
+	plugin := Plugin{}
+	err := s.db.QueryRowContext(ctx, s.queries.get_plugin, name).Scan(append([]any{&id}, pluginDest(&plugin)...)...)
+	if err == sql.ErrNoRows {
+		return plugin, ErrPluginNotFound
+	} else if err != nil {
+		return plugin, utils.DbError(ctx, s.db, err)
+	}
+
+	// Fetch its versions
+	rows, err := s.db.QueryContext(ctx, s.queries.list_versions, id)
+	if err != nil {
+		return plugin, utils.DbError(ctx, s.db, err)
+	}
+	defer rows.Close()
+
+	plugin.Versions = []PluginVersion{}
+	for rows.Next() {
+		var notes []byte
+		version := PluginVersion{}
+		if err := rows.Scan(versionDest(&version, &notes)...); err != nil {
+			return plugin, err
+		}
+		version.Notes = string(notes)
+		plugin.Versions = append(plugin.Versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return plugin, err
+	}
+	sortVersions(plugin.Versions)
+
+	return plugin, nil
+}
+
+func (s *sqlStore) Add(ctx context.Context, plugin Plugin) error {
+	// Check values
+	if err := validatePlugin(plugin); err != nil {
+		return err
+	}
+
+	// Add or update plugin
+	_, err := s.db.ExecContext(ctx, s.queries.add_plugin,
+		plugin.Name,
+		plugin.Title,
+		plugin.Description,
+		plugin.Author,
+		plugin.Homepage,
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return utils.DbError(ctx, s.db, err)
+	}
+	return nil
+}
+
+func (s *sqlStore) Remove(ctx context.Context, name string) error {
+	// Remove plugin (versions will be removed automatically)
+	result, err := s.db.ExecContext(ctx, s.queries.remove_plugin, name)
+	if err != nil {
+		return utils.DbError(ctx, s.db, err)
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrPluginNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) AddVersion(ctx context.Context, name string, version PluginVersion) error {
+	// Check values
+	if err := validateVersion(version); err != nil {
+		return err
+	}
+
+	// Add version and update plugin together
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return utils.DbError(ctx, s.db, err)
+	}
+	defer tx.Rollback()
+
+	id, err := s.getPluginId(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	if _, err := tx.ExecContext(ctx, s.queries.add_version,
+		id,
+		version.Version,
+		version.Url,
+		version.Size,
+		version.Sha256,
+		version.Notes,
+		version.MinMelo,
+		version.MaxMelo,
+		meloKey(version.MinMelo),
+		meloKey(version.MaxMelo),
+		now,
+		version.Signature,
+		version.SignatureKey,
+	); err != nil {
+		return utils.DbError(ctx, s.db, err)
+	}
+	if _, err := tx.ExecContext(ctx, s.queries.touch_plugin, now, id); err != nil {
+		return utils.DbError(ctx, s.db, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return utils.DbError(ctx, s.db, err)
+	}
+	return nil
+}
+
+func (s *sqlStore) RemoveVersion(ctx context.Context, name string, version string) error {
+	// Remove version and update plugin together
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return utils.DbError(ctx, s.db, err)
+	}
+	defer tx.Rollback()
+
+	id, err := s.getPluginId(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, s.queries.remove_version, id, version)
+	if err != nil {
+		return utils.DbError(ctx, s.db, err)
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrVersionNotFound
+	}
+	if _, err := tx.ExecContext(ctx, s.queries.touch_plugin, time.Now().Unix(), id); err != nil {
+		return utils.DbError(ctx, s.db, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return utils.DbError(ctx, s.db, err)
+	}
+	return nil
+}
+
+func (s *sqlStore) Compatible(ctx context.Context, melo utils.Version) ([]CompatiblePlugin, error) {
+	// Fetch the versions compatible with the version core (see meloKey): the maximum version is
+	// excluded, but a pre-release of the maximum version core is compatible, so it is checked below
+	key := meloKey(melo.String())
+	rows, err := s.db.QueryContext(ctx, s.queries.compatible_versions, key, key)
+	if err != nil {
+		return nil, utils.DbError(ctx, s.db, err)
+	}
+	defer rows.Close()
+
+	// Group versions by plugin (sorted by name)
+	plugins := []Plugin{}
+	for rows.Next() {
+		var notes []byte
+		plugin, version := Plugin{}, PluginVersion{}
+		if err := rows.Scan(append(pluginDest(&plugin), versionDest(&version, &notes)...)...); err != nil {
+			return nil, err
+		}
+		version.Notes = string(notes)
+		if len(plugins) == 0 || plugins[len(plugins)-1].Name != plugin.Name {
+			plugins = append(plugins, plugin)
+		}
+		last := &plugins[len(plugins)-1]
+		last.Versions = append(last.Versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Find newest compatible version of each plugin
+	list := []CompatiblePlugin{}
+	for _, plugin := range plugins {
+		sortVersions(plugin.Versions)
+		if version, found := newestCompatible(plugin.Versions, melo); found {
+			list = append(list, CompatiblePlugin{Name: plugin.Name, Title: plugin.Title, Version: version})
+		}
+	}
+
+	return list, nil
+}
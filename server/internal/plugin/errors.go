@@ -0,0 +1,36 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// Errors returned by the plugin stores (see also utils.ErrUnavailable)
+var (
+	ErrPluginNotFound  = fmt.Errorf("plugin %w", utils.ErrNotFound)
+	ErrVersionNotFound = fmt.Errorf("plugin version %w", utils.ErrNotFound)
+)
+
+// validateVersion checks the versions and the compatibility range
+func validateVersion(version PluginVersion) error {
+	if _, err := utils.ParseVersion(version.Version); err != nil {
+		return &utils.ValidationError{Location: "version", Value: version.Version, Message: "invalid semantic version"}
+	}
+	min, err := utils.ParseVersion(version.MinMelo)
+	if err != nil {
+		return &utils.ValidationError{Location: "min_melo", Value: version.MinMelo, Message: "invalid semantic version"}
+	}
+	if version.MaxMelo != "" {
+		max, err := utils.ParseVersion(version.MaxMelo)
+		if err != nil {
+			return &utils.ValidationError{Location: "max_melo", Value: version.MaxMelo, Message: "invalid semantic version"}
+		} else if max.Compare(min) <= 0 {
+			return &utils.ValidationError{Location: "max_melo", Value: version.MaxMelo, Message: "maximum version must be greater than minimum version"}
+		}
+	}
+	if (version.Signature == "") != (version.SignatureKey == "") {
+		return &utils.ValidationError{Location: "signature_key", Value: version.SignatureKey, Message: "signature and signature key must be set together"}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// In-memory plugin entry
+type memoryPlugin struct {
+	plugin   Plugin
+	versions map[string]PluginVersion
+}
+
+// In-memory plugin store
+type memoryStore struct {
+	mutex   sync.RWMutex
+	plugins map[string]*memoryPlugin
+}
+
+// NewMemoryStore creates a plugin store kept in memory (all plugins are lost on exit)
+func NewMemoryStore() PluginStore {
+	return &memoryStore{
+		plugins: make(map[string]*memoryPlugin),
+	}
+}
+
+// Get the versions of a plugin sorted newest first
+func (entry *memoryPlugin) sortedVersions() []PluginVersion {
+	list := []PluginVersion{}
+	for _, version := range entry.versions {
+		list = append(list, version)
+	}
+	sortVersions(list)
+	return list
+}
+
+// Get the names of the plugins sorted
+func (s *memoryStore) sortedNames() []string {
+	names := []string{}
+	for name := range s.plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *memoryStore) List(ctx context.Context, query string) ([]Plugin, error) {
+	query = strings.ToLower(query)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Find matching plugins
+	list := []Plugin{}
+	for _, name := range s.sortedNames() {
+		plugin := s.plugins[name].plugin
+		if strings.Contains(plugin.Name, query) || strings.Contains(strings.ToLower(plugin.Title), query) ||
+			strings.Contains(strings.ToLower(plugin.Description), query) {
+			list = append(list, plugin)
+		}
+	}
+
+	return list, nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, name string) (Plugin, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entry, found := s.plugins[name]
+	if !found {
+		return Plugin{}, ErrPluginNotFound
+	}
+	plugin := entry.plugin
+	plugin.Versions = entry.sortedVersions()
+	return plugin, nil
+}
+
+func (s *memoryStore) Add(ctx context.Context, plugin Plugin) error {
+	// Check values
+	if err := validatePlugin(plugin); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Add or update plugin (keep versions)
+	entry, found := s.plugins[plugin.Name]
+	if !found {
+		entry = &memoryPlugin{versions: map[string]PluginVersion{}}
+		s.plugins[plugin.Name] = entry
+	}
+	plugin.LastUpdate = uint64(time.Now().Unix())
+	plugin.Versions = nil
+	entry.plugin = plugin
+
+	return nil
+}
+
+func (s *memoryStore) Remove(ctx context.Context, name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, found := s.plugins[name]; !found {
+		return ErrPluginNotFound
+	}
+	delete(s.plugins, name)
+
+	return nil
+}
+
+func (s *memoryStore) AddVersion(ctx context.Context, name string, version PluginVersion) error {
+	// Check values
+	if err := validateVersion(version); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, found := s.plugins[name]
+	if !found {
+		return ErrPluginNotFound
+	}
+
+	// Add or replace version (keep publication date)
+	now := uint64(time.Now().Unix())
+	version.Date = now
+	if previous, found := entry.versions[version.Version]; found {
+		version.Date = previous.Date
+	}
+	entry.versions[version.Version] = version
+	entry.plugin.LastUpdate = now
+
+	return nil
+}
+
+func (s *memoryStore) RemoveVersion(ctx context.Context, name string, version string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, found := s.plugins[name]
+	if !found {
+		return ErrPluginNotFound
+	} else if _, found := entry.versions[version]; !found {
+		return ErrVersionNotFound
+	}
+	delete(entry.versions, version)
+	entry.plugin.LastUpdate = uint64(time.Now().Unix())
+
+	return nil
+}
+
+func (s *memoryStore) Compatible(ctx context.Context, melo utils.Version) ([]CompatiblePlugin, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Find newest compatible version of each plugin
+	list := []CompatiblePlugin{}
+	for _, name := range s.sortedNames() {
+		entry := s.plugins[name]
+		if version, found := newestCompatible(entry.sortedVersions(), melo); found {
+			list = append(list, CompatiblePlugin{Name: name, Title: entry.plugin.Title, Version: version})
+		}
+	}
+
+	return list, nil
+}
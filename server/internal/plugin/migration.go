@@ -0,0 +1,255 @@
+package plugin
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Plugin tables migrations per dialect (append only: never modify an applied migration)
+var migrations = map[utils.Dialect][]utils.Migration{
+	utils.MySQL:      mysqlMigrations,
+	utils.SQLite:     sqliteMigrations,
+	utils.PostgreSQL: postgresMigrations,
+}
+
+var mysqlMigrations = []utils.Migration{
+	{
+		Version:     1,
+		Description: "create plugin and plugin_version tables",
+		Up: utils.Statements(
+			`CREATE TABLE IF NOT EXISTS plugin (
+  id INT(11) NOT NULL AUTO_INCREMENT,
+  name VARCHAR(64) NOT NULL,
+  title VARCHAR(128) NOT NULL,
+  description VARCHAR(1024) NOT NULL DEFAULT '',
+  author VARCHAR(128) NOT NULL DEFAULT '',
+  homepage VARCHAR(512) NOT NULL DEFAULT '',
+  last_update BIGINT(4) UNSIGNED NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY name (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_uca1400_ai_ci;`,
+			`CREATE TABLE IF NOT EXISTS plugin_version (
+  id INT(11) NOT NULL AUTO_INCREMENT,
+  plugin_id INT(11) NOT NULL,
+  version VARCHAR(64) NOT NULL,
+  url VARCHAR(512) NOT NULL,
+  size BIGINT(20) unsigned NOT NULL,
+  sha256 CHAR(64) NOT NULL,
+  notes TEXT,
+  min_melo VARCHAR(64) NOT NULL,
+  max_melo VARCHAR(64) NOT NULL DEFAULT '',
+  published BIGINT(4) UNSIGNED NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY plugin_id_version (plugin_id,version),
+  CONSTRAINT plugin_version_constraint FOREIGN KEY (plugin_id) REFERENCES plugin (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_uca1400_ai_ci;`,
+		),
+	},
+	{
+		Version:     2,
+		Description: "add plugin version signature",
+		Up: utils.Statements(
+			`ALTER TABLE plugin_version
+  ADD COLUMN signature VARCHAR(88) NOT NULL DEFAULT '',
+  ADD COLUMN signature_key VARCHAR(16) NOT NULL DEFAULT '';`,
+		),
+	},
+	{
+		Version:     3,
+		Description: "add plugin version comparable Melo versions",
+		Up:          mysqlAddMeloKeys,
+	},
+}
+
+// mysqlAddMeloKeys adds the comparable Melo version columns and fills them.
+//
+// MySQL / MariaDB implicitly commits the DDL statement, so the columns are only added when missing
+// and the migration can be applied again after a failure.
+func mysqlAddMeloKeys(tx *sql.Tx) error {
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM information_schema.COLUMNS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'plugin_version' AND COLUMN_NAME = 'min_melo_key'`).Scan(&count); err != nil {
+		return err
+	} else if count == 0 {
+		if _, err := tx.Exec(`ALTER TABLE plugin_version
+  ADD COLUMN min_melo_key VARCHAR(64) NOT NULL DEFAULT '' AFTER max_melo,
+  ADD COLUMN max_melo_key VARCHAR(64) NOT NULL DEFAULT '' AFTER min_melo_key;`); err != nil {
+			return err
+		}
+	}
+	return fillMeloKeys(utils.MySQL)(tx)
+}
+
+// fillMeloKeys creates a migration step computing the comparable Melo versions (see meloKey) of the
+// existing plugin versions
+func fillMeloKeys(dialect utils.Dialect) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		// Get the Melo versions (all rows are read before the updates)
+		type row struct {
+			id       uint
+			min_melo string
+			max_melo string
+		}
+		rows, err := tx.Query(`SELECT id, min_melo, max_melo FROM plugin_version`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		list := []row{}
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.min_melo, &r.max_melo); err != nil {
+				return err
+			}
+			list = append(list, r)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		// Set the keys
+		update := dialect.Rebind(`UPDATE plugin_version SET min_melo_key = ?, max_melo_key = ? WHERE id = ?`)
+		for _, r := range list {
+			if _, err := tx.Exec(update, meloKey(r.min_melo), meloKey(r.max_melo), r.id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+var sqliteMigrations = []utils.Migration{
+	{
+		Version:     1,
+		Description: "create plugin and plugin_version tables",
+		Up: utils.Statements(
+			`CREATE TABLE IF NOT EXISTS plugin (
+  id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  name VARCHAR(64) NOT NULL,
+  title VARCHAR(128) NOT NULL,
+  description VARCHAR(1024) NOT NULL DEFAULT '',
+  author VARCHAR(128) NOT NULL DEFAULT '',
+  homepage VARCHAR(512) NOT NULL DEFAULT '',
+  last_update INTEGER NOT NULL,
+  UNIQUE (name)
+);`,
+			`CREATE TABLE IF NOT EXISTS plugin_version (
+  id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  plugin_id INTEGER NOT NULL REFERENCES plugin (id) ON DELETE CASCADE,
+  version VARCHAR(64) NOT NULL,
+  url VARCHAR(512) NOT NULL,
+  size INTEGER NOT NULL,
+  sha256 CHAR(64) NOT NULL,
+  notes TEXT,
+  min_melo VARCHAR(64) NOT NULL,
+  max_melo VARCHAR(64) NOT NULL DEFAULT '',
+  published INTEGER NOT NULL,
+  UNIQUE (plugin_id, version)
+);`,
+		),
+	},
+	{
+		Version:     2,
+		Description: "add plugin version signature",
+		Up: utils.Statements(
+			`ALTER TABLE plugin_version ADD COLUMN signature VARCHAR(88) NOT NULL DEFAULT '';`,
+			`ALTER TABLE plugin_version ADD COLUMN signature_key VARCHAR(16) NOT NULL DEFAULT '';`,
+		),
+	},
+	{
+		Version:     3,
+		Description: "add plugin version comparable Melo versions",
+		Up: func(tx *sql.Tx) error {
+			if err := utils.Statements(
+				`ALTER TABLE plugin_version ADD COLUMN min_melo_key VARCHAR(64) NOT NULL DEFAULT '';`,
+				`ALTER TABLE plugin_version ADD COLUMN max_melo_key VARCHAR(64) NOT NULL DEFAULT '';`,
+			)(tx); err != nil {
+				return err
+			}
+			return fillMeloKeys(utils.SQLite)(tx)
+		},
+	},
+}
+
+var postgresMigrations = []utils.Migration{
+	{
+		Version:     1,
+		Description: "create plugin and plugin_version tables",
+		Up: utils.Statements(
+			`CREATE TABLE IF NOT EXISTS plugin (
+  id SERIAL PRIMARY KEY,
+  name VARCHAR(64) NOT NULL,
+  title VARCHAR(128) NOT NULL,
+  description VARCHAR(1024) NOT NULL DEFAULT '',
+  author VARCHAR(128) NOT NULL DEFAULT '',
+  homepage VARCHAR(512) NOT NULL DEFAULT '',
+  last_update BIGINT NOT NULL,
+  CONSTRAINT plugin_name UNIQUE (name)
+);`,
+			`CREATE TABLE IF NOT EXISTS plugin_version (
+  id SERIAL PRIMARY KEY,
+  plugin_id INTEGER NOT NULL REFERENCES plugin (id) ON DELETE CASCADE,
+  version VARCHAR(64) NOT NULL,
+  url VARCHAR(512) NOT NULL,
+  size BIGINT NOT NULL,
+  sha256 CHAR(64) NOT NULL,
+  notes TEXT,
+  min_melo VARCHAR(64) NOT NULL,
+  max_melo VARCHAR(64) NOT NULL DEFAULT '',
+  published BIGINT NOT NULL,
+  CONSTRAINT plugin_id_version UNIQUE (plugin_id, version)
+);`,
+		),
+	},
+	{
+		Version:     2,
+		Description: "add plugin version signature",
+		Up: utils.Statements(
+			`ALTER TABLE plugin_version
+  ADD COLUMN signature VARCHAR(88) NOT NULL DEFAULT '',
+  ADD COLUMN signature_key VARCHAR(16) NOT NULL DEFAULT '';`,
+		),
+	},
+	{
+		Version:     3,
+		Description: "add plugin version comparable Melo versions",
+		Up: func(tx *sql.Tx) error {
+			if err := utils.Statements(
+				`ALTER TABLE plugin_version
+  ADD COLUMN min_melo_key VARCHAR(64) NOT NULL DEFAULT '',
+  ADD COLUMN max_melo_key VARCHAR(64) NOT NULL DEFAULT '';`,
+			)(tx); err != nil {
+				return err
+			}
+			return fillMeloKeys(utils.PostgreSQL)(tx)
+		},
+	},
+}
+
+func InitializeTables(db *sql.DB, dialect utils.Dialect) bool {
+	// Upgrade tables to last version
+	if err := utils.Migrate(db, dialect, "plugin", migrations[dialect]); err != nil {
+		log.Errorf("failed to migrate Plugin tables: %s", err)
+		return false
+	}
+
+	return true
+}
+
+// CheckTables checks the Plugin tables are migrated to the last version
+func CheckTables(db *sql.DB, dialect utils.Dialect) error {
+	version, err := utils.GetTableVersion(db, dialect, "plugin")
+	if err != nil {
+		return fmt.Errorf("failed to get plugin tables version: %w", err)
+	}
+	expected := uint(len(migrations[dialect]))
+	if version != expected {
+		return fmt.Errorf("plugin tables version is %d instead of %d", version, expected)
+	}
+	return nil
+}
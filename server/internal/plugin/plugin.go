@@ -0,0 +1,148 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/danielgtaylor/huma/v2"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// Plugin of the catalog
+type Plugin struct {
+	Name        string          `json:"name" example:"spotify" pattern:"^[a-z0-9][a-z0-9_-]*$" maxLength:"64" doc:"The unique name of the plugin"`
+	Title       string          `json:"title" example:"Spotify" maxLength:"128" doc:"The display name of the plugin"`
+	Description string          `json:"description,omitempty" example:"Play music from Spotify" maxLength:"1024" doc:"The description of the plugin"`
+	Author      string          `json:"author,omitempty" example:"Melo" maxLength:"128" doc:"The author of the plugin"`
+	Homepage    string          `json:"homepage,omitempty" example:"https://github.com/dillya/melo" format:"uri" maxLength:"512" doc:"The home page of the plugin"`
+	LastUpdate  uint64          `json:"last_update" example:"0" doc:"The last update timestamp as Unix epoch (description or versions)" required:"false"`
+	Versions    []PluginVersion `json:"versions,omitempty" doc:"The versions of the plugin, newest first (only with the plugin details)" required:"false"`
+}
+
+// Version of a plugin with its compatibility range
+type PluginVersion struct {
+	Version string `json:"version" example:"1.1.0" minLength:"5" maxLength:"64" doc:"The semantic version of the plugin"`
+	Url     string `json:"url" example:"https://download.example.com/spotify-1.1.0.tar.gz" format:"uri" maxLength:"512" doc:"The download URL of the plugin"`
+	Size    uint64 `json:"size" example:"1048576" doc:"The size of the plugin archive in bytes"`
+	Sha256  string `json:"sha256" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" pattern:"^[0-9a-f]{64}$" doc:"The SHA-256 checksum of the plugin archive"`
+	Notes   string `json:"notes,omitempty" example:"Bug fixes" maxLength:"4096" doc:"The release notes"`
+	MinMelo string `json:"min_melo" example:"1.0.0" minLength:"5" maxLength:"64" doc:"The minimum compatible Melo version (included)"`
+	MaxMelo string `json:"max_melo,omitempty" example:"2.0.0" maxLength:"64" doc:"The maximum compatible Melo version (excluded, no maximum when empty)"`
+	Date    uint64 `json:"date" example:"0" doc:"The publication timestamp as Unix epoch (set on first publication)" required:"false"`
+
+	Signature    string `json:"signature,omitempty" pattern:"^[A-Za-z0-9+/]{86}==$" doc:"The base64 encoded Ed25519 signature of the SHA-256 checksum (32 raw bytes) of the plugin archive"`
+	SignatureKey string `json:"signature_key,omitempty" pattern:"^[0-9a-f]{16}$" doc:"The identifier of the artifact key used to sign the plugin archive"`
+}
+
+// Newest compatible version of a plugin
+type CompatiblePlugin struct {
+	Name    string        `json:"name" example:"spotify" doc:"The unique name of the plugin"`
+	Title   string        `json:"title" example:"Spotify" doc:"The display name of the plugin"`
+	Version PluginVersion `json:"version" doc:"The newest compatible version"`
+}
+
+// Plugin list
+type pluginListOutput struct {
+	Body []Plugin
+}
+
+// Plugin details
+type pluginOutput struct {
+	Body Plugin
+}
+
+// Compatible plugin list
+type compatibleListOutput struct {
+	Body []CompatiblePlugin
+}
+
+// Names used by the catalog paths
+var reservedNames = [...]string{"list", "compatible"}
+
+// validatePlugin checks the values not validated by the API
+func validatePlugin(plugin Plugin) error {
+	for _, name := range reservedNames {
+		if plugin.Name == name {
+			return &utils.ValidationError{Location: "name", Value: plugin.Name, Message: "reserved name"}
+		}
+	}
+	return nil
+}
+
+func Register(api huma.API, store PluginStore) {
+	// Register GET /plugin/list handler
+	huma.Register(api, huma.Operation{
+		OperationID: "listPlugin",
+		Method:      http.MethodGet,
+		Path:        "/plugin/list",
+		Summary:     "List / search plugins",
+		Description: "List the plugins of the catalog, and search them by name, title or description.",
+		Tags:        []string{"Plugin"},
+	}, func(ctx context.Context, input *struct {
+		Query string `query:"q" example:"spotify" maxLength:"128" doc:"The text to search in name, title and description (case insensitive)"`
+	}) (*pluginListOutput, error) {
+		// List plugins
+		plugins, err := store.List(ctx, input.Query)
+		if err != nil {
+			return nil, utils.HttpError(err, "")
+		}
+		resp := &pluginListOutput{}
+		resp.Body = plugins
+		return resp, nil
+	})
+
+	// Register GET /plugin/compatible handler
+	huma.Register(api, huma.Operation{
+		OperationID: "listCompatiblePlugin",
+		Method:      http.MethodGet,
+		Path:        "/plugin/compatible",
+		Summary:     "List compatible plugins",
+		Description: "List the newest version of each plugin compatible with a Melo version (the firmware version of the device).",
+		Tags:        []string{"Plugin"},
+		Errors:      []int{http.StatusUnprocessableEntity},
+	}, func(ctx context.Context, input *struct {
+		Melo string `query:"melo" example:"1.2.0" maxLength:"64" required:"true" doc:"The Melo version of the device"`
+	}) (*compatibleListOutput, error) {
+		// Parse Melo version
+		melo, err := utils.ParseVersion(input.Melo)
+		if err != nil {
+			return nil, huma.Error422UnprocessableEntity("validation failed", &huma.ErrorDetail{
+				Message:  "invalid semantic version",
+				Location: "query.melo",
+				Value:    input.Melo,
+			})
+		}
+
+		// List compatible plugins
+		plugins, err := store.Compatible(ctx, melo)
+		if err != nil {
+			return nil, utils.HttpError(err, "")
+		}
+		resp := &compatibleListOutput{}
+		resp.Body = plugins
+		return resp, nil
+	})
+
+	// Register GET /plugin/{name} handler
+	huma.Register(api, huma.Operation{
+		OperationID: "getPlugin",
+		Method:      http.MethodGet,
+		Path:        "/plugin/{name}",
+		Summary:     "Get plugin",
+		Description: "Get the details of a plugin with all its versions.",
+		Tags:        []string{"Plugin"},
+		Errors:      []int{http.StatusNotFound},
+	}, func(ctx context.Context, input *struct {
+		Name string `path:"name" example:"spotify" doc:"The name of the plugin"`
+	}) (*pluginOutput, error) {
+		// Get plugin
+		plugin, err := store.Get(ctx, input.Name)
+		if err != nil {
+			return nil, utils.HttpError(err, "path.")
+		}
+		resp := &pluginOutput{}
+		resp.Body = plugin
+		return resp, nil
+	})
+}
@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// PluginStore is the storage backend of the plugin catalog.
+//
+// A plugin is identified by its name, and its versions by their semantic version. The errors are
+// ErrPluginNotFound, ErrVersionNotFound, utils.ErrUnavailable, a *utils.ValidationError, a context
+// error or another database error.
+type PluginStore interface {
+	// List the plugins (without their versions) sorted by name, matching the query in their name,
+	// title or description (case insensitive) when set
+	List(ctx context.Context, query string) ([]Plugin, error)
+	// Get a plugin with its versions (newest first)
+	Get(ctx context.Context, name string) (Plugin, error)
+	// Add a plugin or update its description (the versions are not changed)
+	Add(ctx context.Context, plugin Plugin) error
+	// Remove a plugin with all its versions
+	Remove(ctx context.Context, name string) error
+	// Publish a version of a plugin or replace it (the publication date is kept)
+	AddVersion(ctx context.Context, name string, version PluginVersion) error
+	// Remove a version of a plugin
+	RemoveVersion(ctx context.Context, name string, version string) error
+	// List the newest version of each plugin compatible with a Melo version, sorted by plugin name
+	Compatible(ctx context.Context, melo utils.Version) ([]CompatiblePlugin, error)
+}
+
+// Sort versions newest first
+func sortVersions(list []PluginVersion) {
+	versions := map[string]utils.Version{}
+	for _, version := range list {
+		versions[version.Version], _ = utils.ParseVersion(version.Version)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return versions[list[i].Version].Compare(versions[list[j].Version]) > 0
+	})
+}
+
+// meloKey returns the comparable key of a Melo version stored with a plugin version (empty when the
+// version is empty or invalid): the keys of the version cores (major.minor.patch) are fixed-width
+// strings sorted like the versions, so the databases can compare them. The pre-release identifiers
+// are not part of the key, so a range compared on the keys must be checked with isCompatible.
+func meloKey(str string) string {
+	version, err := utils.ParseVersion(str)
+	if str == "" || err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%020d.%020d.%020d", version.Major, version.Minor, version.Patch)
+}
+
+// Check a plugin version is compatible with a Melo version (minimum included, maximum excluded)
+func isCompatible(version PluginVersion, melo utils.Version) bool {
+	if min, err := utils.ParseVersion(version.MinMelo); err != nil || melo.Compare(min) < 0 {
+		return false
+	}
+	if version.MaxMelo != "" {
+		if max, err := utils.ParseVersion(version.MaxMelo); err != nil || melo.Compare(max) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Get the newest version compatible with a Melo version (the versions are sorted newest first)
+func newestCompatible(versions []PluginVersion, melo utils.Version) (PluginVersion, bool) {
+	for _, version := range versions {
+		if isCompatible(version, melo) {
+			return version, true
+		}
+	}
+	return PluginVersion{}, false
+}
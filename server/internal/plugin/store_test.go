@@ -0,0 +1,253 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// Open a SQLite database with the version table in a temporary directory
+func openTestDatabase(tb testing.TB) *sql.DB {
+	dsn := "file:" + filepath.Join(tb.TempDir(), "test.db") + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		tb.Fatalf("failed to open database: %s", err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	if err := utils.InitializeVersionTable(db, utils.SQLite); err != nil {
+		tb.Fatalf("failed to create version table: %s", err)
+	}
+	return db
+}
+
+// Stores under test: each test runs against the in-memory store and the SQLite store
+var testStores = []struct {
+	name string
+	open func(tb testing.TB) PluginStore
+}{
+	{"memory", func(tb testing.TB) PluginStore { return NewMemoryStore() }},
+	{"sqlite", func(tb testing.TB) PluginStore {
+		db := openTestDatabase(tb)
+		if !InitializeTables(db, utils.SQLite) {
+			tb.Fatal("failed to create plugin tables")
+		}
+		return NewSQLStore(db, utils.SQLite)
+	}},
+}
+
+// Create a plugin version of the tests
+func testVersion(version string, min_melo string, max_melo string) PluginVersion {
+	return PluginVersion{
+		Version: version,
+		Url:     "https://example.com/" + version + ".tar.gz",
+		Sha256:  "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		MinMelo: min_melo,
+		MaxMelo: max_melo,
+	}
+}
+
+// Names and versions of compatible plugins
+func compatibleVersions(list []CompatiblePlugin) []string {
+	versions := []string{}
+	for _, plugin := range list {
+		versions = append(versions, plugin.Name+"@"+plugin.Version.Version)
+	}
+	return versions
+}
+
+func TestCompatible(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.open(t)
+			ctx := context.Background()
+			for name, versions := range map[string][]PluginVersion{
+				"radio": {
+					testVersion("1.0.0", "1.0.0", "2.0.0"),
+					testVersion("2.0.0", "2.0.0", ""),
+					testVersion("2.1.0-beta.1", "2.1.0", ""),
+				},
+				"spotify": {
+					testVersion("1.0.0", "1.2.0", "1.10.0"),
+				},
+				"empty": {},
+			} {
+				if err := s.Add(ctx, Plugin{Name: name, Title: name}); err != nil {
+					t.Fatalf("failed to add plugin: %s", err)
+				}
+				for _, version := range versions {
+					if err := s.AddVersion(ctx, name, version); err != nil {
+						t.Fatalf("failed to add version: %s", err)
+					}
+				}
+			}
+
+			for _, test := range []struct {
+				melo string
+				want []string
+			}{
+				{"0.9.0", []string{}},
+				{"1.0.0-rc.1", []string{}},
+				{"1.0.0", []string{"radio@1.0.0"}},
+				{"1.2.0", []string{"radio@1.0.0", "spotify@1.0.0"}},
+				{"1.9.0", []string{"radio@1.0.0", "spotify@1.0.0"}},
+				{"1.10.0-rc.1", []string{"radio@1.0.0", "spotify@1.0.0"}},
+				{"1.10.0", []string{"radio@1.0.0"}},
+				{"2.0.0-rc.1", []string{"radio@1.0.0"}},
+				{"2.0.0", []string{"radio@2.0.0"}},
+				{"2.1.0", []string{"radio@2.1.0-beta.1"}},
+				{"10.0.0", []string{"radio@2.1.0-beta.1"}},
+			} {
+				t.Run(test.melo, func(t *testing.T) {
+					melo, _ := utils.ParseVersion(test.melo)
+					list, err := s.Compatible(ctx, melo)
+					if err != nil {
+						t.Fatalf("failed to list compatible plugins: %s", err)
+					} else if got := compatibleVersions(list); !reflect.DeepEqual(got, test.want) {
+						t.Fatalf("got %v, want %v", got, test.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.open(t)
+			ctx := context.Background()
+			for _, plugin := range []Plugin{
+				{Name: "spotify", Title: "Spotify", Description: "Play music from Spotify"},
+				{Name: "radio", Title: "Web Radio", Description: "100% free radios"},
+				{Name: "file_browser", Title: "Files", Description: "Browse local files"},
+				{Name: "podcast", Title: "Podcasts!", Description: "Listen to podcasts"},
+			} {
+				if err := s.Add(ctx, plugin); err != nil {
+					t.Fatalf("failed to add plugin: %s", err)
+				}
+			}
+
+			for _, test := range []struct {
+				name  string
+				query string
+				want  []string
+			}{
+				{"all", "", []string{"file_browser", "podcast", "radio", "spotify"}},
+				{"name", "spot", []string{"spotify"}},
+				{"title case", "RADIO", []string{"radio"}},
+				{"description", "local", []string{"file_browser"}},
+				{"percent", "%", []string{"radio"}},
+				{"underscore", "_", []string{"file_browser"}},
+				{"escape character", "!", []string{"podcast"}},
+				{"no match", "tidal", []string{}},
+			} {
+				t.Run(test.name, func(t *testing.T) {
+					list, err := s.List(ctx, test.query)
+					if err != nil {
+						t.Fatalf("failed to list plugins: %s", err)
+					}
+					got := []string{}
+					for _, plugin := range list {
+						got = append(got, plugin.Name)
+					}
+					if !reflect.DeepEqual(got, test.want) {
+						t.Fatalf("got %v, want %v", got, test.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestRemoveVersion(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.open(t)
+			ctx := context.Background()
+			if err := s.Add(ctx, Plugin{Name: "radio", Title: "Radio"}); err != nil {
+				t.Fatalf("failed to add plugin: %s", err)
+			}
+			for _, version := range []string{"1.0.0", "1.1.0"} {
+				if err := s.AddVersion(ctx, "radio", testVersion(version, "1.0.0", "")); err != nil {
+					t.Fatalf("failed to add version: %s", err)
+				}
+			}
+
+			for _, test := range []struct {
+				name    string
+				plugin  string
+				version string
+				err     error
+				want    []string
+			}{
+				{"unknown plugin", "tidal", "1.0.0", ErrPluginNotFound, []string{"1.1.0", "1.0.0"}},
+				{"unknown version", "radio", "2.0.0", ErrVersionNotFound, []string{"1.1.0", "1.0.0"}},
+				{"newest version", "radio", "1.1.0", nil, []string{"1.0.0"}},
+				{"removed version", "radio", "1.1.0", ErrVersionNotFound, []string{"1.0.0"}},
+				{"last version", "radio", "1.0.0", nil, []string{}},
+			} {
+				t.Run(test.name, func(t *testing.T) {
+					if err := s.RemoveVersion(ctx, test.plugin, test.version); !errors.Is(err, test.err) {
+						t.Fatalf("got %v, want %v", err, test.err)
+					}
+					plugin, err := s.Get(ctx, "radio")
+					if err != nil {
+						t.Fatalf("failed to get plugin: %s", err)
+					}
+					got := []string{}
+					for _, version := range plugin.Versions {
+						got = append(got, version.Version)
+					}
+					if !reflect.DeepEqual(got, test.want) {
+						t.Fatalf("got versions %v, want %v", got, test.want)
+					}
+				})
+			}
+
+			// The plugin is kept without version
+			melo, _ := utils.ParseVersion("1.0.0")
+			if list, err := s.Compatible(ctx, melo); err != nil || len(list) != 0 {
+				t.Fatalf("got compatible plugins %v, %v, want none", compatibleVersions(list), err)
+			}
+		})
+	}
+}
+
+func TestMigrateMeloKeys(t *testing.T) {
+	// Add a version before the comparable Melo versions
+	db := openTestDatabase(t)
+	if err := utils.Migrate(db, utils.SQLite, "plugin", sqliteMigrations[:2]); err != nil {
+		t.Fatalf("failed to create plugin tables: %s", err)
+	}
+	if _, err := db.Exec(`INSERT INTO plugin (id, name, title, last_update) VALUES (1, 'radio', 'Radio', 0)`); err != nil {
+		t.Fatalf("failed to add plugin: %s", err)
+	}
+	if _, err := db.Exec(`INSERT INTO plugin_version (plugin_id, version, url, size, sha256, min_melo, max_melo, published)
+VALUES (1, '1.0.0', 'https://example.com/radio.tar.gz', 0, '', '1.2.0', '1.10.0', 0)`); err != nil {
+		t.Fatalf("failed to add version: %s", err)
+	}
+
+	// The keys of the existing versions are filled by the migration
+	if !InitializeTables(db, utils.SQLite) {
+		t.Fatal("failed to migrate plugin tables")
+	}
+	s := NewSQLStore(db, utils.SQLite)
+	for _, test := range []struct {
+		melo  string
+		count int
+	}{
+		{"1.1.0", 0},
+		{"1.9.0", 1},
+		{"1.10.0", 0},
+	} {
+		melo, _ := utils.ParseVersion(test.melo)
+		if list, err := s.Compatible(context.Background(), melo); err != nil || len(list) != test.count {
+			t.Fatalf("%s: got %d plugins, %v, want %d", test.melo, len(list), err, test.count)
+		}
+	}
+}
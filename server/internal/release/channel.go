@@ -0,0 +1,27 @@
+package release
+
+type Channel uint
+
+const (
+	StableChannel Channel = iota
+	BetaChannel
+	NightlyChannel
+)
+
+var channelMap = [...]string{"stable", "beta", "nightly"}
+
+func (c Channel) ToString() string {
+	if int(c) < len(channelMap) {
+		return channelMap[c]
+	}
+	return channelMap[0]
+}
+
+func ChannelFromString(str string) uint {
+	for index := range channelMap {
+		if channelMap[index] == str {
+			return uint(index)
+		}
+	}
+	return 0
+}
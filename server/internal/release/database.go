@@ -0,0 +1,203 @@
+package release
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// SQL release store
+type sqlStore struct {
+	db      *sql.DB
+	queries sqlQueries
+}
+
+// Dialect specific queries
+type sqlQueries struct {
+	list_releases  string
+	get_release    string
+	add_release    string
+	remove_release string
+	set_rollout    string
+	add_check      string
+	purge_checks   string
+	get_adoption   string
+}
+
+// Release columns to use with scanRelease
+const releaseColumns = "version, channel, arch, url, size, sha256, notes, published, min_version, signature, signature_key, rollout, rollout_state"
+
+func newSqlQueries(d utils.Dialect) sqlQueries {
+	return sqlQueries{
+		list_releases: d.Rebind("SELECT " + releaseColumns + " FROM melo_release WHERE (? = '' OR arch=?) AND channel<=?"),
+		get_release:   d.Rebind("SELECT " + releaseColumns + " FROM melo_release WHERE version=? AND arch=?"),
+		add_release: d.Rebind(`INSERT INTO melo_release
+(version, channel, arch, url, size, sha256, notes, published, min_version, signature, signature_key, rollout, rollout_state)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+` + d.Upsert([]string{"version", "arch"}, "channel", "url", "size", "sha256", "notes", "min_version", "signature", "signature_key")),
+		remove_release: d.Rebind("DELETE FROM melo_release WHERE version=? AND arch=?"),
+		set_rollout:    d.Rebind("UPDATE melo_release SET rollout=?, rollout_state=? WHERE version=? AND arch=?"),
+		add_check: d.Rebind(`INSERT INTO release_check
+(serial, arch, channel, version, offered, last_check)
+VALUES (?, ?, ?, ?, ?, ?)
+` + d.Upsert([]string{"serial"}, "arch", "channel", "version", "offered", "last_check")),
+		get_adoption: d.Rebind(`SELECT COUNT(*),
+COALESCE(SUM(CASE WHEN offered=? THEN 1 ELSE 0 END), 0),
+COALESCE(SUM(CASE WHEN version=? THEN 1 ELSE 0 END), 0)
+FROM release_check WHERE arch=? AND channel>=? AND last_check>=?`),
+		purge_checks: d.Rebind("DELETE FROM release_check WHERE last_check<?"),
+	}
+}
+
+// NewSQLStore creates a release store backed by a SQL database (MySQL / MariaDB, SQLite or PostgreSQL)
+func NewSQLStore(db *sql.DB, dialect utils.Dialect) ReleaseStore {
+	return &sqlStore{db: db, queries: newSqlQueries(dialect)}
+}
+
+// Scan a release from the releaseColumns
+func scanRelease(scan func(dest ...any) error) (Release, error) {
+	var channel, rollout_state uint
+	var notes []byte
+	rel := Release{}
+	if err := scan(&rel.Version, &channel, &rel.Arch, &rel.Url, &rel.Size, &rel.Sha256, &notes, &rel.Date,
+		&rel.MinVersion, &rel.Signature, &rel.SignatureKey, &rel.Rollout, &rollout_state); err != nil {
+		return rel, err
+	}
+	rel.Channel = Channel.ToString(Channel(channel))
+	rel.RolloutState = RolloutState.ToString(RolloutState(rollout_state))
+	rel.Notes = string(notes)
+	return rel, nil
+}
+
+func (s *sqlStore) List(ctx context.Context, channel Channel, arch string) ([]Release, error) {
+	// Fetch releases
+	rows, err := s.db.QueryContext(ctx, s.queries.list_releases, arch, arch, channel)
+	if err != nil {
+		return nil, utils.DbError(ctx, s.db, err)
+	}
+	defer rows.Close()
+
+	// Generate list sorted by version (not sortable by the database)
+	list := []Release{}
+	for rows.Next() {
+		rel, err := scanRelease(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rel)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sortReleases(list)
+
+	return list, nil
+}
+
+func (s *sqlStore) Get(ctx context.Context, version string, arch string) (Release, error) {
+	rel, err := scanRelease(s.db.QueryRowContext(ctx, s.queries.get_release, version, arch).Scan)
+	if err == sql.ErrNoRows {
+		return rel, ErrReleaseNotFound
+	} else if err != nil {
+		return rel, utils.DbError(ctx, s.db, err)
+	}
+	return rel, nil
+}
+
+func (s *sqlStore) Add(ctx context.Context, rel Release) error {
+	// Check values
+	if err := validateRelease(rel); err != nil {
+		return err
+	}
+
+	// Add or replace release (publication date and rollout are not updated)
+	_, err := s.db.ExecContext(ctx, s.queries.add_release,
+		rel.Version,
+		ChannelFromString(rel.Channel),
+		rel.Arch,
+		rel.Url,
+		rel.Size,
+		rel.Sha256,
+		rel.Notes,
+		time.Now().Unix(),
+		rel.MinVersion,
+		rel.Signature,
+		rel.SignatureKey,
+		rel.Rollout,
+		RolloutStateFromString(rel.RolloutState),
+	)
+	if err != nil {
+		return utils.DbError(ctx, s.db, err)
+	}
+	return nil
+}
+
+func (s *sqlStore) Remove(ctx context.Context, version string, arch string) error {
+	result, err := s.db.ExecContext(ctx, s.queries.remove_release, version, arch)
+	if err != nil {
+		return utils.DbError(ctx, s.db, err)
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrReleaseNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) SetRollout(ctx context.Context, version string, arch string, rollout uint8, state RolloutState) error {
+	// Check release exists (no row is affected by an update without change in MySQL)
+	if _, err := s.Get(ctx, version, arch); err != nil {
+		return err
+	}
+
+	// Update rollout
+	if _, err := s.db.ExecContext(ctx, s.queries.set_rollout, rollout, state, version, arch); err != nil {
+		return utils.DbError(ctx, s.db, err)
+	}
+	return nil
+}
+
+func (s *sqlStore) AddCheck(ctx context.Context, check UpdateCheck) error {
+	_, err := s.db.ExecContext(ctx, s.queries.add_check,
+		check.Serial,
+		check.Arch,
+		check.Channel,
+		check.Version,
+		check.Offered,
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return utils.DbError(ctx, s.db, err)
+	}
+	return nil
+}
+
+func (s *sqlStore) GetAdoption(ctx context.Context, version string, arch string, since uint64) (Adoption, error) {
+	// Get release
+	rel, err := s.Get(ctx, version, arch)
+	if err != nil {
+		return Adoption{}, err
+	}
+	adoption := Adoption{Version: version, Arch: arch, Rollout: rel.Rollout, RolloutState: rel.RolloutState}
+
+	// Count devices which can be offered the release
+	err = s.db.QueryRowContext(ctx, s.queries.get_adoption, version, version, arch, ChannelFromString(rel.Channel), since).
+		Scan(&adoption.Devices, &adoption.Offered, &adoption.Installed)
+	if err != nil {
+		return adoption, utils.DbError(ctx, s.db, err)
+	}
+	adoption.setRate()
+
+	return adoption, nil
+}
+
+func (s *sqlStore) PurgeChecks(ctx context.Context, before uint64) (int64, error) {
+	result, err := s.db.ExecContext(ctx, s.queries.purge_checks, before)
+	if err != nil {
+		return 0, utils.DbError(ctx, s.db, err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,26 @@
+package release
+
+import (
+	"fmt"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// Error returned by the release stores (see also utils.ErrUnavailable)
+var ErrReleaseNotFound = fmt.Errorf("release %w", utils.ErrNotFound)
+
+// validateRelease checks the values not validated by the API
+func validateRelease(rel Release) error {
+	if _, err := utils.ParseVersion(rel.Version); err != nil {
+		return &utils.ValidationError{Location: "version", Value: rel.Version, Message: "invalid semantic version"}
+	}
+	if rel.MinVersion != "" {
+		if _, err := utils.ParseVersion(rel.MinVersion); err != nil {
+			return &utils.ValidationError{Location: "min_version", Value: rel.MinVersion, Message: "invalid semantic version"}
+		}
+	}
+	if (rel.Signature == "") != (rel.SignatureKey == "") {
+		return &utils.ValidationError{Location: "signature_key", Value: rel.SignatureKey, Message: "signature and signature key must be set together"}
+	}
+	return nil
+}
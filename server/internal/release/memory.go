@@ -0,0 +1,162 @@
+package release
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// In-memory release key
+type memoryKey struct {
+	version string
+	arch    string
+}
+
+// In-memory release store
+type memoryStore struct {
+	mutex    sync.RWMutex
+	releases map[memoryKey]Release
+	checks   map[string]UpdateCheck
+}
+
+// NewMemoryStore creates a release store kept in memory (all releases are lost on exit)
+func NewMemoryStore() ReleaseStore {
+	return &memoryStore{
+		releases: make(map[memoryKey]Release),
+		checks:   make(map[string]UpdateCheck),
+	}
+}
+
+func (s *memoryStore) List(ctx context.Context, channel Channel, arch string) ([]Release, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Find releases of the channel and the more stable ones
+	list := []Release{}
+	for key, rel := range s.releases {
+		if (arch == "" || key.arch == arch) && Channel(ChannelFromString(rel.Channel)) <= channel {
+			list = append(list, rel)
+		}
+	}
+	sortReleases(list)
+
+	return list, nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, version string, arch string) (Release, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	rel, found := s.releases[memoryKey{version: version, arch: arch}]
+	if !found {
+		return Release{}, ErrReleaseNotFound
+	}
+	return rel, nil
+}
+
+func (s *memoryStore) Add(ctx context.Context, rel Release) error {
+	// Check values
+	if err := validateRelease(rel); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Add or replace release (keep publication date and rollout)
+	key := memoryKey{version: rel.Version, arch: rel.Arch}
+	rel.Channel = Channel.ToString(Channel(ChannelFromString(rel.Channel)))
+	rel.RolloutState = RolloutState.ToString(RolloutState(RolloutStateFromString(rel.RolloutState)))
+	rel.Date = uint64(time.Now().Unix())
+	if previous, found := s.releases[key]; found {
+		rel.Date = previous.Date
+		rel.Rollout = previous.Rollout
+		rel.RolloutState = previous.RolloutState
+	}
+	s.releases[key] = rel
+
+	return nil
+}
+
+func (s *memoryStore) Remove(ctx context.Context, version string, arch string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := memoryKey{version: version, arch: arch}
+	if _, found := s.releases[key]; !found {
+		return ErrReleaseNotFound
+	}
+	delete(s.releases, key)
+
+	return nil
+}
+
+func (s *memoryStore) SetRollout(ctx context.Context, version string, arch string, rollout uint8, state RolloutState) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := memoryKey{version: version, arch: arch}
+	rel, found := s.releases[key]
+	if !found {
+		return ErrReleaseNotFound
+	}
+	rel.Rollout = rollout
+	rel.RolloutState = state.ToString()
+	s.releases[key] = rel
+
+	return nil
+}
+
+func (s *memoryStore) AddCheck(ctx context.Context, check UpdateCheck) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	check.Date = uint64(time.Now().Unix())
+	s.checks[check.Serial] = check
+
+	return nil
+}
+
+func (s *memoryStore) GetAdoption(ctx context.Context, version string, arch string, since uint64) (Adoption, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Get release
+	rel, found := s.releases[memoryKey{version: version, arch: arch}]
+	if !found {
+		return Adoption{}, ErrReleaseNotFound
+	}
+	adoption := Adoption{Version: version, Arch: arch, Rollout: rel.Rollout, RolloutState: rel.RolloutState}
+
+	// Count devices which can be offered the release
+	channel := Channel(ChannelFromString(rel.Channel))
+	for _, check := range s.checks {
+		if check.Arch != arch || check.Channel < channel || check.Date < since {
+			continue
+		}
+		adoption.Devices++
+		if check.Offered == version {
+			adoption.Offered++
+		}
+		if check.Version == version {
+			adoption.Installed++
+		}
+	}
+	adoption.setRate()
+
+	return adoption, nil
+}
+
+func (s *memoryStore) PurgeChecks(ctx context.Context, before uint64) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var count int64
+	for serial, check := range s.checks {
+		if check.Date < before {
+			delete(s.checks, serial)
+			count++
+		}
+	}
+	return count, nil
+}
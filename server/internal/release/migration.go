@@ -0,0 +1,224 @@
+package release
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Release tables migrations per dialect (append only: never modify an applied migration)
+var migrations = map[utils.Dialect][]utils.Migration{
+	utils.MySQL:      mysqlMigrations,
+	utils.SQLite:     sqliteMigrations,
+	utils.PostgreSQL: postgresMigrations,
+}
+
+// Note: "release" is a reserved word in MySQL / MariaDB
+var mysqlMigrations = []utils.Migration{
+	{
+		Version:     1,
+		Description: "create melo_release table",
+		Up: utils.Statements(
+			`CREATE TABLE IF NOT EXISTS melo_release (
+  id INT(11) NOT NULL AUTO_INCREMENT,
+  version VARCHAR(64) NOT NULL,
+  channel TINYINT(3) unsigned NOT NULL DEFAULT 0,
+  arch VARCHAR(32) NOT NULL,
+  url VARCHAR(512) NOT NULL,
+  size BIGINT(20) unsigned NOT NULL,
+  sha256 CHAR(64) NOT NULL,
+  notes TEXT,
+  published BIGINT(4) UNSIGNED NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY version_arch (version,arch),
+  KEY arch (arch)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_uca1400_ai_ci;`,
+		),
+	},
+	{
+		Version:     2,
+		Description: "add minimum version and signature",
+		Up: utils.Statements(
+			`ALTER TABLE melo_release
+  ADD COLUMN min_version VARCHAR(64) NOT NULL DEFAULT '',
+  ADD COLUMN signature VARCHAR(88) NOT NULL DEFAULT '',
+  ADD COLUMN signature_key VARCHAR(16) NOT NULL DEFAULT '';`,
+		),
+	},
+	{
+		Version:     3,
+		Description: "add rollout",
+		Up: utils.Statements(
+			`ALTER TABLE melo_release
+  ADD COLUMN rollout TINYINT(3) unsigned NOT NULL DEFAULT 100,
+  ADD COLUMN rollout_state TINYINT(3) unsigned NOT NULL DEFAULT 0;`,
+		),
+	},
+	{
+		Version:     4,
+		Description: "create release_check table",
+		Up: utils.Statements(
+			`CREATE TABLE IF NOT EXISTS release_check (
+  serial VARCHAR(17) NOT NULL,
+  arch VARCHAR(32) NOT NULL,
+  channel TINYINT(3) unsigned NOT NULL DEFAULT 0,
+  version VARCHAR(64) NOT NULL,
+  offered VARCHAR(64) NOT NULL,
+  last_check BIGINT(4) UNSIGNED NOT NULL,
+  PRIMARY KEY (serial),
+  KEY arch_last_check (arch,last_check)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_uca1400_ai_ci;`,
+		),
+	},
+	{
+		Version:     5,
+		Description: "add release_check purge index",
+		Up:          utils.Statements(`ALTER TABLE release_check ADD KEY last_check (last_check);`),
+	},
+}
+
+var sqliteMigrations = []utils.Migration{
+	{
+		Version:     1,
+		Description: "create melo_release table",
+		Up: utils.Statements(
+			`CREATE TABLE IF NOT EXISTS melo_release (
+  id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  version VARCHAR(64) NOT NULL,
+  channel INTEGER NOT NULL DEFAULT 0,
+  arch VARCHAR(32) NOT NULL,
+  url VARCHAR(512) NOT NULL,
+  size INTEGER NOT NULL,
+  sha256 CHAR(64) NOT NULL,
+  notes TEXT,
+  published INTEGER NOT NULL,
+  UNIQUE (version, arch)
+);`,
+			`CREATE INDEX IF NOT EXISTS melo_release_arch ON melo_release (arch);`,
+		),
+	},
+	{
+		Version:     2,
+		Description: "add minimum version and signature",
+		Up: utils.Statements(
+			`ALTER TABLE melo_release ADD COLUMN min_version VARCHAR(64) NOT NULL DEFAULT '';`,
+			`ALTER TABLE melo_release ADD COLUMN signature VARCHAR(88) NOT NULL DEFAULT '';`,
+			`ALTER TABLE melo_release ADD COLUMN signature_key VARCHAR(16) NOT NULL DEFAULT '';`,
+		),
+	},
+	{
+		Version:     3,
+		Description: "add rollout",
+		Up: utils.Statements(
+			`ALTER TABLE melo_release ADD COLUMN rollout INTEGER NOT NULL DEFAULT 100;`,
+			`ALTER TABLE melo_release ADD COLUMN rollout_state INTEGER NOT NULL DEFAULT 0;`,
+		),
+	},
+	{
+		Version:     4,
+		Description: "create release_check table",
+		Up: utils.Statements(
+			`CREATE TABLE IF NOT EXISTS release_check (
+  serial VARCHAR(17) NOT NULL PRIMARY KEY,
+  arch VARCHAR(32) NOT NULL,
+  channel INTEGER NOT NULL DEFAULT 0,
+  version VARCHAR(64) NOT NULL,
+  offered VARCHAR(64) NOT NULL,
+  last_check INTEGER NOT NULL
+);`,
+			`CREATE INDEX IF NOT EXISTS release_check_arch_last_check ON release_check (arch, last_check);`,
+		),
+	},
+	{
+		Version:     5,
+		Description: "add release_check purge index",
+		Up:          utils.Statements(`CREATE INDEX IF NOT EXISTS release_check_last_check ON release_check (last_check);`),
+	},
+}
+
+var postgresMigrations = []utils.Migration{
+	{
+		Version:     1,
+		Description: "create melo_release table",
+		Up: utils.Statements(
+			`CREATE TABLE IF NOT EXISTS melo_release (
+  id SERIAL PRIMARY KEY,
+  version VARCHAR(64) NOT NULL,
+  channel SMALLINT NOT NULL DEFAULT 0,
+  arch VARCHAR(32) NOT NULL,
+  url VARCHAR(512) NOT NULL,
+  size BIGINT NOT NULL,
+  sha256 CHAR(64) NOT NULL,
+  notes TEXT,
+  published BIGINT NOT NULL,
+  CONSTRAINT version_arch UNIQUE (version, arch)
+);`,
+			`CREATE INDEX IF NOT EXISTS melo_release_arch ON melo_release (arch);`,
+		),
+	},
+	{
+		Version:     2,
+		Description: "add minimum version and signature",
+		Up: utils.Statements(
+			`ALTER TABLE melo_release
+  ADD COLUMN min_version VARCHAR(64) NOT NULL DEFAULT '',
+  ADD COLUMN signature VARCHAR(88) NOT NULL DEFAULT '',
+  ADD COLUMN signature_key VARCHAR(16) NOT NULL DEFAULT '';`,
+		),
+	},
+	{
+		Version:     3,
+		Description: "add rollout",
+		Up: utils.Statements(
+			`ALTER TABLE melo_release
+  ADD COLUMN rollout SMALLINT NOT NULL DEFAULT 100,
+  ADD COLUMN rollout_state SMALLINT NOT NULL DEFAULT 0;`,
+		),
+	},
+	{
+		Version:     4,
+		Description: "create release_check table",
+		Up: utils.Statements(
+			`CREATE TABLE IF NOT EXISTS release_check (
+  serial VARCHAR(17) PRIMARY KEY,
+  arch VARCHAR(32) NOT NULL,
+  channel SMALLINT NOT NULL DEFAULT 0,
+  version VARCHAR(64) NOT NULL,
+  offered VARCHAR(64) NOT NULL,
+  last_check BIGINT NOT NULL
+);`,
+			`CREATE INDEX IF NOT EXISTS release_check_arch_last_check ON release_check (arch, last_check);`,
+		),
+	},
+	{
+		Version:     5,
+		Description: "add release_check purge index",
+		Up:          utils.Statements(`CREATE INDEX IF NOT EXISTS release_check_last_check ON release_check (last_check);`),
+	},
+}
+
+func InitializeTables(db *sql.DB, dialect utils.Dialect) bool {
+	// Upgrade tables to last version
+	if err := utils.Migrate(db, dialect, "release", migrations[dialect]); err != nil {
+		log.Errorf("failed to migrate Release tables: %s", err)
+		return false
+	}
+
+	return true
+}
+
+// CheckTables checks the Release tables are migrated to the last version
+func CheckTables(db *sql.DB, dialect utils.Dialect) error {
+	version, err := utils.GetTableVersion(db, dialect, "release")
+	if err != nil {
+		return fmt.Errorf("failed to get release tables version: %w", err)
+	}
+	expected := uint(len(migrations[dialect]))
+	if version != expected {
+		return fmt.Errorf("release tables version is %d instead of %d", version, expected)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package release
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Reaper is a background worker removing the update checks of the devices which stopped to check
+// for update: a check older than the retention period is not counted in the adoption anymore.
+type Reaper struct {
+	store     ReleaseStore
+	retention time.Duration
+	interval  time.Duration
+}
+
+// NewReaper creates a reaper: a zero retention disables it
+func NewReaper(store ReleaseStore, retention time.Duration) *Reaper {
+	// Check often enough to remove a check within 1.5 x retention
+	interval := retention / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	} else if interval < time.Second {
+		interval = time.Second
+	}
+
+	return &Reaper{
+		store:     store,
+		retention: retention,
+		interval:  interval,
+	}
+}
+
+// Run executes the reaper until the context is canceled
+func (r *Reaper) Run(ctx context.Context) {
+	if r.retention <= 0 {
+		return
+	}
+
+	log.Infof("update check reaper started: retention = %s", r.retention)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.Reap(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reap executes a single pass of the reaper
+func (r *Reaper) Reap(ctx context.Context) {
+	// Remove checks older than the retention period
+	before := uint64(time.Now().Add(-r.retention).Unix())
+	if count, err := r.store.PurgeChecks(ctx, before); err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("failed to purge update checks")
+	} else if count > 0 {
+		log.Infof("%d update check(s) removed after retention period", count)
+	}
+}
@@ -0,0 +1,230 @@
+package release
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+
+	"github.com/dillya/melo-webapi/internal/device"
+	"github.com/dillya/melo-webapi/internal/signing"
+	"github.com/dillya/melo-webapi/internal/utils"
+	"github.com/dillya/melo-webapi/internal/utils/middleware"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Release of Melo for an architecture
+type Release struct {
+	Version string `json:"version" example:"1.2.0" minLength:"5" maxLength:"64" doc:"The semantic version of the release"`
+	Channel string `json:"channel" example:"stable" enum:"stable,beta,nightly" doc:"The update channel of the release"`
+	Arch    string `json:"arch" example:"aarch64" pattern:"^[a-z0-9_]+$" maxLength:"32" doc:"The CPU architecture of the release"`
+	Url     string `json:"url" example:"https://download.example.com/melo-1.2.0-aarch64.swu" format:"uri" maxLength:"512" doc:"The download URL of the update"`
+	Size    uint64 `json:"size" example:"52428800" doc:"The size of the update in bytes"`
+	Sha256  string `json:"sha256" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" pattern:"^[0-9a-f]{64}$" doc:"The SHA-256 checksum of the update"`
+	Notes   string `json:"notes,omitempty" example:"Bug fixes" maxLength:"4096" doc:"The release notes"`
+	Date    uint64 `json:"date" example:"0" doc:"The publication timestamp as Unix epoch (set on first publication)" required:"false"`
+
+	MinVersion   string `json:"min_version,omitempty" example:"1.0.0" maxLength:"64" doc:"The minimum current version to update from (older devices must install an intermediate release first)"`
+	Signature    string `json:"signature,omitempty" pattern:"^[A-Za-z0-9+/]{86}==$" doc:"The base64 encoded Ed25519 signature of the SHA-256 checksum (32 raw bytes) of the update"`
+	SignatureKey string `json:"signature_key,omitempty" pattern:"^[0-9a-f]{16}$" doc:"The identifier of the artifact key used to sign the update"`
+
+	Rollout      uint8  `json:"rollout" example:"100" minimum:"0" maximum:"100" default:"100" doc:"The percentage of the devices offered the release, selected by the hash of their serial number (set on first publication, then updated with the rollout API)" required:"false"`
+	RolloutState string `json:"rollout_state" example:"active" enum:"active,paused,rolled_back" default:"active" required:"false" doc:"The rollout state: a paused release is not offered anymore, a rolled back release is replaced by the previous release on the devices running it (set on first publication, then updated with the rollout API)"`
+}
+
+// Release manifest (signed payload of GET /release/manifest)
+type Manifest struct {
+	Channel  string    `json:"channel"`
+	Arch     string    `json:"arch"`
+	Date     uint64    `json:"date"`
+	Expires  uint64    `json:"expires"`
+	Releases []Release `json:"releases"`
+}
+
+// Release list
+type releaseListOutput struct {
+	Body []Release
+}
+
+// Signed manifest
+type manifestOutput struct {
+	Body *signing.SignedDocument
+}
+
+// Latest release (no content when the device is up to date)
+type latestReleaseOutput struct {
+	Status int
+	Body   *Release
+}
+
+// latestRelease returns the newest release offered to a device when it is newer than the current
+// version (any when the current version is empty, or when the current release is rolled back): the
+// releases are sorted newest first, and the ones out of the rollout or requiring a newer minimum
+// version than the current one are skipped.
+func latestRelease(releases []Release, current string, serial string) (*Release, error) {
+	// Parse current version
+	var current_version utils.Version
+	rolled_back := false
+	if current != "" {
+		var err error
+		if current_version, err = utils.ParseVersion(current); err != nil {
+			return nil, err
+		}
+		for _, rel := range releases {
+			if rel.Version == current && RolloutState(RolloutStateFromString(rel.RolloutState)) == RolloutRolledBack {
+				rolled_back = true
+			}
+		}
+	}
+
+	// Find newest offered release
+	for index := range releases {
+		if !inRollout(releases[index], serial) {
+			continue
+		} else if current == "" {
+			return &releases[index], nil
+		}
+		version, _ := utils.ParseVersion(releases[index].Version)
+		if version.Compare(current_version) <= 0 && !rolled_back {
+			return nil, nil
+		}
+		if releases[index].MinVersion != "" {
+			min, _ := utils.ParseVersion(releases[index].MinVersion)
+			if current_version.Compare(min) < 0 {
+				continue
+			}
+		}
+		return &releases[index], nil
+	}
+	return nil, nil
+}
+
+// Check a device is registered on the network of the client: the update checks are not
+// authenticated, so only the checks of the registered devices are recorded
+func isRegistered(ctx context.Context, devices device.DeviceStore, serial string) bool {
+	_, err := devices.Get(ctx, middleware.ExtractNetwork(ctx), serial)
+	if err != nil && !errors.Is(err, device.ErrDeviceNotFound) {
+		log.WithFields(log.Fields{"serial": serial, "error": err}).Warn("failed to get device")
+	}
+	return err == nil
+}
+
+func Register(api huma.API, store ReleaseStore, devices device.DeviceStore, client_ip middleware.IpConfig, signer *signing.Signer, manifest_ttl time.Duration) {
+	// Register GET /release/list handler
+	huma.Register(api, huma.Operation{
+		OperationID: "listRelease",
+		Method:      http.MethodGet,
+		Path:        "/release/list",
+		Summary:     "List releases",
+		Description: "List the releases published on a channel (or on a more stable one), newest first.",
+		Tags:        []string{"Release"},
+	}, func(ctx context.Context, input *struct {
+		Channel string `query:"channel" default:"stable" enum:"stable,beta,nightly" doc:"The update channel"`
+		Arch    string `query:"arch" example:"aarch64" pattern:"^[a-z0-9_]+$" maxLength:"32" doc:"The CPU architecture (all when empty)"`
+	}) (*releaseListOutput, error) {
+		// List releases
+		releases, err := store.List(ctx, Channel(ChannelFromString(input.Channel)), input.Arch)
+		if err != nil {
+			return nil, utils.HttpError(err, "")
+		}
+		resp := &releaseListOutput{}
+		resp.Body = releases
+		return resp, nil
+	})
+
+	// Register GET /release/latest handler
+	huma.Register(api, huma.Operation{
+		OperationID: "getLatestRelease",
+		Method:      http.MethodGet,
+		Path:        "/release/latest",
+		Summary:     "Check for update",
+		Description: "Get the newest release of an architecture published on a channel (or on a more stable one). When the current version of the device is set, no content is returned if it is up to date. The releases in partial rollout are only offered to the devices selected by their serial number.",
+		Tags:        []string{"Release"},
+		Errors:      []int{http.StatusNotFound, http.StatusUnprocessableEntity},
+		Middlewares: huma.Middlewares{middleware.GetIpExtractor(client_ip)},
+	}, func(ctx context.Context, input *struct {
+		Channel string `query:"channel" default:"stable" enum:"stable,beta,nightly" doc:"The update channel of the device"`
+		Arch    string `query:"arch" example:"aarch64" pattern:"^[a-z0-9_]+$" maxLength:"32" required:"true" doc:"The CPU architecture of the device"`
+		Current string `query:"current" example:"1.1.0" maxLength:"64" doc:"The current version of the device"`
+		Serial  string `query:"serial" example:"01:23:45:67:89:ab" maxLength:"17" doc:"The serial number of the device, required to be offered the releases in partial rollout"`
+	}) (*latestReleaseOutput, error) {
+		// List releases
+		channel := Channel(ChannelFromString(input.Channel))
+		releases, err := store.List(ctx, channel, input.Arch)
+		if err != nil {
+			return nil, utils.HttpError(err, "")
+		}
+
+		// Find newest release
+		latest, err := latestRelease(releases, input.Current, input.Serial)
+		if err != nil {
+			return nil, huma.Error422UnprocessableEntity("validation failed", &huma.ErrorDetail{
+				Message:  "invalid semantic version",
+				Location: "query.current",
+				Value:    input.Current,
+			})
+		} else if latest == nil && input.Current == "" {
+			return nil, huma.Error404NotFound("no release available")
+		}
+
+		// Record update check of a registered device for the adoption rates
+		if input.Serial != "" && isRegistered(ctx, devices, input.Serial) {
+			check := UpdateCheck{Serial: input.Serial, Arch: input.Arch, Channel: channel, Version: input.Current}
+			if latest != nil {
+				check.Offered = latest.Version
+			}
+			if err := store.AddCheck(ctx, check); err != nil {
+				log.WithFields(log.Fields{"serial": input.Serial, "error": err}).Warn("failed to record update check")
+			}
+		}
+
+		resp := &latestReleaseOutput{Status: http.StatusOK, Body: latest}
+		if latest == nil {
+			resp.Status = http.StatusNoContent
+		}
+		return resp, nil
+	})
+
+	// Manifest is only available with a manifest key
+	if !signer.Enabled() {
+		return
+	}
+
+	// Register GET /release/manifest handler
+	huma.Register(api, huma.Operation{
+		OperationID: "getReleaseManifest",
+		Method:      http.MethodGet,
+		Path:        "/release/manifest",
+		Summary:     "Get signed release manifest",
+		Description: "Get the manifest of the releases of an architecture published on a channel (or on a more stable one), signed with the manifest key. The payload is a JSON document with the releases, newest first, and an expiration date: it can be verified offline with the keys of GET /signing/keys.",
+		Tags:        []string{"Release"},
+	}, func(ctx context.Context, input *struct {
+		Channel string `query:"channel" default:"stable" enum:"stable,beta,nightly" doc:"The update channel of the device"`
+		Arch    string `query:"arch" example:"aarch64" pattern:"^[a-z0-9_]+$" maxLength:"32" required:"true" doc:"The CPU architecture of the device"`
+	}) (*manifestOutput, error) {
+		// List releases
+		channel := Channel(ChannelFromString(input.Channel))
+		releases, err := store.List(ctx, channel, input.Arch)
+		if err != nil {
+			return nil, utils.HttpError(err, "")
+		}
+
+		// Sign manifest
+		now := time.Now()
+		doc, err := signer.Sign(Manifest{
+			Channel:  channel.ToString(),
+			Arch:     input.Arch,
+			Date:     uint64(now.Unix()),
+			Expires:  uint64(now.Add(manifest_ttl).Unix()),
+			Releases: releases,
+		})
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("failed to sign manifest")
+			return nil, huma.Error500InternalServerError("internal error")
+		}
+		return &manifestOutput{Body: doc}, nil
+	})
+}
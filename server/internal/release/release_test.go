@@ -0,0 +1,164 @@
+package release
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// Stores under test: each test runs against the in-memory store and the SQLite store
+var testStores = []struct {
+	name string
+	open func(t *testing.T) ReleaseStore
+}{
+	{"memory", func(t *testing.T) ReleaseStore { return NewMemoryStore() }},
+	{"sqlite", func(t *testing.T) ReleaseStore {
+		db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_pragma=foreign_keys(1)&_txlock=immediate")
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if err := utils.InitializeVersionTable(db, utils.SQLite); err != nil || !InitializeTables(db, utils.SQLite) {
+			t.Fatalf("failed to create release tables: %v", err)
+		}
+		return NewSQLStore(db, utils.SQLite)
+	}},
+}
+
+func TestRolloutBucket(t *testing.T) {
+	// First 8 bytes of SHA-256("<version>:<serial>") modulo 100 (the devices use the same buckets)
+	for _, test := range []struct {
+		version string
+		serial  string
+		want    uint64
+	}{
+		{"1.2.0", "01:23:45:67:89:ab", 54},
+		{"1.2.0", "01:23:45:67:89:ac", 53},
+		{"1.3.0", "01:23:45:67:89:ab", 36},
+		{"2.0.0-beta.1", "serial", 16},
+		{"1.2.0", "", 88},
+	} {
+		if got := rolloutBucket(test.version, test.serial); got != test.want {
+			t.Errorf("rolloutBucket(%q, %q): got %d, want %d", test.version, test.serial, got, test.want)
+		}
+	}
+}
+
+func TestInRollout(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		rel    Release
+		serial string
+		want   bool
+	}{
+		{"full", Release{Version: "1.3.0", Rollout: 100, RolloutState: "active"}, "", true},
+		{"paused", Release{Version: "1.3.0", Rollout: 100, RolloutState: "paused"}, "01:23:45:67:89:ab", false},
+		{"rolled back", Release{Version: "1.3.0", Rollout: 100, RolloutState: "rolled_back"}, "01:23:45:67:89:ab", false},
+		{"none", Release{Version: "1.3.0", Rollout: 0, RolloutState: "active"}, "01:23:45:67:89:ac", false},
+		{"partial without serial", Release{Version: "1.3.0", Rollout: 50, RolloutState: "active"}, "", false},
+		{"partial in", Release{Version: "1.3.0", Rollout: 37, RolloutState: "active"}, "01:23:45:67:89:ab", true},
+		{"partial out", Release{Version: "1.3.0", Rollout: 36, RolloutState: "active"}, "01:23:45:67:89:ab", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := inRollout(test.rel, test.serial); got != test.want {
+				t.Fatalf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLatestRelease(t *testing.T) {
+	// Create a release of the test
+	release := func(version string, min_version string, rollout uint8, state string) Release {
+		return Release{Version: version, MinVersion: min_version, Rollout: rollout, RolloutState: state}
+	}
+	releases := []Release{
+		release("1.3.0", "1.1.0", 100, "active"),
+		release("1.2.0", "", 100, "active"),
+		release("1.1.0", "", 100, "active"),
+		release("1.0.0", "", 100, "active"),
+	}
+
+	for _, test := range []struct {
+		name     string
+		releases []Release
+		current  string
+		serial   string
+		want     string
+		valid    bool
+	}{
+		{"no current version", releases, "", "", "1.3.0", true},
+		{"up to date", releases, "1.3.0", "", "", true},
+		{"newer than latest", releases, "1.4.0", "", "", true},
+		{"update", releases, "1.2.0", "", "1.3.0", true},
+		{"pre-release update", releases, "1.3.0-rc.1", "", "1.3.0", true},
+		{"minimum version", releases, "1.0.0", "", "1.2.0", true},
+		{"invalid current version", releases, "1.0", "", "", false},
+		{"no release", nil, "1.0.0", "", "", true},
+		{"paused", []Release{release("1.3.0", "", 100, "paused"), release("1.2.0", "", 100, "active")}, "1.1.0", "", "1.2.0", true},
+		{"rolled back", []Release{release("1.3.0", "", 100, "rolled_back"), release("1.2.0", "", 100, "active")}, "1.3.0", "", "1.2.0", true},
+		{"rolled back without previous", []Release{release("1.3.0", "", 100, "rolled_back")}, "1.3.0", "", "", true},
+		{"partial rollout in", []Release{release("1.3.0", "", 50, "active"), release("1.2.0", "", 100, "active")}, "1.1.0", "01:23:45:67:89:ab", "1.3.0", true},
+		{"partial rollout out", []Release{release("1.3.0", "", 50, "active"), release("1.2.0", "", 100, "active")}, "1.1.0", "01:23:45:67:89:ad", "1.2.0", true},
+		{"partial rollout without serial", []Release{release("1.3.0", "", 50, "active"), release("1.2.0", "", 100, "active")}, "1.1.0", "", "1.2.0", true},
+		{"partial rollout up to date", []Release{release("1.3.0", "", 50, "active"), release("1.2.0", "", 100, "active")}, "1.2.0", "01:23:45:67:89:ad", "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := latestRelease(test.releases, test.current, test.serial)
+			if !test.valid && err == nil {
+				t.Fatalf("got %+v, want an error", got)
+			} else if test.valid && err != nil {
+				t.Fatalf("got %v, want no error", err)
+			} else if test.want == "" && got != nil {
+				t.Fatalf("got %s, want no release", got.Version)
+			} else if test.want != "" && (got == nil || got.Version != test.want) {
+				t.Fatalf("got %+v, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStoreRepublish(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.open(t)
+			ctx := context.Background()
+			rel := Release{Version: "1.2.0", Channel: "beta", Arch: "aarch64", Url: "https://example.com/a.swu", Rollout: 100, RolloutState: "active"}
+			if err := s.Add(ctx, rel); err != nil {
+				t.Fatalf("failed to add release: %s", err)
+			}
+			if err := s.SetRollout(ctx, "1.2.0", "aarch64", 10, RolloutPaused); err != nil {
+				t.Fatalf("failed to set rollout: %s", err)
+			}
+
+			// Republishing keeps the rollout and updates the other values
+			rel.Url = "https://example.com/b.swu"
+			if err := s.Add(ctx, rel); err != nil {
+				t.Fatalf("failed to republish release: %s", err)
+			}
+			got, err := s.Get(ctx, "1.2.0", "aarch64")
+			if err != nil {
+				t.Fatalf("failed to get release: %s", err)
+			} else if got.Url != rel.Url || got.Rollout != 10 || got.RolloutState != "paused" {
+				t.Fatalf("got %+v, want url %s with paused rollout of 10%%", got, rel.Url)
+			}
+
+			// Channel filter: a beta release is not listed on the stable channel
+			for _, test := range []struct {
+				channel Channel
+				count   int
+			}{
+				{StableChannel, 0},
+				{BetaChannel, 1},
+				{NightlyChannel, 1},
+			} {
+				if list, err := s.List(ctx, test.channel, "aarch64"); err != nil || len(list) != test.count {
+					t.Fatalf("list %s: got %d releases, %v, want %d", test.channel.ToString(), len(list), err, test.count)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package release
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+type RolloutState uint
+
+const (
+	RolloutActive RolloutState = iota
+	RolloutPaused
+	RolloutRolledBack
+)
+
+var rolloutStateMap = [...]string{"active", "paused", "rolled_back"}
+
+func (s RolloutState) ToString() string {
+	if int(s) < len(rolloutStateMap) {
+		return rolloutStateMap[s]
+	}
+	return rolloutStateMap[0]
+}
+
+func RolloutStateFromString(str string) uint {
+	for index := range rolloutStateMap {
+		if rolloutStateMap[index] == str {
+			return uint(index)
+		}
+	}
+	return 0
+}
+
+// Last update check of a device
+type UpdateCheck struct {
+	Serial  string
+	Arch    string
+	Channel Channel
+	Version string
+	Offered string
+	Date    uint64
+}
+
+// Adoption of a release by the devices
+type Adoption struct {
+	Version      string  `json:"version" example:"1.2.0" doc:"The semantic version of the release"`
+	Arch         string  `json:"arch" example:"aarch64" doc:"The CPU architecture of the release"`
+	Rollout      uint8   `json:"rollout" example:"25" doc:"The rollout percentage of the release"`
+	RolloutState string  `json:"rollout_state" example:"active" enum:"active,paused,rolled_back" doc:"The rollout state of the release"`
+	Devices      uint64  `json:"devices" example:"120" doc:"The number of devices of the architecture which checked for update on the release channel or on a less stable one"`
+	Offered      uint64  `json:"offered" example:"30" doc:"The number of devices offered the release on their last update check"`
+	Installed    uint64  `json:"installed" example:"24" doc:"The number of devices running the release on their last update check"`
+	Rate         float64 `json:"rate" example:"20" doc:"The adoption rate: the percentage of the devices running the release"`
+}
+
+// Compute the adoption rate
+func (a *Adoption) setRate() {
+	if a.Devices > 0 {
+		a.Rate = float64(a.Installed) * 100 / float64(a.Devices)
+	}
+}
+
+// rolloutBucket returns the rollout bucket (0 to 99) of a device for a release: the first 8 bytes
+// of the SHA-256 of "<version>:<serial>" as big endian integer, modulo 100. A device is in the
+// rollout of a release when its bucket is lower than the rollout percentage.
+func rolloutBucket(version string, serial string) uint64 {
+	sum := sha256.Sum256([]byte(version + ":" + serial))
+	return binary.BigEndian.Uint64(sum[:8]) % 100
+}
+
+// inRollout returns true when a release is offered to a device (the partial rollouts are only
+// offered to the devices sending their serial number)
+func inRollout(rel Release, serial string) bool {
+	if RolloutState(RolloutStateFromString(rel.RolloutState)) != RolloutActive {
+		return false
+	} else if rel.Rollout >= 100 {
+		return true
+	}
+	return serial != "" && rolloutBucket(rel.Version, serial) < uint64(rel.Rollout)
+}
@@ -0,0 +1,46 @@
+package release
+
+import (
+	"context"
+	"sort"
+
+	"github.com/dillya/melo-webapi/internal/utils"
+)
+
+// ReleaseStore is the storage backend of the Melo releases.
+//
+// A release is identified by its version and its architecture. The errors are ErrReleaseNotFound,
+// utils.ErrUnavailable, a *utils.ValidationError, a context error or another database error.
+type ReleaseStore interface {
+	// List the releases of an architecture (all when empty) published on the channel or on a more
+	// stable one, newest first
+	List(ctx context.Context, channel Channel, arch string) ([]Release, error)
+	// Get a release
+	Get(ctx context.Context, version string, arch string) (Release, error)
+	// Publish a release or replace it (the publication date and the rollout are kept, see SetRollout)
+	Add(ctx context.Context, rel Release) error
+	// Remove a release
+	Remove(ctx context.Context, version string, arch string) error
+	// Update the rollout percentage and state of a release
+	SetRollout(ctx context.Context, version string, arch string, rollout uint8, state RolloutState) error
+	// Record the update check of a device (replacing its previous check)
+	AddCheck(ctx context.Context, check UpdateCheck) error
+	// Get the adoption of a release by the devices which checked for update since a timestamp
+	GetAdoption(ctx context.Context, version string, arch string, since uint64) (Adoption, error)
+	// Remove the update checks older than the timestamp, and return their count
+	PurgeChecks(ctx context.Context, before uint64) (int64, error)
+}
+
+// Sort releases newest first (by architecture for a same version)
+func sortReleases(list []Release) {
+	versions := map[string]utils.Version{}
+	for _, rel := range list {
+		versions[rel.Version], _ = utils.ParseVersion(rel.Version)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if c := versions[list[i].Version].Compare(versions[list[j].Version]); c != 0 {
+			return c > 0
+		}
+		return list[i].Arch < list[j].Arch
+	})
+}
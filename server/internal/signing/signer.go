@@ -0,0 +1,117 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Signature of a document by a key
+type Signature struct {
+	KeyId string `json:"key_id" example:"3f2a9c0d1e4b5a67" doc:"The identifier of the signing key"`
+	Value []byte `json:"sig" doc:"The base64 encoded Ed25519 signature of the payload"`
+}
+
+// SignedDocument is a JSON document signed with Ed25519: the signatures are computed on the exact
+// payload bytes which must be verified before being decoded.
+type SignedDocument struct {
+	Payload    []byte      `json:"payload" doc:"The base64 encoded JSON document"`
+	Signatures []Signature `json:"signatures" doc:"The signatures of the payload"`
+}
+
+// Signer signs the documents with the manifest key, and verifies the artifact signatures with the
+// artifact keys: the artifacts are signed offline, so only their public keys are configured. The
+// first artifact key is the active one, the next ones are the previous keys, still accepted during a
+// key rotation.
+//
+// During a rotation of the manifest key, the documents are also signed by the previous manifest
+// keys, so the devices trusting a previous key can verify the key list and learn the new key.
+type Signer struct {
+	key           ed25519.PrivateKey
+	previous_keys []ed25519.PrivateKey
+	artifact_keys []ed25519.PublicKey
+}
+
+// KeyId returns the identifier of a public key: the first 8 bytes of its SHA-256 as hexadecimal
+func KeyId(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewSigner creates a signer from the manifest key (signing disabled when nil), the previous manifest
+// keys still signing the documents during a rotation, and the artifact keys, the active key first
+// (verification disabled when empty)
+func NewSigner(key ed25519.PrivateKey, previous_keys []ed25519.PrivateKey, artifact_keys []ed25519.PublicKey) *Signer {
+	return &Signer{key: key, previous_keys: previous_keys, artifact_keys: artifact_keys}
+}
+
+// Enabled returns true when the manifest key is configured
+func (s *Signer) Enabled() bool {
+	return s != nil && s.key != nil
+}
+
+// PublicKey returns the public key of the manifest key
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// PreviousKeys returns the public keys of the previous manifest keys
+func (s *Signer) PreviousKeys() []ed25519.PublicKey {
+	keys := []ed25519.PublicKey{}
+	if s.Enabled() {
+		for _, key := range s.previous_keys {
+			keys = append(keys, key.Public().(ed25519.PublicKey))
+		}
+	}
+	return keys
+}
+
+// VerifyEnabled returns true when at least one artifact key is configured
+func (s *Signer) VerifyEnabled() bool {
+	return s != nil && len(s.artifact_keys) > 0
+}
+
+// ArtifactKeys returns the artifact keys, the active key first
+func (s *Signer) ArtifactKeys() []ed25519.PublicKey {
+	if s == nil {
+		return []ed25519.PublicKey{}
+	}
+	return s.artifact_keys
+}
+
+// ArtifactKey returns the artifact key of an identifier (nil when not configured)
+func (s *Signer) ArtifactKey(id string) ed25519.PublicKey {
+	for _, key := range s.ArtifactKeys() {
+		if KeyId(key) == id {
+			return key
+		}
+	}
+	return nil
+}
+
+// Verify checks the signature of an artifact by an artifact key: the signed message is the raw
+// SHA-256 digest (32 bytes) of the artifact, so a large file is not read twice.
+func (s *Signer) Verify(id string, digest []byte, signature []byte) bool {
+	key := s.ArtifactKey(id)
+	return key != nil && len(digest) == sha256.Size && ed25519.Verify(key, digest, signature)
+}
+
+// Sign encodes a value as JSON and signs it with the manifest key, then with the previous manifest
+// keys
+func (s *Signer) Sign(value any) (*SignedDocument, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	doc := &SignedDocument{Payload: payload, Signatures: []Signature{}}
+	if s.Enabled() {
+		for _, key := range append([]ed25519.PrivateKey{s.key}, s.previous_keys...) {
+			doc.Signatures = append(doc.Signatures, Signature{
+				KeyId: KeyId(key.Public().(ed25519.PublicKey)),
+				Value: ed25519.Sign(key, payload),
+			})
+		}
+	}
+	return doc, nil
+}
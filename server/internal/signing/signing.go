@@ -0,0 +1,80 @@
+package signing
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Public signing key
+type PublicKey struct {
+	Id        string `json:"id" example:"3f2a9c0d1e4b5a67" doc:"The identifier of the key"`
+	PublicKey string `json:"public_key" example:"11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=" doc:"The base64 encoded Ed25519 public key"`
+	Usage     string `json:"usage" enum:"manifest,artifact" doc:"The documents signed by the key: the manifests (signed by the server) or the artifacts (signed offline)"`
+	Active    bool   `json:"active" doc:"The key signs the new documents (the other keys are previous keys still signing the manifests or accepted for the artifacts)"`
+}
+
+// Key list (signed payload of GET /signing/keys)
+type KeyList struct {
+	Keys []PublicKey `json:"keys"`
+	Date uint64      `json:"date"`
+}
+
+// Signed document output
+type signedOutput struct {
+	Body *SignedDocument
+}
+
+func Register(api huma.API, signer *Signer) {
+	// Signing is disabled
+	if !signer.Enabled() {
+		return
+	}
+
+	// Register GET /signing/keys handler
+	huma.Register(api, huma.Operation{
+		OperationID: "getSigningKeys",
+		Method:      http.MethodGet,
+		Path:        "/signing/keys",
+		Summary:     "Get signing keys",
+		Description: "Get the public keys used to sign the manifests and the artifacts, the active manifest key first, the previous manifest keys, then the active artifact key. The list is signed by the manifest key and by the previous manifest keys during a rotation: a client trusting one of them can verify it and learn the new manifest key and the artifact keys.",
+		Tags:        []string{"Signing"},
+	}, func(ctx context.Context, input *struct{}) (*signedOutput, error) {
+		// Generate key list
+		list := KeyList{Keys: []PublicKey{}, Date: uint64(time.Now().Unix())}
+		list.Keys = append(list.Keys, PublicKey{
+			Id:        KeyId(signer.PublicKey()),
+			PublicKey: base64.StdEncoding.EncodeToString(signer.PublicKey()),
+			Usage:     "manifest",
+			Active:    true,
+		})
+		for _, key := range signer.PreviousKeys() {
+			list.Keys = append(list.Keys, PublicKey{
+				Id:        KeyId(key),
+				PublicKey: base64.StdEncoding.EncodeToString(key),
+				Usage:     "manifest",
+			})
+		}
+		for index, key := range signer.ArtifactKeys() {
+			list.Keys = append(list.Keys, PublicKey{
+				Id:        KeyId(key),
+				PublicKey: base64.StdEncoding.EncodeToString(key),
+				Usage:     "artifact",
+				Active:    index == 0,
+			})
+		}
+
+		// Sign key list with the manifest keys
+		doc, err := signer.Sign(list)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("failed to sign key list")
+			return nil, huma.Error500InternalServerError("internal error")
+		}
+		return &signedOutput{Body: doc}, nil
+	})
+}
@@ -0,0 +1,147 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2/humatest"
+)
+
+// Create a key of the tests from a seed byte
+func testKey(seed byte) ed25519.PrivateKey {
+	data := make([]byte, ed25519.SeedSize)
+	data[0] = seed
+	return ed25519.NewKeyFromSeed(data)
+}
+
+// Public key of a test key
+func publicKey(key ed25519.PrivateKey) ed25519.PublicKey {
+	return key.Public().(ed25519.PublicKey)
+}
+
+// Check the signatures of a document are made by the keys, in order
+func checkSignatures(t *testing.T, doc *SignedDocument, keys ...ed25519.PublicKey) {
+	t.Helper()
+	if len(doc.Signatures) != len(keys) {
+		t.Fatalf("got %d signatures, want %d", len(doc.Signatures), len(keys))
+	}
+	for i, key := range keys {
+		if signature := doc.Signatures[i]; signature.KeyId != KeyId(key) {
+			t.Fatalf("signature %d: got key %s, want %s", i, signature.KeyId, KeyId(key))
+		} else if !ed25519.Verify(key, doc.Payload, signature.Value) {
+			t.Fatalf("signature %d: invalid signature", i)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	active, previous, other := testKey(1), testKey(2), testKey(3)
+	for _, test := range []struct {
+		name   string
+		signer *Signer
+		keys   []ed25519.PublicKey
+	}{
+		{"disabled", NewSigner(nil, nil, nil), []ed25519.PublicKey{}},
+		{"nil signer", nil, []ed25519.PublicKey{}},
+		{"single key", NewSigner(active, nil, nil), []ed25519.PublicKey{publicKey(active)}},
+		{"rotation", NewSigner(active, []ed25519.PrivateKey{previous}, nil), []ed25519.PublicKey{publicKey(active), publicKey(previous)}},
+		{"many previous keys", NewSigner(active, []ed25519.PrivateKey{previous, other}, nil), []ed25519.PublicKey{publicKey(active), publicKey(previous), publicKey(other)}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			doc, err := test.signer.Sign(map[string]string{"version": "1.2.0"})
+			if err != nil {
+				t.Fatalf("failed to sign document: %s", err)
+			} else if string(doc.Payload) != `{"version":"1.2.0"}` {
+				t.Fatalf("got payload %s", doc.Payload)
+			}
+			checkSignatures(t, doc, test.keys...)
+
+			// A modified payload is rejected
+			doc.Payload = []byte(`{"version":"6.6.6"}`)
+			for i, key := range test.keys {
+				if ed25519.Verify(key, doc.Payload, doc.Signatures[i].Value) {
+					t.Fatalf("signature %d: modified payload is verified", i)
+				}
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	active, previous, unknown := testKey(1), testKey(2), testKey(3)
+	signer := NewSigner(nil, nil, []ed25519.PublicKey{publicKey(active), publicKey(previous)})
+	digest := sha256.Sum256([]byte("artifact"))
+	other := sha256.Sum256([]byte("other artifact"))
+
+	for _, test := range []struct {
+		name      string
+		id        string
+		digest    []byte
+		signature []byte
+		want      bool
+	}{
+		{"active key", KeyId(publicKey(active)), digest[:], ed25519.Sign(active, digest[:]), true},
+		{"previous key", KeyId(publicKey(previous)), digest[:], ed25519.Sign(previous, digest[:]), true},
+		{"unknown key", KeyId(publicKey(unknown)), digest[:], ed25519.Sign(unknown, digest[:]), false},
+		{"other key id", KeyId(publicKey(active)), digest[:], ed25519.Sign(previous, digest[:]), false},
+		{"other artifact", KeyId(publicKey(active)), other[:], ed25519.Sign(active, digest[:]), false},
+		{"hex digest", KeyId(publicKey(active)), []byte("abcd"), ed25519.Sign(active, []byte("abcd")), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := signer.Verify(test.id, test.digest, test.signature); got != test.want {
+				t.Fatalf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+
+	// Verification is disabled without artifact keys
+	if NewSigner(active, nil, nil).VerifyEnabled() {
+		t.Fatal("verification is enabled without artifact keys")
+	}
+}
+
+func TestKeyList(t *testing.T) {
+	active, previous, artifact := testKey(1), testKey(2), testKey(3)
+	_, api := humatest.New(t)
+	Register(api, NewSigner(active, []ed25519.PrivateKey{previous}, []ed25519.PublicKey{publicKey(artifact)}))
+
+	// The key list is signed by the active and the previous manifest keys
+	resp := api.Get("/signing/keys")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", resp.Code, http.StatusOK)
+	}
+	var doc SignedDocument
+	if err := json.Unmarshal(resp.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid signed document: %s", err)
+	}
+	checkSignatures(t, &doc, publicKey(active), publicKey(previous))
+
+	// The manifest keys are listed first, then the artifact keys
+	var list KeyList
+	if err := json.Unmarshal(doc.Payload, &list); err != nil {
+		t.Fatalf("invalid key list: %s", err)
+	}
+	want := []PublicKey{
+		{Id: KeyId(publicKey(active)), Usage: "manifest", Active: true},
+		{Id: KeyId(publicKey(previous)), Usage: "manifest", Active: false},
+		{Id: KeyId(publicKey(artifact)), Usage: "artifact", Active: true},
+	}
+	if len(list.Keys) != len(want) {
+		t.Fatalf("got %d keys, want %d", len(list.Keys), len(want))
+	}
+	for i, key := range list.Keys {
+		if key.Id != want[i].Id || key.Usage != want[i].Usage || key.Active != want[i].Active {
+			t.Fatalf("key %d: got %+v, want %+v", i, key, want[i])
+		}
+	}
+
+	// Disabled signing has no key list
+	_, api = humatest.New(t)
+	Register(api, NewSigner(nil, nil, []ed25519.PublicKey{publicKey(artifact)}))
+	if resp := api.Get("/signing/keys"); resp.Code != http.StatusNotFound {
+		t.Fatalf("disabled signing: got status %d, want %d", resp.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+type Dialect uint
+
+const (
+	MySQL Dialect = iota
+	SQLite
+	PostgreSQL
+)
+
+var dialectMap = [...]string{"mysql", "sqlite", "postgres"}
+
+func (d Dialect) ToString() string {
+	if int(d) < len(dialectMap) {
+		return dialectMap[d]
+	}
+	return dialectMap[0]
+}
+
+func DialectFromString(str string) (Dialect, bool) {
+	for index := range dialectMap {
+		if dialectMap[index] == str {
+			return Dialect(index), true
+		}
+	}
+	return MySQL, false
+}
+
+// Rebind converts the '?' placeholders of the query to the dialect ones
+func (d Dialect) Rebind(query string) string {
+	if d != PostgreSQL {
+		return query
+	}
+
+	// Replace placeholders with numbered ones
+	var builder strings.Builder
+	index := 0
+	for _, c := range query {
+		if c == '?' {
+			index++
+			builder.WriteString("$" + strconv.Itoa(index))
+		} else {
+			builder.WriteRune(c)
+		}
+	}
+	return builder.String()
+}
+
+// Upsert returns the clause updating the columns when the unique key is already present
+func (d Dialect) Upsert(key []string, columns ...string) string {
+	set := make([]string, len(columns))
+	switch d {
+	case SQLite, PostgreSQL:
+		for index, column := range columns {
+			set[index] = column + "=excluded." + column
+		}
+		return "ON CONFLICT (" + strings.Join(key, ", ") + ") DO UPDATE SET " + strings.Join(set, ", ")
+	default:
+		for index, column := range columns {
+			set[index] = column + "=VALUES(" + column + ")"
+		}
+		return "ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+	}
+}
+
+// InetAton converts an IPv4 address string to its column value (NULL for an empty string)
+func (d Dialect) InetAton(expr string) string {
+	if d == PostgreSQL {
+		return "CAST(NULLIF(" + expr + ", '') AS inet)"
+	}
+	return "INET_ATON(" + expr + ")"
+}
+
+// InetNtoa converts an IPv4 address column value to its string
+func (d Dialect) InetNtoa(expr string) string {
+	if d == PostgreSQL {
+		return "host(" + expr + ")"
+	}
+	return "INET_NTOA(" + expr + ")"
+}
+
+// Inet6Aton converts an IPv4 / IPv6 address string to its column value (NULL for an empty string)
+func (d Dialect) Inet6Aton(expr string) string {
+	if d == PostgreSQL {
+		return "CAST(NULLIF(" + expr + ", '') AS inet)"
+	}
+	return "INET6_ATON(" + expr + ")"
+}
+
+// Inet6Ntoa converts an IPv4 / IPv6 address column value to its string
+func (d Dialect) Inet6Ntoa(expr string) string {
+	if d == PostgreSQL {
+		return "host(" + expr + ")"
+	}
+	return "INET6_NTOA(" + expr + ")"
+}
+
+// MacAton converts a MAC address integer (see Uint64FromHwAddress) to its column value
+func (d Dialect) MacAton(expr string) string {
+	if d == PostgreSQL {
+		return "CAST(lpad(to_hex(CAST(" + expr + " AS bigint)), 12, '0') AS macaddr)"
+	}
+	return expr
+}
+
+// MacNtoa converts a MAC address column value to its integer (see Uint64ToHwAddress)
+func (d Dialect) MacNtoa(expr string) string {
+	if d == PostgreSQL {
+		return "CAST(CAST('x' || lpad(replace(CAST(" + expr + " AS text), ':', ''), 16, '0') AS bit(64)) AS bigint)"
+	}
+	return expr
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Errors shared by the stores: a store wraps ErrNotFound in its own errors (as "device not found")
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrUnavailable = errors.New("storage unavailable")
+)
+
+// Status code of a request canceled by the client (no response is received)
+const StatusClientClosedRequest = 499
+
+// ValidationError is returned by the stores when a value is invalid
+type ValidationError struct {
+	Location string
+	Value    any
+	Message  string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Location, e.Message)
+}
+
+// ValidationErrors is returned by the stores when many values are invalid
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	list := []string{}
+	for _, err := range e {
+		list = append(list, err.Error())
+	}
+	return strings.Join(list, ", ")
+}
+
+func (e ValidationErrors) Unwrap() []error {
+	list := []error{}
+	for _, err := range e {
+		list = append(list, err)
+	}
+	return list
+}
+
+// DbError converts a database error: the context error when the request is canceled or timed out
+// (the database is not faulty), and ErrUnavailable when the database cannot be pinged.
+func DbError(ctx context.Context, db *sql.DB, err error) error {
+	if ctx_err := ctx.Err(); ctx_err != nil {
+		return ctx_err
+	}
+	if ping := db.PingContext(ctx); ping != nil {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
+
+// HttpError converts a store error to a Huma error model: the prefix is prepended to the location of
+// a validation error (as "body."), and an error already converted is returned as is.
+func HttpError(err error, prefix string) error {
+	var serr huma.StatusError
+	var verrs ValidationErrors
+	var verr *ValidationError
+	switch {
+	case errors.As(err, &serr):
+		return err
+	case errors.As(err, &verrs):
+		details := []error{}
+		for _, verr := range verrs {
+			details = append(details, &huma.ErrorDetail{
+				Message:  verr.Message,
+				Location: prefix + verr.Location,
+				Value:    verr.Value,
+			})
+		}
+		return huma.Error422UnprocessableEntity("validation failed", details...)
+	case errors.As(err, &verr):
+		return huma.Error422UnprocessableEntity("validation failed", &huma.ErrorDetail{
+			Message:  verr.Message,
+			Location: prefix + verr.Location,
+			Value:    verr.Value,
+		})
+	case errors.Is(err, ErrNotFound):
+		return huma.Error404NotFound(err.Error())
+	case errors.Is(err, context.Canceled):
+		// The client is gone: nothing to log
+		return huma.NewError(StatusClientClosedRequest, "request canceled")
+	case errors.Is(err, context.DeadlineExceeded):
+		log.WithFields(log.Fields{"error": err}).Warn("request timed out")
+		return huma.Error504GatewayTimeout("request timed out")
+	case errors.Is(err, ErrUnavailable):
+		log.WithFields(log.Fields{"error": err}).Error("storage unavailable")
+		return huma.Error503ServiceUnavailable("storage unavailable")
+	default:
+		log.WithFields(log.Fields{"error": err}).Error("unexpected storage error")
+		return huma.Error500InternalServerError("internal error")
+	}
+}
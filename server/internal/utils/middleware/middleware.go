@@ -2,24 +2,155 @@ package middleware
 
 import (
 	"context"
-	"os"
+	"net"
+	"net/http"
+	"net/netip"
 	"strings"
 
 	"github.com/danielgtaylor/huma/v2"
 )
 
-func GetIpExtractor() func(ctx huma.Context, next func(huma.Context)) {
-	// Get proxy IP address header from environment
-	http_header := os.Getenv("MELO_WEBAPI_REAL_IP_HEADER")
+// IpConfig is the client IP extraction configuration
+type IpConfig struct {
+	// HTTP header to read the real IP address of the client from, and the proxies allowed to set it:
+	// the header is ignored for requests coming from any other peer.
+	//
+	// The header can be:
+	//   - "X-Forwarded-For": a comma separated list of addresses, appended by each proxy,
+	//   - "Forwarded": the RFC 7239 header, where the "for" parameters are used,
+	//   - any other header (like "X-Real-Ip"), holding a single address.
+	RealIpHeader   string
+	TrustedProxies []*net.IPNet
+
+	// Prefix length of the client IPv6 networks: unlike IPv4 clients sharing the public address of
+	// their NAT, each IPv6 client of a home network has its own address within the prefix delegated
+	// by the ISP (usually a /56 or a /64).
+	Ipv6Prefix int
+}
+
+func isTrustedProxy(proxies []*net.IPNet, addr netip.Addr) bool {
+	for _, network := range proxies {
+		if network.Contains(addr.AsSlice()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse an IP address with optional port (IPv6 within brackets when a port is set)
+func parseAddr(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+
+	// IPv4-mapped IPv6 addresses are converted to IPv4, zones are dropped
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// Get the "for" parameters of RFC 7239 Forwarded header values
+func parseForwarded(values []string) []string {
+	nodes := []string{}
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name, node, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(name, "for") {
+					nodes = append(nodes, strings.Trim(node, "\""))
+				}
+			}
+		}
+	}
+	return nodes
+}
+
+// Get the list of forwarded addresses (client first) from the request headers
+func getForwarded(http_header string, header http.Header) []string {
+	values := header.Values(http_header)
+	switch http_header {
+	case "Forwarded":
+		return parseForwarded(values)
+	case "X-Forwarded-For":
+		nodes := []string{}
+		for _, value := range values {
+			nodes = append(nodes, strings.Split(value, ",")...)
+		}
+		return nodes
+	default:
+		// Single value header: only the last one is set by the trusted proxy
+		if len(values) == 0 {
+			return nil
+		}
+		return values[len(values)-1:]
+	}
+}
+
+// Get client IP address: the forwarded addresses are walked from right to left while they are
+// trusted proxies, so an address added by the client itself is never used.
+func (c *IpConfig) getIp(header http.Header, remote_addr string) string {
+	addr, ok := parseAddr(remote_addr)
+	if !ok {
+		return remote_addr
+	}
+	if c.RealIpHeader == "" || !isTrustedProxy(c.TrustedProxies, addr) {
+		return addr.String()
+	}
+
+	nodes := getForwarded(http.CanonicalHeaderKey(c.RealIpHeader), header)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		// Stop on invalid or obfuscated address ("unknown", "_hidden", ...)
+		node, ok := parseAddr(nodes[i])
+		if !ok {
+			break
+		}
+		addr = node
+		if !isTrustedProxy(c.TrustedProxies, addr) {
+			break
+		}
+	}
+
+	return addr.String()
+}
+
+// Set the client IP address and its network in the context
+func (c *IpConfig) withClient(ctx context.Context, ip string) context.Context {
+	ctx = context.WithValue(ctx, "remote-ip", ip)
+	return context.WithValue(ctx, "remote-network", c.NetworkFromIp(ip))
+}
+
+func GetIpExtractor(cfg IpConfig) func(ctx huma.Context, next func(huma.Context)) {
+	// Get proxy IP address header
+	http_header := http.CanonicalHeaderKey(cfg.RealIpHeader)
 
 	// Create closure for client IP extract
 	return func(ctx huma.Context, next func(huma.Context)) {
-		if ip := ctx.Header(http_header); ip != "" {
-			ctx = huma.WithValue(ctx, "remote-ip", ip)
-		} else {
-			ctx = huma.WithValue(ctx, "remote-ip", strings.Split(ctx.RemoteAddr(), ":")[0])
+		// Get all the values of the header (set by each proxy)
+		header := http.Header{}
+		if http_header != "" {
+			ctx.EachHeader(func(name, value string) {
+				if http.CanonicalHeaderKey(name) == http_header {
+					header.Add(http_header, value)
+				}
+			})
 		}
-		next(ctx)
+		ip := cfg.getIp(header, ctx.RemoteAddr())
+		next(huma.WithContext(ctx, cfg.withClient(ctx.Context(), ip)))
+	}
+}
+
+// GetHttpIpExtractor is the same as GetIpExtractor for handlers registered out of Huma
+func GetHttpIpExtractor(cfg IpConfig) func(next http.Handler) http.Handler {
+	// Create closure for client IP extract
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := cfg.getIp(r.Header, r.RemoteAddr)
+			next.ServeHTTP(w, r.WithContext(cfg.withClient(r.Context(), ip)))
+		})
 	}
 }
 
@@ -28,3 +159,27 @@ func ExtractIp(ctx context.Context) string {
 	ip, _ := ctx.Value("remote-ip").(string)
 	return ip
 }
+
+// NetworkFromIp returns the network of a client IP address, which scopes the devices: the address
+// itself for IPv4, the first address of the prefix for IPv6. The IP address is returned unchanged
+// when invalid.
+func (c *IpConfig) NetworkFromIp(ip string) string {
+	addr, ok := parseAddr(ip)
+	if !ok {
+		return ip
+	} else if addr.Is4() {
+		return addr.String()
+	}
+
+	prefix, err := addr.Prefix(c.Ipv6Prefix)
+	if err != nil {
+		return addr.String()
+	}
+	return prefix.Addr().String()
+}
+
+func ExtractNetwork(ctx context.Context) string {
+	// Get network of the remote
+	network, _ := ctx.Value("remote-network").(string)
+	return network
+}
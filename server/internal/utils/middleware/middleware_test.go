@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Parse the networks of a test
+func parseNetworks(t *testing.T, cidrs ...string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("invalid network %q: %s", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func TestGetIp(t *testing.T) {
+	proxies := parseNetworks(t, "10.0.0.0/8", "fd00::/8")
+	for _, test := range []struct {
+		name           string
+		real_ip_header string
+		header         http.Header
+		remote_addr    string
+		want           string
+	}{
+		{"no header", "", nil, "1.2.3.4:1234", "1.2.3.4"},
+		{"ipv6 remote", "", nil, "[2001:db8::1]:1234", "2001:db8::1"},
+		{"ipv4-mapped remote", "", nil, "[::ffff:1.2.3.4]:1234", "1.2.3.4"},
+		{"zone remote", "", nil, "[fe80::1%eth0]:1234", "fe80::1"},
+		{"invalid remote", "", nil, "invalid", "invalid"},
+		{"header not configured", "", http.Header{"X-Real-Ip": {"5.6.7.8"}}, "10.0.0.1:1234", "10.0.0.1"},
+		{"other header", "X-Real-Ip", http.Header{"X-Forwarded-For": {"5.6.7.8"}}, "10.0.0.1:1234", "10.0.0.1"},
+		{"untrusted peer", "X-Real-Ip", http.Header{"X-Real-Ip": {"5.6.7.8"}}, "1.2.3.4:1234", "1.2.3.4"},
+		{"real ip", "X-Real-Ip", http.Header{"X-Real-Ip": {"5.6.7.8"}}, "10.0.0.1:1234", "5.6.7.8"},
+		{"real ip last value", "X-Real-Ip", http.Header{"X-Real-Ip": {"6.6.6.6", "5.6.7.8"}}, "10.0.0.1:1234", "5.6.7.8"},
+		{"real ip invalid", "X-Real-Ip", http.Header{"X-Real-Ip": {"unknown"}}, "10.0.0.1:1234", "10.0.0.1"},
+		{"forwarded-for", "X-Forwarded-For", http.Header{"X-Forwarded-For": {"5.6.7.8"}}, "10.0.0.1:1234", "5.6.7.8"},
+		{"forwarded-for spoofed", "X-Forwarded-For", http.Header{"X-Forwarded-For": {"6.6.6.6, 5.6.7.8"}}, "10.0.0.1:1234", "5.6.7.8"},
+		{"forwarded-for proxies", "X-Forwarded-For", http.Header{"X-Forwarded-For": {"6.6.6.6, 5.6.7.8, 10.0.0.2", "10.0.0.3"}}, "10.0.0.1:1234", "5.6.7.8"},
+		{"forwarded-for only proxies", "X-Forwarded-For", http.Header{"X-Forwarded-For": {"10.0.0.3"}}, "10.0.0.1:1234", "10.0.0.3"},
+		{"forwarded-for ipv6", "X-Forwarded-For", http.Header{"X-Forwarded-For": {"2001:db8::1"}}, "[fd00::1]:1234", "2001:db8::1"},
+		{"forwarded-for obfuscated", "X-Forwarded-For", http.Header{"X-Forwarded-For": {"5.6.7.8, unknown"}}, "10.0.0.1:1234", "10.0.0.1"},
+		{"forwarded", "Forwarded", http.Header{"Forwarded": {`for=5.6.7.8;proto=https`}}, "10.0.0.1:1234", "5.6.7.8"},
+		{"forwarded ipv6 port", "Forwarded", http.Header{"Forwarded": {`for="[2001:db8::1]:4711"`}}, "10.0.0.1:1234", "2001:db8::1"},
+		{"forwarded spoofed", "Forwarded", http.Header{"Forwarded": {`for=6.6.6.6, for=5.6.7.8`, `For=10.0.0.2`}}, "10.0.0.1:1234", "5.6.7.8"},
+		{"forwarded hidden", "Forwarded", http.Header{"Forwarded": {`for=_hidden`}}, "10.0.0.1:1234", "10.0.0.1"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := IpConfig{RealIpHeader: test.real_ip_header, TrustedProxies: proxies}
+			if got := cfg.getIp(test.header, test.remote_addr); got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHttpIpExtractor(t *testing.T) {
+	cfg := IpConfig{RealIpHeader: "x-forwarded-for", TrustedProxies: parseNetworks(t, "10.0.0.0/8"), Ipv6Prefix: 56}
+
+	// Extract client of a proxied request
+	ip, network := "", ""
+	handler := GetHttpIpExtractor(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, network = ExtractIp(r.Context()), ExtractNetwork(r.Context())
+	}))
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	request.Header.Set("X-Forwarded-For", "2001:db8:0:12::1")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if ip != "2001:db8:0:12::1" || network != "2001:db8::" {
+		t.Fatalf("got %q and network %q, want %q and network %q", ip, network, "2001:db8:0:12::1", "2001:db8::")
+	}
+}
+
+func TestNetworkFromIp(t *testing.T) {
+	for _, test := range []struct {
+		prefix int
+		ip     string
+		want   string
+	}{
+		{64, "1.2.3.4", "1.2.3.4"},
+		{64, "::ffff:1.2.3.4", "1.2.3.4"},
+		{64, "2001:db8:1:2:3:4:5:6", "2001:db8:1:2::"},
+		{56, "2001:db8:1:2ff:3:4:5:6", "2001:db8:1:200::"},
+		{48, "2001:db8:1:2:3:4:5:6", "2001:db8:1::"},
+		{128, "2001:db8::1", "2001:db8::1"},
+		{200, "2001:db8::1", "2001:db8::1"},
+		{64, "invalid", "invalid"},
+	} {
+		t.Run(test.ip, func(t *testing.T) {
+			cfg := IpConfig{Ipv6Prefix: test.prefix}
+			if got := cfg.NetworkFromIp(test.ip); got != test.want {
+				t.Fatalf("prefix %d: got %q, want %q", test.prefix, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrDatabaseTooNew is returned when the database schema is newer than the one supported by the
+// binary: it must be upgraded before being started on this database.
+var ErrDatabaseTooNew = errors.New("database schema is newer than supported")
+
+// Migration is a single schema upgrade step of a table family
+type Migration struct {
+	Version     uint
+	Description string
+	Up          func(tx *sql.Tx) error
+}
+
+// Statements creates a migration step function executing the SQL statements in order
+func Statements(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Querier runs a single row query on a database or within a transaction
+type Querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// GetTableVersion returns the version of a table family (0 when it has not been created yet)
+func GetTableVersion(q Querier, dialect Dialect, name string) (uint, error) {
+	var version uint
+	err := q.QueryRow(dialect.Rebind("SELECT version FROM version WHERE name = ?"), name).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
+
+func updateTableVersion(tx *sql.Tx, dialect Dialect, name string, version uint) error {
+	_, err := tx.Exec(dialect.Rebind("INSERT INTO version (name, version) VALUES(?, ?) "+dialect.Upsert([]string{"name"}, "version")), name, version)
+	return err
+}
+
+func checkMigrations(migrations []Migration) error {
+	for index, migration := range migrations {
+		if migration.Version != uint(index+1) {
+			return fmt.Errorf("migration %d has version %d", index+1, migration.Version)
+		}
+		if migration.Up == nil {
+			return fmt.Errorf("migration %d has no step", migration.Version)
+		}
+	}
+	return nil
+}
+
+// Migrate upgrades the table family to the last migration version.
+//
+// The migrations must be ordered and numbered from 1 without gap. Each migration is applied in
+// its own transaction with the version update, so a failing step leaves the tables in the state of
+// the previous version. Note that MySQL / MariaDB implicitly commits DDL statements, so a step
+// should not mix many schema changes when possible.
+func Migrate(db *sql.DB, dialect Dialect, name string, migrations []Migration) error {
+	// Check migration list
+	if err := checkMigrations(migrations); err != nil {
+		return fmt.Errorf("invalid %s migrations: %w", name, err)
+	}
+	latest := uint(len(migrations))
+
+	// Get current version
+	current, err := GetTableVersion(db, dialect, name)
+	if err != nil {
+		return fmt.Errorf("failed to get %s version: %w", name, err)
+	} else if current > latest {
+		return fmt.Errorf("%w: %s version %d > %d", ErrDatabaseTooNew, name, current, latest)
+	} else if current == latest {
+		return nil
+	}
+
+	// Apply migrations one by one
+	for _, migration := range migrations[current:] {
+		log.Infof("migrate %s tables: %d -> %d (%s)", name, migration.Version-1, migration.Version, migration.Description)
+		if err := applyMigration(db, dialect, name, migration); err != nil {
+			return fmt.Errorf("failed to migrate %s to version %d: %w", name, migration.Version, err)
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(db *sql.DB, dialect Dialect, name string, migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Check version has not been updated concurrently
+	version, err := GetTableVersion(tx, dialect, name)
+	if err != nil {
+		return err
+	} else if version >= migration.Version {
+		return nil
+	}
+
+	// Apply migration and update version
+	if err := migration.Up(tx); err != nil {
+		return err
+	}
+	if err := updateTableVersion(tx, dialect, name, migration.Version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
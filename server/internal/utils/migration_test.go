@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// Open a SQLite database with the version table in a temporary directory
+func openTestDatabase(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_pragma=foreign_keys(1)&_txlock=immediate")
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := InitializeVersionTable(db, SQLite); err != nil {
+		t.Fatalf("failed to create version table: %s", err)
+	}
+	return db
+}
+
+// Check a table exists
+func hasTable(t *testing.T, db *sql.DB, name string) bool {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count); err != nil {
+		t.Fatalf("failed to check table %s: %s", name, err)
+	}
+	return count > 0
+}
+
+func TestMigrate(t *testing.T) {
+	failure := errors.New("step failure")
+	steps := []Migration{
+		{Version: 1, Description: "create a", Up: Statements("CREATE TABLE a (id INTEGER)")},
+		{Version: 2, Description: "create b", Up: Statements("CREATE TABLE b (id INTEGER)")},
+		{Version: 3, Description: "create c", Up: Statements("CREATE TABLE c (id INTEGER)", "INSERT INTO c VALUES (1)")},
+	}
+
+	for _, test := range []struct {
+		name    string
+		initial []Migration
+		apply   []Migration
+		fails   bool
+		err     error
+		version uint
+		tables  []string
+	}{
+		{"create", nil, steps, false, nil, 3, []string{"a", "b", "c"}},
+		{"up to date", steps, steps, false, nil, 3, []string{"a", "b", "c"}},
+		{"upgrade", steps[:1], steps, false, nil, 3, []string{"a", "b", "c"}},
+		{"too new", steps, steps[:2], true, ErrDatabaseTooNew, 3, []string{"a", "b", "c"}},
+		{"no migration", nil, []Migration{}, false, nil, 0, nil},
+		{"version gap", nil, []Migration{steps[0], steps[2]}, true, nil, 0, nil},
+		{"missing step", nil, []Migration{{Version: 1, Description: "none"}}, true, nil, 0, nil},
+		{"failing step", nil, []Migration{steps[0], {Version: 2, Description: "fail", Up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec("CREATE TABLE b (id INTEGER)"); err != nil {
+				return err
+			}
+			return failure
+		}}}, true, failure, 1, []string{"a"}},
+		{"failing statement", nil, []Migration{steps[0], {Version: 2, Description: "fail", Up: Statements("CREATE TABLE b (id INTEGER)", "INVALID")}}, true, nil, 1, []string{"a"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			db := openTestDatabase(t)
+			if test.initial != nil {
+				if err := Migrate(db, SQLite, "test", test.initial); err != nil {
+					t.Fatalf("initial migration: %s", err)
+				}
+			}
+
+			// Migrate and check error
+			err := Migrate(db, SQLite, "test", test.apply)
+			if !test.fails && err != nil {
+				t.Fatalf("got %v, want no error", err)
+			} else if test.fails && err == nil {
+				t.Fatal("got no error, want an error")
+			} else if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+
+			// Check version and tables: a failing step leaves the previous version
+			if version, err := GetTableVersion(db, SQLite, "test"); err != nil || version != test.version {
+				t.Fatalf("got version %d, %v, want %d", version, err, test.version)
+			}
+			for _, table := range []string{"a", "b", "c"} {
+				want := false
+				for _, name := range test.tables {
+					want = want || name == table
+				}
+				if got := hasTable(t, db, table); got != want {
+					t.Fatalf("table %s exists: got %t, want %t", table, got, want)
+				}
+			}
+		})
+	}
+}
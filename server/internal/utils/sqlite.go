@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"net"
+
+	"modernc.org/sqlite"
+)
+
+// Register the MySQL IP conversion functions in SQLite, so the same queries can be used with both
+// databases. As in MySQL, NULL is returned when the value cannot be converted.
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("INET_ATON", 1, inetAton)
+	sqlite.MustRegisterDeterministicScalarFunction("INET_NTOA", 1, inetNtoa)
+	sqlite.MustRegisterDeterministicScalarFunction("INET6_ATON", 1, inet6Aton)
+	sqlite.MustRegisterDeterministicScalarFunction("INET6_NTOA", 1, inet6Ntoa)
+}
+
+func parseIpArg(arg driver.Value) net.IP {
+	switch value := arg.(type) {
+	case string:
+		return net.ParseIP(value)
+	case []byte:
+		return net.ParseIP(string(value))
+	}
+	return nil
+}
+
+func inetAton(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	ip := parseIpArg(args[0]).To4()
+	if ip == nil {
+		return nil, nil
+	}
+	return int64(binary.BigEndian.Uint32(ip)), nil
+}
+
+func inetNtoa(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	value, ok := args[0].(int64)
+	if !ok || value < 0 || value > 0xffffffff {
+		return nil, nil
+	}
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, uint32(value))
+	return ip.String(), nil
+}
+
+func inet6Aton(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	ip := parseIpArg(args[0])
+	if ip == nil {
+		return nil, nil
+	} else if ipv4 := ip.To4(); ipv4 != nil {
+		return []byte(ipv4), nil
+	}
+	return []byte(ip.To16()), nil
+}
+
+func inet6Ntoa(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	value, ok := args[0].([]byte)
+	if !ok || (len(value) != net.IPv4len && len(value) != net.IPv6len) {
+		return nil, nil
+	}
+	return net.IP(value).String(), nil
+}
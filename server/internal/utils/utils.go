@@ -3,6 +3,7 @@ package utils
 import (
 	"database/sql"
 	"net"
+	"strings"
 )
 
 func Uint64FromHwAddress(address string) uint64 {
@@ -26,31 +27,31 @@ func Uint64ToHwAddress(address uint64) string {
 	return hw_addr.String()
 }
 
-func InitializeVersionTable(db *sql.DB) error {
+// EscapeLike escapes the LIKE wildcards with '!' (to use with "ESCAPE '!'")
+func EscapeLike(value string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(value)
+}
+
+func InitializeVersionTable(db *sql.DB, dialect Dialect) error {
 	version := `CREATE TABLE IF NOT EXISTS version (
   name VARCHAR(32) NOT NULL,
   version SMALLINT(5) UNSIGNED NOT NULL,
   PRIMARY KEY (name)
 );`
+	switch dialect {
+	case SQLite:
+		version = `CREATE TABLE IF NOT EXISTS version (
+  name VARCHAR(32) NOT NULL,
+  version INTEGER NOT NULL,
+  PRIMARY KEY (name)
+);`
+	case PostgreSQL:
+		version = `CREATE TABLE IF NOT EXISTS version (
+  name VARCHAR(32) NOT NULL,
+  version SMALLINT NOT NULL,
+  PRIMARY KEY (name)
+);`
+	}
 	_, err := db.Exec(version)
 	return err
 }
-
-func GetTableVersion(db *sql.DB, name string) uint {
-	row := db.QueryRow("SELECT version FROM version WHERE name = ?", name)
-	if row == nil {
-		return 0
-	}
-
-	var version uint
-	if err := row.Scan(&version); err != nil {
-		return 0
-	}
-
-	return version
-}
-
-func UpdateTableVersion(db *sql.DB, name string, version uint) bool {
-	_, err := db.Exec("INSERT INTO version (name, version) VALUES(?, ?) ON DUPLICATE KEY UPDATE name=?, version=?", name, version, name, version)
-	return err == nil
-}
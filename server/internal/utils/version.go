@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version (see https://semver.org): MAJOR.MINOR.PATCH[-PRERELEASE][+BUILD]
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string
+	Build      string
+}
+
+// Parse a numeric version identifier (no leading zero)
+func parseVersionNumber(str string) (uint64, error) {
+	if str == "" || (len(str) > 1 && str[0] == '0') {
+		return 0, errors.New("invalid number")
+	}
+	return strconv.ParseUint(str, 10, 64)
+}
+
+// ParseVersion parses a semantic version
+func ParseVersion(str string) (Version, error) {
+	version := Version{}
+	invalid := fmt.Errorf("invalid version %q", str)
+
+	// Split build metadata and pre-release identifiers
+	rest, build, found := strings.Cut(str, "+")
+	if found && build == "" {
+		return version, invalid
+	}
+	version.Build = build
+	rest, prerelease, found := strings.Cut(rest, "-")
+	if found {
+		version.Prerelease = strings.Split(prerelease, ".")
+		for _, identifier := range version.Prerelease {
+			if identifier == "" {
+				return version, invalid
+			} else if _, err := strconv.ParseUint(identifier, 10, 64); err == nil && len(identifier) > 1 && identifier[0] == '0' {
+				return version, invalid
+			}
+		}
+	}
+
+	// Parse version core
+	numbers := strings.Split(rest, ".")
+	if len(numbers) != 3 {
+		return version, invalid
+	}
+	var err error
+	if version.Major, err = parseVersionNumber(numbers[0]); err != nil {
+		return version, invalid
+	}
+	if version.Minor, err = parseVersionNumber(numbers[1]); err != nil {
+		return version, invalid
+	}
+	if version.Patch, err = parseVersionNumber(numbers[2]); err != nil {
+		return version, invalid
+	}
+
+	return version, nil
+}
+
+func (v Version) String() string {
+	str := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		str += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if v.Build != "" {
+		str += "+" + v.Build
+	}
+	return str
+}
+
+// Compare returns -1, 0 or +1 when the version is older, equal or newer than the other one (the
+// build metadata is ignored, a pre-release is older than its release)
+func (v Version) Compare(other Version) int {
+	if c := cmp.Compare(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+
+	// Compare pre-release identifiers
+	if len(v.Prerelease) == 0 || len(other.Prerelease) == 0 {
+		return cmp.Compare(len(other.Prerelease), len(v.Prerelease))
+	}
+	for index := 0; index < len(v.Prerelease) && index < len(other.Prerelease); index++ {
+		a, b := v.Prerelease[index], other.Prerelease[index]
+		a_number, a_err := strconv.ParseUint(a, 10, 64)
+		b_number, b_err := strconv.ParseUint(b, 10, 64)
+		switch {
+		case a_err == nil && b_err == nil:
+			if c := cmp.Compare(a_number, b_number); c != 0 {
+				return c
+			}
+		case a_err == nil:
+			// Numeric identifiers have lower precedence
+			return -1
+		case b_err == nil:
+			return 1
+		default:
+			if c := strings.Compare(a, b); c != 0 {
+				return c
+			}
+		}
+	}
+	return cmp.Compare(len(v.Prerelease), len(other.Prerelease))
+}
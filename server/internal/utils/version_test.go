@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	for _, test := range []struct {
+		str   string
+		want  Version
+		valid bool
+	}{
+		{"1.2.3", Version{Major: 1, Minor: 2, Patch: 3}, true},
+		{"0.0.0", Version{}, true},
+		{"10.20.30", Version{Major: 10, Minor: 20, Patch: 30}, true},
+		{"1.0.0-alpha", Version{Major: 1, Prerelease: []string{"alpha"}}, true},
+		{"1.0.0-alpha.1", Version{Major: 1, Prerelease: []string{"alpha", "1"}}, true},
+		{"1.0.0-0.3.7", Version{Major: 1, Prerelease: []string{"0", "3", "7"}}, true},
+		{"1.0.0-x-y-z.--", Version{Major: 1, Prerelease: []string{"x-y-z", "--"}}, true},
+		{"1.0.0-rc.1+build.1", Version{Major: 1, Prerelease: []string{"rc", "1"}, Build: "build.1"}, true},
+		{"1.0.0+20130313144700", Version{Major: 1, Build: "20130313144700"}, true},
+		{"1.0.0-beta+exp.sha.5114f85", Version{Major: 1, Prerelease: []string{"beta"}, Build: "exp.sha.5114f85"}, true},
+		{"", Version{}, false},
+		{"1", Version{}, false},
+		{"1.2", Version{}, false},
+		{"1.2.3.4", Version{}, false},
+		{"v1.2.3", Version{}, false},
+		{"01.2.3", Version{}, false},
+		{"1.02.3", Version{}, false},
+		{"1.2.03", Version{}, false},
+		{"1.2.-3", Version{}, false},
+		{"1.2.3-", Version{}, false},
+		{"1.2.3-alpha..1", Version{}, false},
+		{"1.2.3-01", Version{}, false},
+		{"1.2.3+", Version{}, false},
+		{"1.2.3-alpha+", Version{}, false},
+		{"1.2.x", Version{}, false},
+		{"99999999999999999999.0.0", Version{}, false},
+	} {
+		t.Run(test.str, func(t *testing.T) {
+			got, err := ParseVersion(test.str)
+			if !test.valid && err == nil {
+				t.Fatalf("got %+v, want an error", got)
+			} else if test.valid && (err != nil || !reflect.DeepEqual(got, test.want)) {
+				t.Fatalf("got %+v, %v, want %+v", got, err, test.want)
+			} else if test.valid && got.String() != test.str {
+				t.Fatalf("got string %q, want %q", got.String(), test.str)
+			}
+		})
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	// Precedence example of the specification, then build metadata and numeric identifiers
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.1.0", "1.10.0", "2.0.0"}
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := ParseVersion(ordered[i])
+			b, _ := ParseVersion(ordered[j])
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got := a.Compare(b); got != want {
+				t.Errorf("%s compared to %s: got %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+
+	for _, test := range []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{"1.0.0+build.1", "1.0.0+build.2", 0},
+		{"1.0.0-rc.1+build", "1.0.0-rc.1", 0},
+		{"1.0.0-2", "1.0.0-10", -1},
+		{"1.0.0-10", "1.0.0-alpha", -1},
+	} {
+		a, _ := ParseVersion(test.a)
+		b, _ := ParseVersion(test.b)
+		if got := a.Compare(b); got != test.want {
+			t.Errorf("%s compared to %s: got %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
@@ -1,16 +1,31 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	// Internal
+	"github.com/dillya/melo-webapi/internal/admin"
+	"github.com/dillya/melo-webapi/internal/blob"
+	"github.com/dillya/melo-webapi/internal/config"
 	"github.com/dillya/melo-webapi/internal/device"
 	"github.com/dillya/melo-webapi/internal/discover_legacy"
+	"github.com/dillya/melo-webapi/internal/health"
+	"github.com/dillya/melo-webapi/internal/plugin"
+	"github.com/dillya/melo-webapi/internal/release"
+	"github.com/dillya/melo-webapi/internal/signing"
 	"github.com/dillya/melo-webapi/internal/utils"
+	"github.com/dillya/melo-webapi/internal/utils/middleware"
 
 	// REST / OpenAPI
 	"github.com/danielgtaylor/huma/v2"
@@ -18,81 +33,266 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
 
-	// Use MySQL as database
+	// Use MySQL or PostgreSQL as database
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
 
 	// Logs
 	log "github.com/sirupsen/logrus"
 )
 
-func initDatabaseTables(db *sql.DB) bool {
+func initDatabaseTables(db *sql.DB, dialect utils.Dialect) bool {
 	// Create Version table
-	if err := utils.InitializeVersionTable(db); err != nil {
+	if err := utils.InitializeVersionTable(db, dialect); err != nil {
 		log.Errorf("failed to initialize Version table: %s", err)
 		return false
 	}
 
 	// Create Device tables
-	if !device.InitializeTables(db) {
+	if !device.InitializeTables(db, dialect) {
 		log.Error("failed to initialize Device tables")
 		return false
 	}
 
+	// Create Release tables
+	if !release.InitializeTables(db, dialect) {
+		log.Error("failed to initialize Release tables")
+		return false
+	}
+
+	// Create Plugin tables
+	if !plugin.InitializeTables(db, dialect) {
+		log.Error("failed to initialize Plugin tables")
+		return false
+	}
+
 	return true
 }
 
-func main() {
-	// Get URL from environment
-	url := os.Getenv("MELO_WEBAPI_URL")
+func waitDatabase(db *sql.DB, retry_interval time.Duration) bool {
+	for {
+		err := db.Ping()
+		if err == nil {
+			return true
+		} else if !strings.Contains(err.Error(), "connection refused") {
+			log.Errorf("failed to ping database: %s", err)
+			return false
+		}
 
-	// Get MySQL login and database from environment
-	hostname := os.Getenv("MELO_WEBAPI_MYSQL_HOSTNAME")
-	user := os.Getenv("MELO_WEBAPI_MYSQL_USER")
-	password := os.Getenv("MELO_WEBAPI_MYSQL_PASSWORD")
-	db_name := os.Getenv("MELO_WEBAPI_MYSQL_DATABASE")
+		// Retry to connect
+		log.Error("failed to ping database: retry...")
+		time.Sleep(retry_interval)
+	}
+}
 
+func openDatabase(driver string, dsn string, cfg config.Database) *sql.DB {
 	// Create SQL connection
-	db, err := sql.Open("mysql", user+":"+password+"@tcp("+hostname+")/"+db_name)
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		log.WithFields(log.Fields{"error": err}).Error("failed to open database")
+		return nil
 	}
-	defer db.Close()
 
-	// Setup default database connections
-	db.SetConnMaxLifetime(time.Minute * 3)
-	db.SetMaxOpenConns(10)
-	db.SetMaxIdleConns(10)
+	// Setup database connections
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
 
 	// Try to connect to database
-	for {
-		err = db.Ping()
-		if err == nil {
-			break
-		} else if !strings.Contains(err.Error(), "connection refused") {
-			log.Errorf("failed to ping database: %s", err)
-			return
+	if !waitDatabase(db, cfg.RetryInterval) {
+		db.Close()
+		return nil
+	}
+
+	return db
+}
+
+func openMySQL(cfg config.Database) *sql.DB {
+	// Get MySQL login and database from configuration
+	dsn := cfg.Dsn
+	if dsn == "" {
+		dsn = cfg.Mysql.User + ":" + cfg.Mysql.Password + "@tcp(" + cfg.Mysql.Hostname + ")/" + cfg.Mysql.Database
+	}
+
+	return openDatabase("mysql", dsn, cfg)
+}
+
+func openPostgreSQL(cfg config.Database) *sql.DB {
+	// Get PostgreSQL login and database from configuration
+	dsn := cfg.Dsn
+	if dsn == "" {
+		dsn = (&url.URL{
+			Scheme: "postgres",
+			User:   url.UserPassword(cfg.Postgres.User, cfg.Postgres.Password),
+			Host:   cfg.Postgres.Hostname,
+			Path:   "/" + cfg.Postgres.Database,
+		}).String()
+	}
+
+	return openDatabase("pgx", dsn, cfg)
+}
+
+func openSQLite(cfg config.Database) *sql.DB {
+	// Get SQLite database from configuration (foreign keys are required for interfaces removal,
+	// concurrent writes wait for the lock instead of failing, and the transactions take the lock when
+	// started so a read-modify-write transaction cannot fail on commit)
+	dsn := cfg.Dsn
+	if dsn == "" {
+		dsn = "file:" + cfg.SqlitePath + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	}
+
+	// Create SQL connection
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("failed to open database")
+		return nil
+	}
+
+	// Check database can be accessed
+	if err := db.Ping(); err != nil {
+		log.Errorf("failed to open database: %s", err)
+		db.Close()
+		return nil
+	}
+
+	return db
+}
+
+func main() {
+	os.Exit(run())
+}
+
+// run starts the server until a termination signal and returns the exit code: the deferred cleanups
+// are executed before exiting.
+func run() int {
+	// Load configuration from file, environment and command line
+	cfg, print, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		log.Errorf("invalid configuration: %s", err)
+		return 2
+	}
+	if print {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Errorf("failed to print configuration: %s", err)
+			return 1
 		}
+		return 0
+	}
 
-		// Retry to connect
-		log.Error("failed to ping database: retry...")
-		time.Sleep(10 * time.Second)
+	// Open storage backend
+	var store device.DeviceStore
+	var releases release.ReleaseStore
+	var plugins plugin.PluginStore
+	var db *sql.DB
+	var dialect utils.Dialect
+	switch backend := cfg.Database.Backend; backend {
+	case "mysql":
+		db = openMySQL(cfg.Database)
+		dialect = utils.MySQL
+	case "sqlite":
+		db = openSQLite(cfg.Database)
+		dialect = utils.SQLite
+	case "postgres":
+		db = openPostgreSQL(cfg.Database)
+		dialect = utils.PostgreSQL
+	case "memory":
+		log.Warn("using in-memory storage: all data will be lost on exit")
+		store = device.NewMemoryStore()
+		releases = release.NewMemoryStore()
+		plugins = plugin.NewMemoryStore()
+	default:
+		log.Errorf("invalid storage backend: %s", backend)
+		return 1
 	}
 
 	// Initialize Database tables
-	if !initDatabaseTables(db) {
-		log.Error("failed to initialize tables")
-		return
+	if store == nil {
+		if db == nil {
+			return 1
+		}
+		defer db.Close()
+
+		if !initDatabaseTables(db, dialect) {
+			log.Error("failed to initialize tables")
+			return 1
+		}
+		store = device.NewSQLStore(db, dialect)
+		releases = release.NewSQLStore(db, dialect)
+		plugins = plugin.NewSQLStore(db, dialect)
 	}
 
+	// Open artifact storage
+	var blobs blob.BlobStore
+	switch cfg.Artifacts.Backend {
+	case "local":
+		blobs, err = blob.NewLocalStore(cfg.Artifacts.Path)
+	case "s3":
+		s3 := cfg.Artifacts.S3
+		blobs, err = blob.NewS3Store(s3.Endpoint, s3.Region, s3.Bucket, s3.AccessKey, s3.SecretKey)
+	}
+	if err != nil {
+		log.Errorf("failed to open artifact storage: %s", err)
+		return 1
+	}
+
+	// Publish device changes to event subscribers
+	broker := device.NewBroker()
+	store = device.NewEventStore(store, broker, cfg.Device.MergeWindow)
+
+	// Stop on interrupt / termination signal
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Start device reaper (stopped before the database is closed)
+	reaper_done := make(chan struct{})
+	go func() {
+		device.NewReaper(store, cfg.Device.HeartbeatTimeout, cfg.Device.OfflineRetention).Run(ctx)
+		close(reaper_done)
+	}()
+	defer func() { <-reaper_done }()
+
+	// Start update check reaper
+	check_reaper_done := make(chan struct{})
+	go func() {
+		release.NewReaper(releases, cfg.Release.CheckRetention).Run(ctx)
+		close(check_reaper_done)
+	}()
+	defer func() { <-check_reaper_done }()
+
 	// Setup API name / version
 	api_name := "Melo Web API"
 	api_version := "1.0.0"
 	log.Info(api_name + " " + api_version)
-	config := huma.DefaultConfig(api_name, api_version)
+	api_config := huma.DefaultConfig(api_name, api_version)
 
 	// Setup main URL
-	if url != "" {
-		config.Servers = []*huma.Server{{URL: url}}
+	if cfg.Url != "" {
+		api_config.Servers = []*huma.Server{{URL: cfg.Url}}
+	}
+
+	// Setup client IP extraction (trusted proxies are checked by the configuration)
+	proxies, _ := config.ParseNetworks(cfg.Http.TrustedProxies)
+	client_ip := middleware.IpConfig{
+		RealIpHeader:   cfg.Http.RealIpHeader,
+		TrustedProxies: proxies,
+		Ipv6Prefix:     cfg.Device.Ipv6Prefix,
+	}
+	if cfg.Http.RealIpHeader != "" && len(proxies) == 0 {
+		log.Warnf("no trusted proxies: %s header is ignored", cfg.Http.RealIpHeader)
+	}
+
+	// Setup signing keys (checked by the configuration)
+	key, _ := config.ParseSigningKey(cfg.Signing.Key)
+	previous_keys, _ := config.ParsePreviousKeys(cfg.Signing.PreviousKeys)
+	artifact_keys, _ := config.ParseArtifactKeys(cfg.Signing.ArtifactKeys)
+	signer := signing.NewSigner(key, previous_keys, artifact_keys)
+	if !signer.Enabled() {
+		log.Warn("no signing key: release manifest is disabled")
+	}
+	if !signer.VerifyEnabled() {
+		log.Warn("no artifact keys: artifact signatures are not verified")
 	}
 
 	// Create a new router & API.
@@ -100,20 +300,103 @@ func main() {
 
 	// Setup CORS
 	router.Use(cors.Handler(cors.Options{
-		AllowedOrigins: []string{"https://*", "http://*"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedOrigins: cfg.Http.CorsOrigins,
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "If-Match", "If-None-Match", "If-Range", "Range", "X-Api-Key"},
+		ExposedHeaders: []string{"Accept-Ranges", "Content-Range", "ETag", "Link", "Repr-Digest"},
 		MaxAge:         300,
 	}))
 
 	// Create API
-	api := humachi.New(router, config)
+	api := humachi.New(router, api_config)
 
 	// Register Device API
-	device.Register(api, db)
+	device.Register(api, store, broker, client_ip, cfg.Device.MergeWindow)
+	device.RegisterWebSocket(api, router, store, broker, client_ip)
+
+	// Register Plugin API
+	plugin.Register(api, plugins)
+
+	// Register Release API
+	release.Register(api, releases, store, client_ip, signer, cfg.Signing.ManifestTtl)
+
+	// Register Signing API
+	signing.Register(api, signer)
+
+	// Register Download API
+	blob.Register(api, blobs)
+
+	// Register Admin API
+	admin.Register(api, store, releases, plugins, blobs, signer, client_ip, cfg.AdminKey)
 
 	// Register deprecated Discover API
-	discover_legacy.Register(api, db)
+	discover_legacy.Register(api, store, client_ip)
 
-	// Start the server
-	http.ListenAndServe("0.0.0.0:8888", router)
+	// Register health probes
+	var shutting_down atomic.Bool
+	checks := map[string]health.Check{
+		"server": func(ctx context.Context) error {
+			if shutting_down.Load() {
+				return errors.New("server is shutting down")
+			}
+			return nil
+		},
+	}
+	if db != nil {
+		checks["database"] = func(ctx context.Context) error {
+			if err := db.PingContext(ctx); err != nil {
+				return err
+			}
+			if err := device.CheckTables(db, dialect); err != nil {
+				return err
+			}
+			if err := release.CheckTables(db, dialect); err != nil {
+				return err
+			}
+			return plugin.CheckTables(db, dialect)
+		}
+	}
+	if blobs != nil {
+		checks["artifacts"] = blobs.Ping
+	}
+	health.Register(api, checks)
+
+	// Start the server (event streams are closed on shutdown since they never end)
+	server := &http.Server{Addr: cfg.Http.Listen, Handler: router}
+	server.RegisterOnShutdown(broker.Close)
+	server_err := make(chan error, 1)
+	go func() {
+		server_err <- server.ListenAndServe()
+	}()
+
+	// Wait for signal or server failure
+	select {
+	case <-ctx.Done():
+	case err := <-server_err:
+		log.Errorf("failed to start server: %s", err)
+		stop()
+		return 1
+	}
+
+	// Restore default behavior: a second signal kills the server
+	stop()
+
+	// Fail the readiness probe, and keep serving until the load balancers stop sending requests
+	shutting_down.Store(true)
+	if cfg.Http.DrainPeriod > 0 {
+		log.Infof("shutting down: not ready, waiting %s before closing the listener", cfg.Http.DrainPeriod)
+		time.Sleep(cfg.Http.DrainPeriod)
+	}
+
+	// Drain in-flight requests
+	log.Infof("shutting down: waiting %s for in-flight requests", cfg.Http.ShutdownTimeout)
+	shutdown_ctx, cancel := context.WithTimeout(context.Background(), cfg.Http.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdown_ctx); err != nil {
+		log.Errorf("failed to drain connections: %s", err)
+		server.Close()
+		return 1
+	}
+	log.Info("server stopped")
+	return 0
 }